@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/chambridge/cost-metrics-aggregator/internal/config"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"net/http"
+	"time"
+)
+
+// RebuildSummariesRequest selects the summaries to rebuild: the calendar days start_date to
+// end_date, inclusive, of every cluster or only of cluster_id
+type RebuildSummariesRequest struct {
+	StartDate string `json:"start_date" binding:"required"`
+	EndDate   string `json:"end_date"`
+	ClusterID string `json:"cluster_id"`
+}
+
+// VerifySummariesRequest selects the summaries to verify like RebuildSummariesRequest;
+// with repair, mismatched summaries are rebuilt
+type VerifySummariesRequest struct {
+	RebuildSummariesRequest
+	Repair bool `json:"repair"`
+}
+
+// summaryRange parses the range of a summaries request, responding with 400 when it is invalid
+func summaryRange(c *gin.Context, req RebuildSummariesRequest) (start, end time.Time, clusterID uuid.UUID, ok bool) {
+	start, err := time.Parse("2006-01-02", req.StartDate)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date: " + err.Error()})
+		return
+	}
+	end = start
+	if req.EndDate != "" {
+		end, err = time.Parse("2006-01-02", req.EndDate)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date: " + err.Error()})
+			return
+		}
+	}
+	if end.Before(start) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "end_date must not be before start_date"})
+		return
+	}
+
+	if req.ClusterID != "" {
+		clusterID, err = uuid.Parse(req.ClusterID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster_id: " + err.Error()})
+			return
+		}
+	}
+	return start, end, clusterID, true
+}
+
+// summaryRepository is a Repository that derives summaries with the configured rounding and timezone
+func summaryRepository(database *pgxpool.Pool, cfg *config.Config) (*db.Repository, error) {
+	loc, err := queryLocation("", cfg)
+	if err != nil {
+		return nil, err
+	}
+	return db.NewRepository(database).WithHoursRounding(cfg.HoursRounding).WithReportingLocation(loc), nil
+}
+
+// RebuildSummariesHandler handles the /api/admin/v1/summaries/rebuild endpoint, recomputing
+// the daily summaries of a date range from the raw metrics after re-attributing pods to
+// components with the current rules. The affected rows are replaced in a single
+// transaction; the response counts the pods and rows written.
+func RebuildSummariesHandler(database *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RebuildSummariesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+		start, end, clusterID, ok := summaryRange(c, req)
+		if !ok {
+			return
+		}
+
+		repo, err := summaryRepository(database, cfg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid reporting timezone: " + err.Error()})
+			return
+		}
+		rules, err := repo.ResolveComponentRules(cfg.ComponentRulesFile)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to load component rules: " + err.Error()})
+			return
+		}
+		result, err := repo.WithComponentRules(rules).RebuildDailySummaries(c.Request.Context(), start, end, clusterID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to rebuild summaries: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"start_date": start.Format("2006-01-02"),
+			"end_date":   end.Format("2006-01-02"),
+			"cluster_id": req.ClusterID,
+			"rebuilt":    result,
+		})
+	}
+}
+
+// VerifySummariesHandler handles the /api/admin/v1/summaries/verify endpoint, comparing the
+// daily summaries of a date range with aggregates recomputed from the raw metrics. The
+// response lists every mismatched summary, cluster and day, and whether they were repaired.
+func VerifySummariesHandler(database *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req VerifySummariesRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+		start, end, clusterID, ok := summaryRange(c, req.RebuildSummariesRequest)
+		if !ok {
+			return
+		}
+
+		repo, err := summaryRepository(database, cfg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Invalid reporting timezone: " + err.Error()})
+			return
+		}
+		mismatches, err := repo.VerifyDailySummaries(c.Request.Context(), start, end, clusterID, req.Repair)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to verify summaries: " + err.Error()})
+			return
+		}
+		if mismatches == nil {
+			mismatches = []db.SummaryMismatch{}
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"start_date": start.Format("2006-01-02"),
+			"end_date":   end.Format("2006-01-02"),
+			"cluster_id": req.ClusterID,
+			"mismatches": mismatches,
+			"repaired":   req.Repair && len(mismatches) > 0,
+		})
+	}
+}
+
+// ClusterTimezoneRequest is the IANA timezone a cluster's samples are bucketed into days in;
+// an empty timezone falls back to REPORTING_TIMEZONE
+type ClusterTimezoneRequest struct {
+	Timezone *string `json:"timezone" binding:"required"`
+}
+
+// SetClusterTimezoneHandler handles PUT /api/admin/v1/clusters/:id/timezone. Summaries
+// already built keep their days until they are rebuilt.
+func SetClusterTimezoneHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clusterID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster id: " + err.Error()})
+			return
+		}
+		var req ClusterTimezoneRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+			return
+		}
+
+		err = db.NewRepository(database).SetClusterTimezone(clusterID, *req.Timezone)
+		switch {
+		case errors.Is(err, db.ErrInvalidTimezone):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		case errors.Is(err, db.ErrClusterNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		case err != nil:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to set cluster timezone: " + err.Error()})
+		default:
+			c.JSON(http.StatusOK, gin.H{"cluster_id": clusterID, "timezone": *req.Timezone})
+		}
+	}
+}
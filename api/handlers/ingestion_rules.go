@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// IngestionRuleRequest is the body of a rule to create or update; rules without a
+// cluster_id apply to every cluster and pattern_type defaults to glob
+type IngestionRuleRequest struct {
+	ClusterID   string `json:"cluster_id"`
+	Action      string `json:"action" binding:"required"`
+	Field       string `json:"field" binding:"required"`
+	Pattern     string `json:"pattern" binding:"required"`
+	PatternType string `json:"pattern_type"`
+}
+
+// ingestionRule reads and validates a rule from the request body, responding with 400
+// when it is invalid
+func ingestionRule(c *gin.Context) (*ingestrules.Rule, bool) {
+	var req IngestionRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request: " + err.Error()})
+		return nil, false
+	}
+	rule := &ingestrules.Rule{Action: req.Action, Field: req.Field, Pattern: req.Pattern, PatternType: req.PatternType}
+	if req.ClusterID != "" {
+		clusterID, err := uuid.Parse(req.ClusterID)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster_id: " + err.Error()})
+			return nil, false
+		}
+		rule.ClusterID = &clusterID
+	}
+	if err := rule.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule: " + err.Error()})
+		return nil, false
+	}
+	return rule, true
+}
+
+// ingestionRuleID parses the id path parameter, responding with 400 when it is invalid
+func ingestionRuleID(c *gin.Context) (uuid.UUID, bool) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid rule id: " + err.Error()})
+		return uuid.Nil, false
+	}
+	return id, true
+}
+
+// ingestionRuleFailed responds to a failed rule operation: 404 for an unknown rule, 400 for
+// an unknown cluster and 500 otherwise
+func ingestionRuleFailed(c *gin.Context, err error) {
+	switch {
+	case errors.Is(err, db.ErrIngestionRuleNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+	case errors.Is(err, db.ErrClusterNotFound):
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster_id: " + err.Error()})
+	default:
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to store ingestion rule: " + err.Error()})
+	}
+}
+
+// ListIngestionRulesHandler handles GET /api/admin/v1/ingestion-rules, listing every rule,
+// or with cluster_id the global rules and those of that cluster
+func ListIngestionRulesHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		clusterID := uuid.Nil
+		if value := c.Query("cluster_id"); value != "" {
+			var err error
+			if clusterID, err = uuid.Parse(value); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster_id: " + err.Error()})
+				return
+			}
+		}
+
+		rules, err := db.NewRepository(database).IngestionRules(clusterID)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query ingestion rules: " + err.Error()})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"data": rules})
+	}
+}
+
+// GetIngestionRuleHandler handles GET /api/admin/v1/ingestion-rules/:id
+func GetIngestionRuleHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := ingestionRuleID(c)
+		if !ok {
+			return
+		}
+		rule, err := db.NewRepository(database).GetIngestionRule(id)
+		if err != nil {
+			ingestionRuleFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// CreateIngestionRuleHandler handles POST /api/admin/v1/ingestion-rules. The rule applies
+// to uploads processed from then on; data already ingested is not changed.
+func CreateIngestionRuleHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		rule, ok := ingestionRule(c)
+		if !ok {
+			return
+		}
+		if err := db.NewRepository(database).CreateIngestionRule(rule); err != nil {
+			ingestionRuleFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusCreated, rule)
+	}
+}
+
+// UpdateIngestionRuleHandler handles PUT /api/admin/v1/ingestion-rules/:id, replacing the rule
+func UpdateIngestionRuleHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := ingestionRuleID(c)
+		if !ok {
+			return
+		}
+		rule, ok := ingestionRule(c)
+		if !ok {
+			return
+		}
+		rule.ID = id
+		if err := db.NewRepository(database).UpdateIngestionRule(rule); err != nil {
+			ingestionRuleFailed(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, rule)
+	}
+}
+
+// DeleteIngestionRuleHandler handles DELETE /api/admin/v1/ingestion-rules/:id
+func DeleteIngestionRuleHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := ingestionRuleID(c)
+		if !ok {
+			return
+		}
+		if err := db.NewRepository(database).DeleteIngestionRule(id); err != nil {
+			ingestionRuleFailed(c, err)
+			return
+		}
+		c.Status(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// PodHistoryHandler handles GET /api/metrics/v1/pods/:id/history, returning when a pod was
+// first and last seen and the nodes it ran on, each with the usage the pod reported there.
+// The pod ID is the PodID of the pod metrics endpoint.
+func PodHistoryHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		podID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid pod id: " + err.Error()})
+			return
+		}
+
+		history, err := db.NewRepository(database).PodHistory(podID)
+		if errors.Is(err, db.ErrPodNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pod history: " + err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusOK, history)
+	}
+}
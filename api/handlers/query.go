@@ -4,10 +4,14 @@ import (
 	"bytes"
 	"encoding/csv"
 	"fmt"
+	"github.com/chambridge/cost-metrics-aggregator/internal/config"
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/selector"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -17,6 +21,7 @@ type NodeMetricsQueryParams struct {
 	ClusterID   string `form:"cluster_id"`
 	ClusterName string `form:"cluster_name"`
 	NodeType    string `form:"node_type"`
+	TZ          string `form:"tz"`
 	Limit       int    `form:"limit,default=100"`
 	Offset      int    `form:"offset,default=0"`
 }
@@ -29,12 +34,69 @@ type PodMetricsQueryParams struct {
 	Namespace   string `form:"namespace"`
 	PodName     string `form:"pod_name"`
 	Component   string `form:"component"`
-	Limit       int    `form:"limit,default=100"`
-	Offset      int    `form:"offset,default=0"`
+	// LabelSelector filters pods by label in Kubernetes selector syntax, e.g. env=prod,team in (a,b),!canary
+	LabelSelector string `form:"label_selector"`
+	// GroupBy aggregates the pods of each day by a label value, as label:<key>, or rolls up
+	// the incarnations of each pod name, as pod
+	GroupBy string `form:"group_by"`
+	TZ      string `form:"tz"`
+	Limit   int    `form:"limit,default=100"`
+	Offset  int    `form:"offset,default=0"`
+}
+
+type StorageMetricsQueryParams struct {
+	StartDate    string `form:"start_date"`
+	EndDate      string `form:"end_date"`
+	ClusterID    string `form:"cluster_id"`
+	ClusterName  string `form:"cluster_name"`
+	Namespace    string `form:"namespace"`
+	StorageClass string `form:"storageclass"`
+	TZ           string `form:"tz"`
+	Limit        int    `form:"limit,default=100"`
+	Offset       int    `form:"offset,default=0"`
+}
+
+// queryLocation is the timezone date filters are read in: the tz query parameter when
+// given and the configured reporting timezone otherwise
+func queryLocation(tz string, cfg *config.Config) (*time.Location, error) {
+	if tz == "" {
+		tz = cfg.ReportingTimezone
+	}
+	if tz == "Local" {
+		return nil, fmt.Errorf("unknown time zone %s", tz)
+	}
+	return time.LoadLocation(tz)
+}
+
+// parseQueryDate reads a date filter. A date such as 2025-05-17 is the calendar day itself,
+// while a timestamp such as 2025-05-17T02:00:00Z is shifted to the day it falls on in loc.
+// Either way the result is matched against the days the summaries were built with, those of
+// each cluster's reporting timezone: loc only picks the day and never re-buckets summaries.
+func parseQueryDate(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return db.ReportingDate(t, loc), nil
+	}
+	return time.Parse("2006-01-02", value)
+}
+
+// podLabelKeys are the label keys a pod needs one of to be queried, from the comma
+// separated POD_LABEL_KEYS; none, or *, includes every pod
+func podLabelKeys(cfg *config.Config) []string {
+	var keys []string
+	for _, key := range strings.Split(cfg.PodLabelKeys, ",") {
+		key = strings.TrimSpace(key)
+		if key == "*" {
+			return nil
+		}
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
 }
 
 // QueryNodeMetricsHandler handles the /api/metrics/v1/nodes endpoint, querying node_daily_summary
-func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
+func QueryNodeMetricsHandler(database *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var params NodeMetricsQueryParams
 		if err := c.ShouldBindQuery(&params); err != nil {
@@ -52,15 +114,20 @@ func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		// Set default dates: start_date = beginning of current month, end_date = current day
-		now := time.Now().UTC()
+		loc, err := queryLocation(params.TZ, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tz: " + err.Error()})
+			return
+		}
+
+		// Set default dates in the query's timezone: start_date = beginning of current month, end_date = current day
+		now := time.Now().In(loc)
 		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-		end := now.Truncate(24 * time.Hour)
+		end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 
 		// Parse start_date if provided
 		if params.StartDate != "" {
-			var err error
-			start, err = time.Parse("2006-01-02", params.StartDate)
+			start, err = parseQueryDate(params.StartDate, loc)
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date: " + err.Error()})
 				return
@@ -69,8 +136,7 @@ func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 
 		// Parse end_date if provided
 		if params.EndDate != "" {
-			var err error
-			end, err = time.Parse("2006-01-02", params.EndDate)
+			end, err = parseQueryDate(params.EndDate, loc)
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date: " + err.Error()})
 				return
@@ -91,7 +157,7 @@ func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 			writer := csv.NewWriter(&buf)
 
 			// Write CSV header
-			header := []string{"Date", "ClusterID", "ClusterName", "NodeName", "NodeIdentifier", "NodeType", "CoreCount", "TotalHours"}
+			header := []string{"Date", "ClusterID", "ClusterName", "NodeName", "NodeIdentifier", "NodeType", "CoreCount", "MemoryBytes", "TotalHours"}
 			if err := writer.Write(header); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
 				return
@@ -107,7 +173,8 @@ func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 					metric.NodeIdentifier,
 					metric.NodeType,
 					fmt.Sprintf("%d", metric.CoreCount),
-					fmt.Sprintf("%d", metric.TotalHours),
+					fmt.Sprintf("%d", metric.MemoryBytes),
+					strconv.FormatFloat(metric.TotalHours, 'f', -1, 64),
 				}
 				if err := writer.Write(row); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
@@ -139,8 +206,26 @@ func QueryNodeMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 	}
 }
 
-// QueryPodMetricsHandler handles the /api/metrics/v1/pods endpoint, querying pod_daily_summary
-func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
+// groupByPod is the pods group_by value rolling up the incarnations of each pod name
+const groupByPod = "pod"
+
+// podGroupLabel is the label key, in the operator's form, of a pods group_by parameter of
+// the form label:<key>, or empty when the pods are not grouped by label
+func podGroupLabel(groupBy string) (string, error) {
+	if groupBy == "" || groupBy == groupByPod {
+		return "", nil
+	}
+	key, ok := strings.CutPrefix(groupBy, "label:")
+	if !ok || strings.TrimSpace(key) == "" {
+		return "", fmt.Errorf("must be pod or label:<key>")
+	}
+	return selector.OperatorKey(strings.TrimSpace(key)), nil
+}
+
+// QueryPodMetricsHandler handles the /api/metrics/v1/pods endpoint, querying pod_daily_summary.
+// With group_by=label:<key>, each day's pods are aggregated by the value of that label, and
+// with group_by=pod, the incarnations of each pod name are rolled up.
+func QueryPodMetricsHandler(database *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var params PodMetricsQueryParams
 		if err := c.ShouldBindQuery(&params); err != nil {
@@ -158,15 +243,20 @@ func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 			return
 		}
 
-		// Set default dates: start_date = beginning of current month, end_date = current day
-		now := time.Now().UTC()
+		loc, err := queryLocation(params.TZ, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tz: " + err.Error()})
+			return
+		}
+
+		// Set default dates in the query's timezone: start_date = beginning of current month, end_date = current day
+		now := time.Now().In(loc)
 		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
-		end := now.Truncate(24 * time.Hour)
+		end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
 
 		// Parse start_date if provided
 		if params.StartDate != "" {
-			var err error
-			start, err = time.Parse("2006-01-02", params.StartDate)
+			start, err = parseQueryDate(params.StartDate, loc)
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date: " + err.Error()})
 				return
@@ -175,16 +265,34 @@ func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 
 		// Parse end_date if provided
 		if params.EndDate != "" {
-			var err error
-			end, err = time.Parse("2006-01-02", params.EndDate)
+			end, err = parseQueryDate(params.EndDate, loc)
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date: " + err.Error()})
 				return
 			}
 		}
 
+		sel, err := selector.Parse(params.LabelSelector)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid label_selector: " + err.Error()})
+			return
+		}
+		groupLabel, err := podGroupLabel(params.GroupBy)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid group_by: " + err.Error()})
+			return
+		}
+
 		repo := db.NewRepository(database)
-		podMetrics, total, err := repo.QueryPodMetrics(start, end, params.ClusterID, params.ClusterName, params.Namespace, params.PodName, params.Component, params.Limit, params.Offset)
+		if groupLabel != "" {
+			queryPodLabelGroups(c, repo, params, start, end, podLabelKeys(cfg), sel, groupLabel)
+			return
+		}
+		if params.GroupBy == groupByPod {
+			queryPodIncarnations(c, repo, params, start, end, podLabelKeys(cfg), sel)
+			return
+		}
+		podMetrics, total, err := repo.QueryPodMetrics(start, end, params.ClusterID, params.ClusterName, params.Namespace, params.PodName, params.Component, podLabelKeys(cfg), sel, params.Limit, params.Offset)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pod metrics: " + err.Error()})
 			return
@@ -197,7 +305,7 @@ func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 			writer := csv.NewWriter(&buf)
 
 			// Write CSV header
-			header := []string{"Date", "MaxCoresUsed", "TotalPodEffectiveCoreSeconds", "TotalHours", "ClusterID", "ClusterName", "Namespace", "PodName", "Component"}
+			header := []string{"Date", "MaxCoresUsed", "TotalPodEffectiveCoreSeconds", "TotalPodEffectiveMemoryByteSeconds", "TotalPodEffectiveMemoryGiBHours", "TotalHours", "ClusterID", "ClusterName", "Namespace", "PodName", "Component", "Product", "PodID", "PodUID"}
 			if err := writer.Write(header); err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
 				return
@@ -209,12 +317,17 @@ func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 					metric.Date.Format("2006-01-02"),
 					fmt.Sprintf("%.2f", metric.MaxCoresUsed),
 					fmt.Sprintf("%.2f", metric.TotalPodEffectiveCoreSeconds),
-					fmt.Sprintf("%d", metric.TotalHours),
+					fmt.Sprintf("%.0f", metric.TotalPodEffectiveMemoryByteSeconds),
+					fmt.Sprintf("%.4f", metric.TotalPodEffectiveMemoryGiBHours),
+					strconv.FormatFloat(metric.TotalHours, 'f', -1, 64),
 					metric.ClusterID.String(),
 					metric.ClusterName,
 					metric.Namespace,
 					metric.PodName,
 					metric.Component,
+					metric.Product,
+					metric.PodID.String(),
+					metric.PodUID,
 				}
 				if err := writer.Write(row); err != nil {
 					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
@@ -245,3 +358,236 @@ func QueryPodMetricsHandler(database *pgxpool.Pool) gin.HandlerFunc {
 		})
 	}
 }
+
+// queryPodLabelGroups responds with the pod metrics of each day grouped by a label value
+func queryPodLabelGroups(c *gin.Context, repo *db.Repository, params PodMetricsQueryParams, start, end time.Time, labelKeys []string, sel selector.Selector, groupLabel string) {
+	groups, total, err := repo.QueryPodMetricsByLabel(start, end, params.ClusterID, params.ClusterName, params.Namespace, params.PodName, params.Component, labelKeys, sel, groupLabel, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pod metrics: " + err.Error()})
+		return
+	}
+
+	if c.GetHeader("Accept") == "text/csv" {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		header := []string{"Date", "LabelKey", "LabelValue", "Pods", "TotalPodEffectiveCoreSeconds", "TotalPodEffectiveMemoryByteSeconds", "TotalPodEffectiveMemoryGiBHours", "TotalHours"}
+		if err := writer.Write(header); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
+			return
+		}
+		for _, group := range groups {
+			var value string
+			if group.LabelValue != nil {
+				value = *group.LabelValue
+			}
+			row := []string{
+				group.Date.Format("2006-01-02"),
+				group.LabelKey,
+				value,
+				strconv.Itoa(group.Pods),
+				fmt.Sprintf("%.2f", group.TotalPodEffectiveCoreSeconds),
+				fmt.Sprintf("%.0f", group.TotalPodEffectiveMemoryByteSeconds),
+				fmt.Sprintf("%.4f", group.TotalPodEffectiveMemoryGiBHours),
+				strconv.FormatFloat(group.TotalHours, 'f', -1, 64),
+			}
+			if err := writer.Write(row); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
+				return
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flush CSV: " + err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment;filename=pod_metrics.csv")
+		c.String(http.StatusOK, buf.String())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metadata": gin.H{
+			"total":    total,
+			"limit":    params.Limit,
+			"offset":   params.Offset,
+			"group_by": params.GroupBy,
+		},
+		"data": groups,
+	})
+}
+
+// queryPodIncarnations responds with the pod metrics of each day rolled up by pod name,
+// adding together the incarnations of pods recreated under the same name
+func queryPodIncarnations(c *gin.Context, repo *db.Repository, params PodMetricsQueryParams, start, end time.Time, labelKeys []string, sel selector.Selector) {
+	pods, total, err := repo.QueryPodMetricsByName(start, end, params.ClusterID, params.ClusterName, params.Namespace, params.PodName, params.Component, labelKeys, sel, params.Limit, params.Offset)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query pod metrics: " + err.Error()})
+		return
+	}
+
+	if c.GetHeader("Accept") == "text/csv" {
+		var buf bytes.Buffer
+		writer := csv.NewWriter(&buf)
+		header := []string{"Date", "ClusterID", "ClusterName", "Namespace", "PodName", "Incarnations", "MaxCoresUsed", "TotalPodEffectiveCoreSeconds", "TotalPodEffectiveMemoryByteSeconds", "TotalPodEffectiveMemoryGiBHours", "TotalHours"}
+		if err := writer.Write(header); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
+			return
+		}
+		for _, pod := range pods {
+			row := []string{
+				pod.Date.Format("2006-01-02"),
+				pod.ClusterID.String(),
+				pod.ClusterName,
+				pod.Namespace,
+				pod.PodName,
+				strconv.Itoa(pod.Incarnations),
+				fmt.Sprintf("%.2f", pod.MaxCoresUsed),
+				fmt.Sprintf("%.2f", pod.TotalPodEffectiveCoreSeconds),
+				fmt.Sprintf("%.0f", pod.TotalPodEffectiveMemoryByteSeconds),
+				fmt.Sprintf("%.4f", pod.TotalPodEffectiveMemoryGiBHours),
+				strconv.FormatFloat(pod.TotalHours, 'f', -1, 64),
+			}
+			if err := writer.Write(row); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
+				return
+			}
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flush CSV: " + err.Error()})
+			return
+		}
+
+		c.Header("Content-Type", "text/csv")
+		c.Header("Content-Disposition", "attachment;filename=pod_metrics.csv")
+		c.String(http.StatusOK, buf.String())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"metadata": gin.H{
+			"total":    total,
+			"limit":    params.Limit,
+			"offset":   params.Offset,
+			"group_by": params.GroupBy,
+		},
+		"data": pods,
+	})
+}
+
+// QueryStorageMetricsHandler handles the /api/metrics/v1/storage endpoint, querying storage_daily_summary
+func QueryStorageMetricsHandler(database *pgxpool.Pool, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var params StorageMetricsQueryParams
+		if err := c.ShouldBindQuery(&params); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
+			return
+		}
+
+		// Validate limit
+		if params.Limit <= 0 || params.Limit > 1000 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Limit must be between 1 and 1000"})
+			return
+		}
+		if params.Offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Offset must be non-negative"})
+			return
+		}
+
+		loc, err := queryLocation(params.TZ, cfg)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tz: " + err.Error()})
+			return
+		}
+
+		// Set default dates in the query's timezone: start_date = beginning of current month, end_date = current day
+		now := time.Now().In(loc)
+		start := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+		// Parse start_date if provided
+		if params.StartDate != "" {
+			start, err = parseQueryDate(params.StartDate, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start_date: " + err.Error()})
+				return
+			}
+		}
+
+		// Parse end_date if provided
+		if params.EndDate != "" {
+			end, err = parseQueryDate(params.EndDate, loc)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end_date: " + err.Error()})
+				return
+			}
+		}
+
+		repo := db.NewRepository(database)
+		storageMetrics, total, err := repo.QueryStorageMetrics(start, end, params.ClusterID, params.ClusterName, params.Namespace, params.StorageClass, params.Limit, params.Offset)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query storage metrics: " + err.Error()})
+			return
+		}
+
+		// Check Accept header
+		accept := c.GetHeader("Accept")
+		if accept == "text/csv" {
+			var buf bytes.Buffer
+			writer := csv.NewWriter(&buf)
+
+			// Write CSV header
+			header := []string{"Date", "ClusterID", "ClusterName", "Namespace", "PersistentVolumeClaim", "PersistentVolume", "StorageClass",
+				"CapacityBytes", "TotalCapacityByteSeconds", "TotalRequestByteSeconds", "TotalUsageByteSeconds", "TotalHours"}
+			if err := writer.Write(header); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV header: " + err.Error()})
+				return
+			}
+
+			// Write CSV rows
+			for _, metric := range storageMetrics {
+				row := []string{
+					metric.Date.Format("2006-01-02"),
+					metric.ClusterID.String(),
+					metric.ClusterName,
+					metric.Namespace,
+					metric.PersistentVolumeClaim,
+					metric.PersistentVolume,
+					metric.StorageClass,
+					fmt.Sprintf("%d", metric.CapacityBytes),
+					fmt.Sprintf("%.0f", metric.TotalCapacityByteSeconds),
+					fmt.Sprintf("%.0f", metric.TotalRequestByteSeconds),
+					fmt.Sprintf("%.0f", metric.TotalUsageByteSeconds),
+					strconv.FormatFloat(metric.TotalHours, 'f', -1, 64),
+				}
+				if err := writer.Write(row); err != nil {
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write CSV row: " + err.Error()})
+					return
+				}
+			}
+
+			writer.Flush()
+			if err := writer.Error(); err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to flush CSV: " + err.Error()})
+				return
+			}
+
+			c.Header("Content-Type", "text/csv")
+			c.Header("Content-Disposition", "attachment;filename=storage_metrics.csv")
+			c.String(http.StatusOK, buf.String())
+			return
+		}
+
+		// JSON response with metadata
+		c.JSON(http.StatusOK, gin.H{
+			"metadata": gin.H{
+				"total":  total,
+				"limit":  params.Limit,
+				"offset": params.Offset,
+			},
+			"data": storageMetrics,
+		})
+	}
+}
@@ -1,9 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
+	"github.com/chambridge/cost-metrics-aggregator/internal/config"
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingest"
 	"github.com/chambridge/cost-metrics-aggregator/internal/processor"
 	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"io"
 	"net/http"
@@ -11,43 +16,140 @@ import (
 	"path/filepath"
 )
 
-func UploadHandler(database *pgxpool.Pool) gin.HandlerFunc {
+// UploadHandler saves an upload and queues it for background processing. The upload may be
+// an operator archive (tar.gz, tar.zst or zip) or a plain or gzipped CSV; uploads without a
+// manifest.json take their cluster from the cluster_id and cluster_name form parameters.
+// Uploads larger than MAX_UPLOAD_BYTES are rejected with 413, and uploads that are not
+// readable archives, start with an unsafe entry or are single CSVs without a cluster_id
+// with 400, before anything is queued. The decompression limits are enforced by the worker.
+func UploadHandler(database *pgxpool.Pool, cfg *config.Config, workers *ingest.WorkerPool) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		file, _, err := c.Request.FormFile("file")
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, cfg.MaxUploadBytes)
+		file, header, err := c.Request.FormFile("file")
 		if err != nil {
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": fmt.Sprintf("Upload exceeds the maximum size of %d bytes", maxBytesErr.Limit)})
+				return
+			}
 			c.JSON(http.StatusBadRequest, gin.H{"error": "File upload failed: " + err.Error()})
 			return
 		}
 		defer file.Close()
 
-		tempDir, err := os.MkdirTemp("", "upload")
+		clusterID := c.PostForm("cluster_id")
+		if clusterID != "" {
+			if _, err := uuid.Parse(clusterID); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid cluster_id: " + err.Error()})
+				return
+			}
+		}
+
+		// The worker removes this directory once the upload has been processed
+		uploadDir, err := os.MkdirTemp(cfg.UploadDir, "upload")
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create temp dir: " + err.Error()})
 			return
 		}
-		defer os.RemoveAll(tempDir)
 
-		tarPath := filepath.Join(tempDir, "upload.tar.gz")
-		outFile, err := os.Create(tarPath)
+		// The format is detected from the content when the upload is processed
+		uploadPath := filepath.Join(uploadDir, "upload")
+		outFile, err := os.Create(uploadPath)
 		if err != nil {
+			os.RemoveAll(uploadDir)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save file: " + err.Error()})
 			return
 		}
-		defer outFile.Close()
 
 		_, err = io.Copy(outFile, file)
+		outFile.Close()
 		if err != nil {
+			os.RemoveAll(uploadDir)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to write file: " + err.Error()})
 			return
 		}
 
+		// Only the start of the upload is read here; decompression limits are enforced by the
+		// worker, which fails the upload with the limit it broke
+		format, err := processor.InspectUpload(uploadPath)
+		if err != nil {
+			os.RemoveAll(uploadDir)
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload: " + err.Error()})
+			return
+		}
+		switch format {
+		case processor.FormatCSV, processor.FormatCSVGz, processor.FormatCSVZst:
+			if clusterID == "" {
+				os.RemoveAll(uploadDir)
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Invalid upload: cluster_id is required for %s uploads", format)})
+				return
+			}
+		}
+
 		repo := db.NewRepository(database)
-		err = processor.ProcessTar(c, tarPath, repo)
+		uploadID, err := repo.CreateUpload()
+		if err != nil {
+			os.RemoveAll(uploadDir)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record upload: " + err.Error()})
+			return
+		}
+
+		job := ingest.Job{
+			UploadID:    uploadID,
+			Path:        uploadPath,
+			Filename:    header.Filename,
+			ClusterID:   clusterID,
+			ClusterName: c.PostForm("cluster_name"),
+		}
+		if err := workers.Submit(job); err != nil {
+			os.RemoveAll(uploadDir)
+			failed := &db.Upload{ID: uploadID, Status: db.UploadStatusFailed, Error: err.Error()}
+			if completeErr := repo.CompleteUpload(failed); completeErr != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to record upload: " + completeErr.Error()})
+				return
+			}
+			c.JSON(http.StatusServiceUnavailable, gin.H{"error": "Failed to queue upload: " + err.Error(), "upload_id": uploadID})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{
+			"message":   "File queued for processing",
+			"upload_id": uploadID,
+			"status":    db.UploadStatusQueued,
+		})
+	}
+}
+
+// UploadLimits returns the limits on what an upload may expand to
+func UploadLimits(cfg *config.Config) processor.Limits {
+	return processor.Limits{
+		MaxDecompressedBytes: cfg.MaxDecompressedBytes,
+		MaxEntries:           cfg.MaxArchiveEntries,
+		MaxEntryBytes:        cfg.MaxEntryBytes,
+		MaxCompressionRatio:  cfg.MaxCompressionRatio,
+	}
+}
+
+// UploadStatusHandler handles the /api/ingress/v1/uploads/:id endpoint, reporting the state of an upload
+func UploadStatusHandler(database *pgxpool.Pool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		uploadID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid upload id: " + err.Error()})
+			return
+		}
+
+		repo := db.NewRepository(database)
+		upload, err := repo.GetUpload(uploadID)
+		if errors.Is(err, db.ErrUploadNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+			return
+		}
 		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to process tar.gz: " + err.Error()})
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to query upload: " + err.Error()})
 			return
 		}
 
-		c.JSON(http.StatusAccepted, gin.H{"message": "File processed successfully"})
+		c.JSON(http.StatusOK, upload)
 	}
 }
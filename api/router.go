@@ -3,18 +3,35 @@ package api
 import (
 	"github.com/chambridge/cost-metrics-aggregator/api/handlers"
 	"github.com/chambridge/cost-metrics-aggregator/internal/config"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingest"
 	"github.com/gin-gonic/gin"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
-func SetupRouter(db *pgxpool.Pool, cfg *config.Config) *gin.Engine {
+func SetupRouter(db *pgxpool.Pool, cfg *config.Config, workers *ingest.WorkerPool) *gin.Engine {
 	r := gin.Default()
 
 	api := r.Group("/api")
 	{
-		api.POST("/ingress/v1/upload", handlers.UploadHandler(db))
-		api.GET("/metrics/v1/nodes", handlers.QueryNodeMetricsHandler(db))
-		api.GET("/metrics/v1/pods", handlers.QueryPodMetricsHandler(db))
+		api.POST("/ingress/v1/upload", handlers.UploadHandler(db, cfg, workers))
+		api.GET("/ingress/v1/uploads/:id", handlers.UploadStatusHandler(db))
+		api.GET("/metrics/v1/nodes", handlers.QueryNodeMetricsHandler(db, cfg))
+		api.GET("/metrics/v1/pods", handlers.QueryPodMetricsHandler(db, cfg))
+		api.GET("/metrics/v1/pods/:id/history", handlers.PodHistoryHandler(db))
+		api.GET("/metrics/v1/storage", handlers.QueryStorageMetricsHandler(db, cfg))
+	}
+
+	// The admin API changes stored data and settings, so it needs the admin token
+	admin := r.Group("/api/admin/v1", handlers.AdminAuth(cfg.AdminToken))
+	{
+		admin.POST("/summaries/rebuild", handlers.RebuildSummariesHandler(db, cfg))
+		admin.POST("/summaries/verify", handlers.VerifySummariesHandler(db, cfg))
+		admin.PUT("/clusters/:id/timezone", handlers.SetClusterTimezoneHandler(db))
+		admin.GET("/ingestion-rules", handlers.ListIngestionRulesHandler(db))
+		admin.POST("/ingestion-rules", handlers.CreateIngestionRuleHandler(db))
+		admin.GET("/ingestion-rules/:id", handlers.GetIngestionRuleHandler(db))
+		admin.PUT("/ingestion-rules/:id", handlers.UpdateIngestionRuleHandler(db))
+		admin.DELETE("/ingestion-rules/:id", handlers.DeleteIngestionRuleHandler(db))
 	}
 
 	return r
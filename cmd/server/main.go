@@ -3,10 +3,17 @@ package main
 import (
 	"context"
 	"github.com/chambridge/cost-metrics-aggregator/api"
+	"github.com/chambridge/cost-metrics-aggregator/api/handlers"
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
 	"github.com/chambridge/cost-metrics-aggregator/internal/config"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingest"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"log"
 	"os"
+	"time"
+	_ "time/tzdata" // Reporting timezones do not depend on the image's zoneinfo
 )
 
 func main() {
@@ -14,6 +21,26 @@ func main() {
 	if err != nil {
 		log.Fatalf("Failed to load config: %v", err)
 	}
+	if cfg.MinOperatorVersion != "" {
+		if _, err := processor.ParseVersion(cfg.MinOperatorVersion); err != nil {
+			log.Fatalf("Invalid MIN_OPERATOR_VERSION: %v", err)
+		}
+	}
+	timestampFormats, err := processor.ParseTimestampFormats(cfg.TimestampFormats)
+	if err != nil {
+		log.Fatalf("Invalid TIMESTAMP_FORMATS: %v", err)
+	}
+	reportingLocation, err := time.LoadLocation(cfg.ReportingTimezone)
+	if err != nil {
+		log.Fatalf("Invalid REPORTING_TIMEZONE: %v", err)
+	}
+	// Without a rules file, rules are read from component_rules for each upload
+	var componentRules *components.RuleSet
+	if cfg.ComponentRulesFile != "" {
+		if componentRules, err = components.Load(cfg.ComponentRulesFile); err != nil {
+			log.Fatalf("Invalid COMPONENT_RULES_FILE: %v", err)
+		}
+	}
 
 	dbpool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
 	if err != nil {
@@ -22,6 +49,35 @@ func main() {
 	}
 	defer dbpool.Close()
 
-	router := api.SetupRouter(dbpool, cfg)
+	// Uploads still queued or processing belong to a previous run whose archives are gone
+	interrupted, err := db.NewRepository(dbpool).FailInterruptedUploads()
+	if err != nil {
+		log.Printf("Failed to mark interrupted uploads: %v", err)
+	} else if interrupted > 0 {
+		log.Printf("Marked %d interrupted uploads as failed", interrupted)
+	}
+
+	// Node summaries removed by migrations are rebuilt with this server's hours rounding and timezone
+	rebuilt, err := db.NewRepository(dbpool).WithHoursRounding(cfg.HoursRounding).
+		WithReportingLocation(reportingLocation).RebuildPendingSummaries(context.Background())
+	if err != nil {
+		log.Printf("Failed to rebuild pending node summaries: %v", err)
+	} else if rebuilt > 0 {
+		log.Printf("Rebuilt node summaries for %d cluster days", rebuilt)
+	}
+
+	workers := ingest.NewWorkerPool(cfg.IngestWorkers, cfg.IngestQueueSize, ingest.ProcessUpload(dbpool, processor.Options{
+		TransactionScope:   cfg.IngestTransactionScope,
+		MinOperatorVersion: cfg.MinOperatorVersion,
+		Limits:             handlers.UploadLimits(cfg),
+		TimestampFormats:   timestampFormats,
+		HoursRounding:      cfg.HoursRounding,
+		ReportingLocation:  reportingLocation,
+		ComponentRules:     componentRules,
+	}))
+	workers.Start(context.Background())
+	defer workers.Stop()
+
+	router := api.SetupRouter(dbpool, cfg, workers)
 	log.Fatal(router.Run(cfg.ServerAddress))
 }
@@ -7,6 +7,7 @@ require (
 	github.com/google/uuid v1.4.0
 	github.com/jackc/pgx/v5 v5.7.1
 	github.com/jmoiron/sqlx v1.4.0
+	github.com/klauspost/compress v1.17.11
 	github.com/spf13/viper v1.19.0
 	github.com/stretchr/testify v1.9.0
 )
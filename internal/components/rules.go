@@ -0,0 +1,121 @@
+// Package components attributes pods to a component and product from their labels and
+// namespace using an ordered set of rules.
+package components
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+)
+
+// DefaultLabel is the operator label that names a pod's component when no rules are configured
+const DefaultLabel = "label_rht_comp"
+
+// Rule maps pods to a component and product. A pod matches when it carries Label with a
+// value matching Value and its namespace matches Namespace; patterns use path.Match syntax
+// and an empty pattern matches anything. A rule without Label matches on namespace alone,
+// and one with neither matches every pod. An empty Component takes the value of Label.
+// Label keys are as the operator reports them, so app.kubernetes.io/part-of is
+// label_app_kubernetes_io_part_of.
+type Rule struct {
+	Label     string `json:"label"`
+	Value     string `json:"value"`
+	Namespace string `json:"namespace"`
+	Component string `json:"component"`
+	Product   string `json:"product"`
+}
+
+// Assignment is the component and product a pod is attributed to
+type Assignment struct {
+	Component string `json:"component"`
+	Product   string `json:"product"`
+}
+
+// RuleSet is an ordered list of rules; the first matching rule wins and pods matching
+// none are assigned Fallback
+type RuleSet struct {
+	Rules    []Rule     `json:"rules"`
+	Fallback Assignment `json:"fallback"`
+}
+
+// Default is the rule set used when none is configured: the component is the value of
+// label_rht_comp, and pods without it have none
+func Default() *RuleSet {
+	return &RuleSet{Rules: []Rule{{Label: DefaultLabel}}}
+}
+
+// Load reads a JSON rule set from a file such as
+//
+//	{"rules": [{"label": "label_app_kubernetes_io_part_of", "value": "kafka*", "component": "kafka", "product": "AMQ Streams"}],
+//	 "fallback": {"component": "unknown"}}
+func Load(filename string) (*RuleSet, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read component rules: %w", err)
+	}
+	var rs RuleSet
+	if err := json.Unmarshal(data, &rs); err != nil {
+		return nil, fmt.Errorf("failed to parse component rules %s: %w", filename, err)
+	}
+	if err := rs.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid component rules %s: %w", filename, err)
+	}
+	return &rs, nil
+}
+
+// Validate checks that every rule's patterns are well formed and that rules taking their
+// component or matching a value from a label name it
+func (rs *RuleSet) Validate() error {
+	for i, rule := range rs.Rules {
+		for _, pattern := range []string{rule.Value, rule.Namespace} {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("rule %d: invalid pattern %q: %w", i+1, pattern, err)
+			}
+		}
+		if rule.Label == "" && rule.Value != "" {
+			return fmt.Errorf("rule %d: value pattern %q needs a label", i+1, rule.Value)
+		}
+		if rule.Label == "" && rule.Component == "" {
+			return fmt.Errorf("rule %d: a rule without a label needs a component", i+1)
+		}
+	}
+	return nil
+}
+
+// Assign returns the component and product of a pod from the first rule it matches
+func (rs *RuleSet) Assign(namespace string, labels map[string]string) Assignment {
+	for _, rule := range rs.Rules {
+		if a, ok := rule.match(namespace, labels); ok {
+			return a
+		}
+	}
+	return rs.Fallback
+}
+
+func (r Rule) match(namespace string, labels map[string]string) (Assignment, bool) {
+	if !matchPattern(r.Namespace, namespace) {
+		return Assignment{}, false
+	}
+	a := Assignment{Component: r.Component, Product: r.Product}
+	if r.Label == "" {
+		return a, true
+	}
+	value, ok := labels[r.Label]
+	if !ok || !matchPattern(r.Value, value) {
+		return Assignment{}, false
+	}
+	if a.Component == "" {
+		a.Component = value
+	}
+	return a, true
+}
+
+// matchPattern reports whether s matches a path.Match pattern; an empty pattern matches anything
+func matchPattern(pattern, s string) bool {
+	if pattern == "" {
+		return true
+	}
+	ok, _ := path.Match(pattern, s)
+	return ok
+}
@@ -0,0 +1,81 @@
+package components
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAssign(t *testing.T) {
+	rs := &RuleSet{
+		Rules: []Rule{
+			{Label: "label_app_kubernetes_io_part_of", Value: "kafka*", Component: "kafka", Product: "AMQ Streams"},
+			{Label: "label_app_kubernetes_io_part_of", Namespace: "team-*", Product: "Team Apps"},
+			{Label: DefaultLabel, Product: "OpenShift"},
+			{Namespace: "openshift-*", Component: "platform", Product: "OpenShift"},
+		},
+		Fallback: Assignment{Component: "unknown"},
+	}
+
+	tests := []struct {
+		name      string
+		namespace string
+		labels    map[string]string
+		want      Assignment
+	}{
+		{name: "value pattern", namespace: "streams", labels: map[string]string{"label_app_kubernetes_io_part_of": "kafka-cluster"}, want: Assignment{Component: "kafka", Product: "AMQ Streams"}},
+		{name: "component from label value", namespace: "team-a", labels: map[string]string{"label_app_kubernetes_io_part_of": "billing"}, want: Assignment{Component: "billing", Product: "Team Apps"}},
+		{name: "namespace pattern not matched", namespace: "other", labels: map[string]string{"label_app_kubernetes_io_part_of": "billing"}, want: Assignment{Component: "unknown"}},
+		{name: "first match wins", namespace: "streams", labels: map[string]string{"label_app_kubernetes_io_part_of": "kafka", DefaultLabel: "ocp"}, want: Assignment{Component: "kafka", Product: "AMQ Streams"}},
+		{name: "later rule", namespace: "streams", labels: map[string]string{DefaultLabel: "ocp"}, want: Assignment{Component: "ocp", Product: "OpenShift"}},
+		{name: "namespace only", namespace: "openshift-monitoring", want: Assignment{Component: "platform", Product: "OpenShift"}},
+		{name: "fallback", namespace: "default", labels: map[string]string{"label_app": "web"}, want: Assignment{Component: "unknown"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, rs.Assign(tt.namespace, tt.labels))
+		})
+	}
+}
+
+func TestDefault(t *testing.T) {
+	rs := Default()
+	require.NoError(t, rs.Validate())
+	assert.Equal(t, Assignment{Component: "ocp"}, rs.Assign("default", map[string]string{DefaultLabel: "ocp"}))
+	assert.Equal(t, Assignment{}, rs.Assign("default", map[string]string{"label_app": "web"}))
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		filename := filepath.Join(dir, name)
+		require.NoError(t, os.WriteFile(filename, []byte(content), 0o644))
+		return filename
+	}
+
+	rs, err := Load(write("rules.json", `{
+		"rules": [{"label": "label_app_kubernetes_io_part_of", "value": "kafka*", "component": "kafka", "product": "AMQ Streams"}],
+		"fallback": {"component": "unknown"}
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []Rule{{Label: "label_app_kubernetes_io_part_of", Value: "kafka*", Component: "kafka", Product: "AMQ Streams"}}, rs.Rules)
+	assert.Equal(t, Assignment{Component: "unknown"}, rs.Fallback)
+
+	for name, content := range map[string]string{
+		"malformed.json": `{"rules": [`,
+		"pattern.json":   `{"rules": [{"label": "label_app", "value": "[web"}]}`,
+		"value.json":     `{"rules": [{"value": "web", "component": "web"}]}`,
+		"component.json": `{"rules": [{"namespace": "web"}]}`,
+		"namespace.json": `{"rules": [{"label": "label_app", "namespace": "\\"}]}`,
+	} {
+		_, err := Load(write(name, content))
+		assert.Error(t, err, name)
+	}
+
+	_, err = Load(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
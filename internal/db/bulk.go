@@ -0,0 +1,255 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// NodeKey identifies a node to insert or update in bulk. Within its cluster a node is
+// identified by Identifier, its resource ID, or by Name when Identifier is empty.
+type NodeKey struct {
+	ClusterID  uuid.UUID
+	Name       string
+	Identifier string
+	Type       string
+}
+
+// PodKey identifies a pod to insert or update in bulk
+type PodKey struct {
+	ClusterID uuid.UUID
+	NodeID    uuid.UUID
+	Name      string
+	Namespace string
+	// UID tells apart incarnations of a pod recreated under the same name; empty when
+	// the operator does not report it
+	UID       string
+	Component string
+	// Product is the product the pod's component belongs to; empty stores none
+	Product string
+	// Labels are all of the pod's labels; nil stores none
+	Labels map[string]string
+}
+
+// NodeMetricRow is a node sample destined for node_metrics
+type NodeMetricRow struct {
+	NodeID      uuid.UUID
+	ClusterID   uuid.UUID
+	Timestamp   time.Time
+	CoreCount   int
+	MemoryBytes int64
+	// IntervalSeconds is the length of the sample's interval; zero means an hour
+	IntervalSeconds float64
+}
+
+// PodMetricRow is a pod sample destined for pod_metrics
+type PodMetricRow struct {
+	PodID                         uuid.UUID
+	Timestamp                     time.Time
+	PodUsage                      float64
+	PodRequest                    float64
+	NodeCapacityCPUCoreSeconds    float64
+	NodeCapacityCPUCores          int
+	PodUsageMemory                float64
+	PodRequestMemory              float64
+	PodLimitMemory                float64
+	NodeCapacityMemoryBytes       int64
+	NodeCapacityMemoryByteSeconds float64
+	// IntervalSeconds is the length of the sample's interval; zero means an hour
+	IntervalSeconds float64
+}
+
+// podMetricUpdateColumns replaces every stored value of a pod sample on conflict
+const podMetricUpdateColumns = `pod_usage_cpu_core_seconds = EXCLUDED.pod_usage_cpu_core_seconds,
+	pod_request_cpu_core_seconds = EXCLUDED.pod_request_cpu_core_seconds,
+	node_capacity_cpu_core_seconds = EXCLUDED.node_capacity_cpu_core_seconds,
+	node_capacity_cpu_cores = EXCLUDED.node_capacity_cpu_cores,
+	pod_usage_memory_byte_seconds = EXCLUDED.pod_usage_memory_byte_seconds,
+	pod_request_memory_byte_seconds = EXCLUDED.pod_request_memory_byte_seconds,
+	pod_limit_memory_byte_seconds = EXCLUDED.pod_limit_memory_byte_seconds,
+	node_capacity_memory_bytes = EXCLUDED.node_capacity_memory_bytes,
+	node_capacity_memory_byte_seconds = EXCLUDED.node_capacity_memory_byte_seconds,
+	interval_seconds = EXCLUDED.interval_seconds`
+
+const upsertNodeQuery = `
+	INSERT INTO nodes (id, cluster_id, name, identifier, type)
+	VALUES (gen_random_uuid(), $1, $2, NULLIF($3, ''), $4)
+	ON CONFLICT (cluster_id, node_key) DO UPDATE
+	SET name = EXCLUDED.name, type = EXCLUDED.type
+	RETURNING id`
+
+const upsertPodQuery = `
+	INSERT INTO pods (id, cluster_id, node_id, name, namespace, component, labels, product, pod_uid)
+	VALUES (gen_random_uuid(), $1, $2, $3, $4, $5, $6, NULLIF($7, ''), $8)
+	ON CONFLICT (cluster_id, namespace, name, pod_uid) DO UPDATE
+	SET node_id = EXCLUDED.node_id, component = EXCLUDED.component, labels = EXCLUDED.labels, product = EXCLUDED.product
+	RETURNING id`
+
+// UpsertNodes inserts or updates nodes in a single round trip, returning their IDs in input order
+func (r *Repository) UpsertNodes(ctx context.Context, nodes []NodeKey) ([]uuid.UUID, error) {
+	batch := &pgx.Batch{}
+	for _, n := range nodes {
+		batch.Queue(upsertNodeQuery, n.ClusterID, n.Name, n.Identifier, n.Type)
+	}
+	return r.sendUpsertBatch(ctx, batch, "nodes")
+}
+
+// UpsertPods inserts or updates pods in a single round trip, returning their IDs in input order
+func (r *Repository) UpsertPods(ctx context.Context, pods []PodKey) ([]uuid.UUID, error) {
+	batch := &pgx.Batch{}
+	for _, p := range pods {
+		batch.Queue(upsertPodQuery, p.ClusterID, p.NodeID, p.Name, p.Namespace, p.Component, podLabels(p.Labels), p.Product, p.UID)
+	}
+	return r.sendUpsertBatch(ctx, batch, "pods")
+}
+
+// podLabels is the labels column value of a pod, which is never NULL
+func podLabels(labels map[string]string) map[string]string {
+	if labels == nil {
+		return map[string]string{}
+	}
+	return labels
+}
+
+func (r *Repository) sendUpsertBatch(ctx context.Context, batch *pgx.Batch, table string) ([]uuid.UUID, error) {
+	if batch.Len() == 0 {
+		return nil, nil
+	}
+
+	results := r.db.SendBatch(ctx, batch)
+	ids := make([]uuid.UUID, batch.Len())
+	for i := range ids {
+		if err := results.QueryRow().Scan(&ids[i]); err != nil {
+			results.Close()
+			return nil, fmt.Errorf("failed to upsert %s: %w", table, err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return nil, fmt.Errorf("failed to upsert %s: %w", table, err)
+	}
+	return ids, nil
+}
+
+// CopyNodeMetrics loads node samples into a staging table with COPY and merges them
+// into node_metrics. A node's interval keeps its highest core count and memory, including
+// across calls, since a file's samples for one interval can span several batches.
+func (r *Repository) CopyNodeMetrics(ctx context.Context, rows []NodeMetricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS node_metrics_staging (
+				node_id UUID,
+				timestamp TIMESTAMPTZ,
+				core_count INTEGER,
+				memory_bytes BIGINT,
+				cluster_id UUID,
+				interval_seconds DOUBLE PRECISION
+			) ON COMMIT DROP;
+			TRUNCATE node_metrics_staging`)
+		if err != nil {
+			return fmt.Errorf("failed to create node_metrics staging table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"node_metrics_staging"},
+			[]string{"node_id", "timestamp", "core_count", "memory_bytes", "cluster_id", "interval_seconds"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				return []any{rows[i].NodeID, rows[i].Timestamp, rows[i].CoreCount, rows[i].MemoryBytes, rows[i].ClusterID,
+					intervalSeconds(rows[i].IntervalSeconds)}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to copy node_metrics: %w", err)
+		}
+
+		// A node appears once per pod in an interval; keep its highest capacity
+		_, err = tx.Exec(ctx, `
+			INSERT INTO node_metrics (node_id, timestamp, core_count, memory_bytes, cluster_id, interval_seconds)
+			SELECT DISTINCT ON (node_id, timestamp) node_id, timestamp, core_count, memory_bytes, cluster_id, interval_seconds
+			FROM node_metrics_staging
+			ORDER BY node_id, timestamp, core_count DESC, memory_bytes DESC
+			ON CONFLICT (node_id, timestamp) DO UPDATE
+			SET core_count = GREATEST(node_metrics.core_count, EXCLUDED.core_count),
+			    memory_bytes = GREATEST(node_metrics.memory_bytes, EXCLUDED.memory_bytes),
+			    cluster_id = EXCLUDED.cluster_id, interval_seconds = EXCLUDED.interval_seconds`)
+		if err != nil {
+			return fmt.Errorf("failed to merge node_metrics: %w", err)
+		}
+		return nil
+	})
+}
+
+// CopyPodMetrics loads pod samples into a staging table with COPY and merges them
+// into pod_metrics. Samples for an interval that already exists replace it.
+func (r *Repository) CopyPodMetrics(ctx context.Context, rows []PodMetricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS pod_metrics_staging (
+				seq INTEGER,
+				pod_id UUID,
+				timestamp TIMESTAMPTZ,
+				pod_usage_cpu_core_seconds DOUBLE PRECISION,
+				pod_request_cpu_core_seconds DOUBLE PRECISION,
+				node_capacity_cpu_core_seconds DOUBLE PRECISION,
+				node_capacity_cpu_cores INTEGER,
+				pod_usage_memory_byte_seconds DOUBLE PRECISION,
+				pod_request_memory_byte_seconds DOUBLE PRECISION,
+				pod_limit_memory_byte_seconds DOUBLE PRECISION,
+				node_capacity_memory_bytes BIGINT,
+				node_capacity_memory_byte_seconds DOUBLE PRECISION,
+				interval_seconds DOUBLE PRECISION
+			) ON COMMIT DROP;
+			TRUNCATE pod_metrics_staging`)
+		if err != nil {
+			return fmt.Errorf("failed to create pod_metrics staging table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"pod_metrics_staging"},
+			[]string{"seq", "pod_id", "timestamp", "pod_usage_cpu_core_seconds", "pod_request_cpu_core_seconds",
+				"node_capacity_cpu_core_seconds", "node_capacity_cpu_cores", "pod_usage_memory_byte_seconds",
+				"pod_request_memory_byte_seconds", "pod_limit_memory_byte_seconds", "node_capacity_memory_bytes",
+				"node_capacity_memory_byte_seconds", "interval_seconds"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				row := rows[i]
+				return []any{i, row.PodID, row.Timestamp, row.PodUsage, row.PodRequest,
+					row.NodeCapacityCPUCoreSeconds, row.NodeCapacityCPUCores, row.PodUsageMemory,
+					row.PodRequestMemory, row.PodLimitMemory, row.NodeCapacityMemoryBytes,
+					row.NodeCapacityMemoryByteSeconds, intervalSeconds(row.IntervalSeconds)}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to copy pod_metrics: %w", err)
+		}
+
+		// The last sample for a pod and interval wins
+		_, err = tx.Exec(ctx, `
+			INSERT INTO pod_metrics (
+				pod_id, timestamp, pod_usage_cpu_core_seconds, pod_request_cpu_core_seconds,
+				node_capacity_cpu_core_seconds, node_capacity_cpu_cores, pod_usage_memory_byte_seconds,
+				pod_request_memory_byte_seconds, pod_limit_memory_byte_seconds,
+				node_capacity_memory_bytes, node_capacity_memory_byte_seconds, interval_seconds
+			)
+			SELECT DISTINCT ON (pod_id, timestamp)
+				pod_id, timestamp, pod_usage_cpu_core_seconds, pod_request_cpu_core_seconds,
+				node_capacity_cpu_core_seconds, node_capacity_cpu_cores, pod_usage_memory_byte_seconds,
+				pod_request_memory_byte_seconds, pod_limit_memory_byte_seconds,
+				node_capacity_memory_bytes, node_capacity_memory_byte_seconds, interval_seconds
+			FROM pod_metrics_staging
+			ORDER BY pod_id, timestamp, seq DESC
+			ON CONFLICT (pod_id, timestamp) DO UPDATE
+			SET `+podMetricUpdateColumns)
+		if err != nil {
+			return fmt.Errorf("failed to merge pod_metrics: %w", err)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBulkUpsertAndCopyMetrics(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	repo := NewRepository(pool)
+	ctx := context.Background()
+
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	require.NoError(t, repo.UpsertCluster(clusterID, "test-cluster"))
+
+	nodeIDs, err := repo.UpsertNodes(ctx, []NodeKey{
+		{ClusterID: clusterID, Name: "node-a", Identifier: "i-aaa", Type: "worker"},
+		{ClusterID: clusterID, Name: "node-b", Identifier: "i-bbb", Type: "master"},
+	})
+	require.NoError(t, err)
+	require.Len(t, nodeIDs, 2)
+	assert.NotEqual(t, nodeIDs[0], nodeIDs[1])
+
+	// Upserting again returns the same IDs
+	again, err := repo.UpsertNodes(ctx, []NodeKey{{ClusterID: clusterID, Name: "node-a", Identifier: "i-aaa", Type: "worker"}})
+	require.NoError(t, err)
+	assert.Equal(t, nodeIDs[0], again[0])
+
+	now := time.Now().UTC()
+	timestamp := time.Date(now.Year(), now.Month(), 15, 14, 0, 0, 0, time.UTC)
+
+	// Duplicate samples for a node and interval keep the highest core count
+	err = repo.CopyNodeMetrics(ctx, []NodeMetricRow{
+		{NodeID: nodeIDs[0], ClusterID: clusterID, Timestamp: timestamp, CoreCount: 4},
+		{NodeID: nodeIDs[0], ClusterID: clusterID, Timestamp: timestamp, CoreCount: 8},
+		{NodeID: nodeIDs[1], ClusterID: clusterID, Timestamp: timestamp, CoreCount: 2},
+	})
+	require.NoError(t, err)
+
+	var coreCount int
+	err = pool.QueryRow(ctx, "SELECT core_count FROM node_metrics WHERE node_id = $1 AND timestamp = $2", nodeIDs[0], timestamp).Scan(&coreCount)
+	require.NoError(t, err)
+	assert.Equal(t, 8, coreCount)
+
+	// A node's interval split across two calls keeps the highest capacity, whichever comes last
+	err = repo.CopyNodeMetrics(ctx, []NodeMetricRow{
+		{NodeID: nodeIDs[1], ClusterID: clusterID, Timestamp: timestamp, CoreCount: 16, MemoryBytes: 1024},
+	})
+	require.NoError(t, err)
+	err = repo.CopyNodeMetrics(ctx, []NodeMetricRow{
+		{NodeID: nodeIDs[1], ClusterID: clusterID, Timestamp: timestamp, CoreCount: 4, MemoryBytes: 512},
+	})
+	require.NoError(t, err)
+
+	var memoryBytes int64
+	err = pool.QueryRow(ctx, "SELECT core_count, memory_bytes FROM node_metrics WHERE node_id = $1 AND timestamp = $2", nodeIDs[1], timestamp).Scan(&coreCount, &memoryBytes)
+	require.NoError(t, err)
+	assert.Equal(t, 16, coreCount)
+	assert.Equal(t, int64(1024), memoryBytes)
+
+	podIDs, err := repo.UpsertPods(ctx, []PodKey{
+		{ClusterID: clusterID, NodeID: nodeIDs[0], Name: "pod-a", Namespace: "ns", Component: "comp"},
+	})
+	require.NoError(t, err)
+	require.Len(t, podIDs, 1)
+
+	// The last sample for a pod and interval wins, and reloading replaces it
+	err = repo.CopyPodMetrics(ctx, []PodMetricRow{
+		{PodID: podIDs[0], Timestamp: timestamp, PodUsage: 10, PodRequest: 20, NodeCapacityCPUCoreSeconds: 3600, NodeCapacityCPUCores: 4},
+		{PodID: podIDs[0], Timestamp: timestamp, PodUsage: 30, PodRequest: 40, NodeCapacityCPUCoreSeconds: 3600, NodeCapacityCPUCores: 4},
+	})
+	require.NoError(t, err)
+	err = repo.CopyPodMetrics(ctx, []PodMetricRow{
+		{PodID: podIDs[0], Timestamp: timestamp, PodUsage: 30, PodRequest: 40, NodeCapacityCPUCoreSeconds: 3600, NodeCapacityCPUCores: 4},
+	})
+	require.NoError(t, err)
+
+	var count int
+	var usage float64
+	err = pool.QueryRow(ctx, "SELECT COUNT(*), MAX(pod_usage_cpu_core_seconds) FROM pod_metrics WHERE pod_id = $1", podIDs[0]).Scan(&count, &usage)
+	require.NoError(t, err)
+	assert.Equal(t, 1, count)
+	assert.Equal(t, 30.0, usage)
+}
@@ -0,0 +1,116 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// WithComponentRules returns a Repository whose RebuildDailySummaries first re-attributes
+// pods to components with rules; without rules the stored components are kept
+func (r *Repository) WithComponentRules(rules *components.RuleSet) *Repository {
+	c := *r
+	c.componentRules = rules
+	return &c
+}
+
+// ComponentRules reads the rules stored in component_rules in position order. It returns
+// nil when the table is empty. Stored rules have no fallback; a last rule matching every
+// pod serves as one.
+func (r *Repository) ComponentRules() (*components.RuleSet, error) {
+	rows, err := r.db.Query(context.Background(),
+		`SELECT label_key, value_pattern, namespace_pattern, component, product
+		 FROM component_rules ORDER BY position`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query component_rules: %w", err)
+	}
+	defer rows.Close()
+
+	var rs components.RuleSet
+	for rows.Next() {
+		var rule components.Rule
+		if err := rows.Scan(&rule.Label, &rule.Value, &rule.Namespace, &rule.Component, &rule.Product); err != nil {
+			return nil, fmt.Errorf("failed to scan component rule: %w", err)
+		}
+		rs.Rules = append(rs.Rules, rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read component_rules: %w", err)
+	}
+	if len(rs.Rules) == 0 {
+		return nil, nil
+	}
+	if err := rs.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid component_rules: %w", err)
+	}
+	return &rs, nil
+}
+
+// ResolveComponentRules returns the rules pods are attributed with: those in filename when
+// it is set, otherwise those in component_rules, and components.Default when there are none
+func (r *Repository) ResolveComponentRules(filename string) (*components.RuleSet, error) {
+	if filename != "" {
+		return components.Load(filename)
+	}
+	rules, err := r.ComponentRules()
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		return components.Default(), nil
+	}
+	return rules, nil
+}
+
+// applyComponentRules re-attributes the pods of a cluster, or of every cluster when
+// clusterID is uuid.Nil, from their stored labels and namespace, returning how many changed
+func (r *Repository) applyComponentRules(ctx context.Context, tx pgx.Tx, clusterID uuid.UUID) (int64, error) {
+	var cluster *uuid.UUID
+	if clusterID != uuid.Nil {
+		cluster = &clusterID
+	}
+	rows, err := tx.Query(ctx,
+		`SELECT id, namespace, labels, COALESCE(component, ''), COALESCE(product, '')
+		 FROM pods WHERE $1::uuid IS NULL OR cluster_id = $1`, cluster)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query pods: %w", err)
+	}
+
+	// Changes are collected first since the connection is busy until the rows are read
+	batch := &pgx.Batch{}
+	for rows.Next() {
+		var id uuid.UUID
+		var namespace string
+		var labels map[string]string
+		var current components.Assignment
+		if err := rows.Scan(&id, &namespace, &labels, &current.Component, &current.Product); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan pod: %w", err)
+		}
+		if a := r.componentRules.Assign(namespace, labels); a != current {
+			batch.Queue(`UPDATE pods SET component = $2, product = NULLIF($3, '') WHERE id = $1`, id, a.Component, a.Product)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("failed to read pods: %w", err)
+	}
+	if batch.Len() == 0 {
+		return 0, nil
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for i := 0; i < batch.Len(); i++ {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return 0, fmt.Errorf("failed to update pod components: %w", err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return 0, fmt.Errorf("failed to update pod components: %w", err)
+	}
+	return int64(batch.Len()), nil
+}
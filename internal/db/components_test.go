@@ -0,0 +1,80 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveComponentRules(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	ctx := context.Background()
+	repo := NewRepository(pool)
+
+	// An empty table resolves to the default rules
+	rules, err := repo.ResolveComponentRules("")
+	require.NoError(t, err)
+	assert.Equal(t, components.Default(), rules)
+
+	_, err = pool.Exec(ctx, `INSERT INTO component_rules (position, label_key, value_pattern, component, product) VALUES
+		(2, 'label_rht_comp', '', '', 'OpenShift'),
+		(1, 'label_app_kubernetes_io_part_of', 'kafka*', 'kafka', 'AMQ Streams')`)
+	require.NoError(t, err)
+	rules, err = repo.ResolveComponentRules("")
+	require.NoError(t, err)
+	assert.Equal(t, []components.Rule{
+		{Label: "label_app_kubernetes_io_part_of", Value: "kafka*", Component: "kafka", Product: "AMQ Streams"},
+		{Label: "label_rht_comp", Product: "OpenShift"},
+	}, rules.Rules)
+
+	_, err = pool.Exec(ctx, `INSERT INTO component_rules (position, label_key, value_pattern) VALUES (3, 'label_app', '[web')`)
+	require.NoError(t, err)
+	_, err = repo.ResolveComponentRules("")
+	assert.Error(t, err)
+}
+
+func TestRebuildDailySummariesComponentRules(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	kafkaID, err := upsertPod(repo, clusterID, nodeID, "kafka-0", "streams", "", map[string]string{"label_app_kubernetes_io_part_of": "kafka-cluster"})
+	require.NoError(t, err)
+	_, err = upsertPod(repo, clusterID, nodeID, "zip-1", "test", "EAP", map[string]string{"label_rht_comp": "EAP"})
+	require.NoError(t, err)
+
+	rules := &components.RuleSet{Rules: []components.Rule{
+		{Label: "label_app_kubernetes_io_part_of", Value: "kafka*", Component: "kafka", Product: "AMQ Streams"},
+		{Label: "label_rht_comp"},
+	}}
+	day := time.Now().UTC()
+
+	// Only the pod whose attribution changes is updated
+	result, err := repo.WithComponentRules(rules).RebuildDailySummaries(ctx, day, day, clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Pods)
+
+	var component, product string
+	err = pool.QueryRow(ctx, "SELECT component, product FROM pods WHERE id = $1", kafkaID).Scan(&component, &product)
+	require.NoError(t, err)
+	assert.Equal(t, "kafka", component)
+	assert.Equal(t, "AMQ Streams", product)
+
+	// Applying the same rules again changes nothing
+	result, err = repo.WithComponentRules(rules).RebuildDailySummaries(ctx, day, day, clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result.Pods)
+
+	// Without rules, stored components are kept
+	result, err = repo.RebuildDailySummaries(ctx, day, day, clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), result.Pods)
+}
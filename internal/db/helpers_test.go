@@ -0,0 +1,42 @@
+package db
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// upsertNode inserts or updates a single node through the bulk API and returns its ID
+func upsertNode(repo *Repository, clusterID uuid.UUID, name, identifier, nodeType string) (uuid.UUID, error) {
+	ids, err := repo.UpsertNodes(context.Background(), []NodeKey{
+		{ClusterID: clusterID, Name: name, Identifier: identifier, Type: nodeType},
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return ids[0], nil
+}
+
+// upsertPod inserts or updates a single pod through the bulk API and returns its ID
+func upsertPod(repo *Repository, clusterID, nodeID uuid.UUID, name, namespace, component string, labels map[string]string) (uuid.UUID, error) {
+	ids, err := repo.UpsertPods(context.Background(), []PodKey{
+		{ClusterID: clusterID, NodeID: nodeID, Name: name, Namespace: namespace, Component: component, Labels: labels},
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+	return ids[0], nil
+}
+
+// insertNodeMetric stores an hourly node sample through the bulk API
+func insertNodeMetric(repo *Repository, nodeID uuid.UUID, timestamp time.Time, coreCount int, memoryBytes int64, clusterID uuid.UUID) error {
+	return repo.CopyNodeMetrics(context.Background(), []NodeMetricRow{
+		{NodeID: nodeID, ClusterID: clusterID, Timestamp: timestamp, CoreCount: coreCount, MemoryBytes: memoryBytes},
+	})
+}
+
+// insertPodMetric stores a pod sample through the bulk API
+func insertPodMetric(repo *Repository, m PodMetricRow) error {
+	return repo.CopyPodMetrics(context.Background(), []PodMetricRow{m})
+}
@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// ErrIngestionRuleNotFound is returned when no ingestion rule exists for the requested ID
+var ErrIngestionRuleNotFound = errors.New("ingestion rule not found")
+
+// ErrClusterNotFound is returned when a rule or request names a cluster that has never uploaded
+var ErrClusterNotFound = errors.New("cluster not found")
+
+const ingestionRuleColumns = `id, cluster_id, action, field, pattern, pattern_type, created_at`
+
+// IngestionRules lists the rules applying to a cluster, both global and its own, or
+// every rule when clusterID is uuid.Nil. Global rules come first, then by creation.
+func (r *Repository) IngestionRules(clusterID uuid.UUID) ([]ingestrules.Rule, error) {
+	var cluster *uuid.UUID
+	if clusterID != uuid.Nil {
+		cluster = &clusterID
+	}
+	rows, err := r.db.Query(context.Background(),
+		`SELECT `+ingestionRuleColumns+` FROM ingestion_rules
+		 WHERE $1::uuid IS NULL OR cluster_id IS NULL OR cluster_id = $1
+		 ORDER BY cluster_id NULLS FIRST, created_at, id`, cluster)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query ingestion_rules: %w", err)
+	}
+	defer rows.Close()
+
+	rules := []ingestrules.Rule{}
+	for rows.Next() {
+		rule, err := scanIngestionRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, *rule)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read ingestion_rules: %w", err)
+	}
+	return rules, nil
+}
+
+// GetIngestionRule returns a single rule, or ErrIngestionRuleNotFound
+func (r *Repository) GetIngestionRule(id uuid.UUID) (*ingestrules.Rule, error) {
+	row := r.db.QueryRow(context.Background(), `SELECT `+ingestionRuleColumns+` FROM ingestion_rules WHERE id = $1`, id)
+	return scanIngestionRule(row)
+}
+
+// CreateIngestionRule validates and stores a rule, setting its ID and creation time
+func (r *Repository) CreateIngestionRule(rule *ingestrules.Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	err := r.db.QueryRow(context.Background(),
+		`INSERT INTO ingestion_rules (cluster_id, action, field, pattern, pattern_type)
+		 VALUES ($1, $2, $3, $4, $5) RETURNING id, created_at`,
+		rule.ClusterID, rule.Action, rule.Field, rule.Pattern, rule.PatternType).Scan(&rule.ID, &rule.CreatedAt)
+	return ingestionRuleError(err, "create")
+}
+
+// UpdateIngestionRule validates and replaces the rule with rule.ID
+func (r *Repository) UpdateIngestionRule(rule *ingestrules.Rule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+	err := r.db.QueryRow(context.Background(),
+		`UPDATE ingestion_rules SET cluster_id = $2, action = $3, field = $4, pattern = $5, pattern_type = $6
+		 WHERE id = $1 RETURNING created_at`,
+		rule.ID, rule.ClusterID, rule.Action, rule.Field, rule.Pattern, rule.PatternType).Scan(&rule.CreatedAt)
+	return ingestionRuleError(err, "update")
+}
+
+// DeleteIngestionRule removes a rule, or returns ErrIngestionRuleNotFound
+func (r *Repository) DeleteIngestionRule(id uuid.UUID) error {
+	tag, err := r.db.Exec(context.Background(), `DELETE FROM ingestion_rules WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete ingestion rule %s: %w", id, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrIngestionRuleNotFound
+	}
+	return nil
+}
+
+// ClusterName returns the name of a cluster, or an empty string when it is unknown
+func (r *Repository) ClusterName(clusterID uuid.UUID) (string, error) {
+	var name string
+	err := r.db.QueryRow(context.Background(), `SELECT name FROM clusters WHERE id = $1`, clusterID).Scan(&name)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return "", fmt.Errorf("failed to get name of cluster %s: %w", clusterID, err)
+	}
+	return name, nil
+}
+
+func scanIngestionRule(row pgx.Row) (*ingestrules.Rule, error) {
+	var rule ingestrules.Rule
+	err := row.Scan(&rule.ID, &rule.ClusterID, &rule.Action, &rule.Field, &rule.Pattern, &rule.PatternType, &rule.CreatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrIngestionRuleNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan ingestion rule: %w", err)
+	}
+	return &rule, nil
+}
+
+// ingestionRuleError maps the errors of writing a rule to ErrIngestionRuleNotFound and
+// ErrClusterNotFound
+func ingestionRuleError(err error, action string) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return ErrIngestionRuleNotFound
+	case errors.As(err, &pgErr) && pgErr.Code == "23503":
+		return ErrClusterNotFound
+	default:
+		return fmt.Errorf("failed to %s ingestion rule: %w", action, err)
+	}
+}
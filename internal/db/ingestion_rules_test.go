@@ -0,0 +1,57 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIngestionRules(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	otherID := uuid.New()
+	require.NoError(t, repo.UpsertCluster(otherID, "other"))
+
+	global := &ingestrules.Rule{Action: ingestrules.ActionExclude, Field: ingestrules.FieldNamespace, Pattern: "openshift-*"}
+	require.NoError(t, repo.CreateIngestionRule(global))
+	assert.NotEqual(t, uuid.Nil, global.ID)
+	assert.Equal(t, ingestrules.PatternGlob, global.PatternType)
+	own := &ingestrules.Rule{ClusterID: &clusterID, Action: ingestrules.ActionInclude, Field: ingestrules.FieldNodeRole, Pattern: "worker"}
+	require.NoError(t, repo.CreateIngestionRule(own))
+	other := &ingestrules.Rule{ClusterID: &otherID, Action: ingestrules.ActionExclude, Field: ingestrules.FieldClusterName, Pattern: "other"}
+	require.NoError(t, repo.CreateIngestionRule(other))
+
+	// A cluster gets the global rules and its own
+	rules, err := repo.IngestionRules(clusterID)
+	require.NoError(t, err)
+	require.Len(t, rules, 2)
+	assert.Equal(t, global.ID, rules[0].ID)
+	assert.Equal(t, own.ID, rules[1].ID)
+
+	rules, err = repo.IngestionRules(uuid.Nil)
+	require.NoError(t, err)
+	assert.Len(t, rules, 3)
+
+	own.Pattern = "infra"
+	require.NoError(t, repo.UpdateIngestionRule(own))
+	got, err := repo.GetIngestionRule(own.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "infra", got.Pattern)
+	assert.Equal(t, clusterID, *got.ClusterID)
+
+	require.NoError(t, repo.DeleteIngestionRule(own.ID))
+	_, err = repo.GetIngestionRule(own.ID)
+	assert.ErrorIs(t, err, ErrIngestionRuleNotFound)
+	assert.ErrorIs(t, repo.DeleteIngestionRule(own.ID), ErrIngestionRuleNotFound)
+	assert.ErrorIs(t, repo.UpdateIngestionRule(own), ErrIngestionRuleNotFound)
+
+	unknown := uuid.New()
+	err = repo.CreateIngestionRule(&ingestrules.Rule{ClusterID: &unknown, Action: ingestrules.ActionExclude, Field: ingestrules.FieldNamespace, Pattern: "a"})
+	assert.ErrorIs(t, err, ErrClusterNotFound)
+	assert.Error(t, repo.CreateIngestionRule(&ingestrules.Rule{Action: "drop", Field: ingestrules.FieldNamespace, Pattern: "a"}))
+}
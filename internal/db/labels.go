@@ -0,0 +1,59 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// LabelSet is the labels last reported for a node or namespace
+type LabelSet struct {
+	Name     string
+	LastSeen time.Time
+	Labels   map[string]string
+}
+
+// UpsertNodeLabels stores the labels of a cluster's nodes in a single round trip
+func (r *Repository) UpsertNodeLabels(ctx context.Context, clusterID uuid.UUID, sets []LabelSet) error {
+	return r.upsertLabels(ctx, "node_labels", "node", clusterID, sets)
+}
+
+// UpsertNamespaceLabels stores the labels of a cluster's namespaces in a single round trip
+func (r *Repository) UpsertNamespaceLabels(ctx context.Context, clusterID uuid.UUID, sets []LabelSet) error {
+	return r.upsertLabels(ctx, "namespace_labels", "namespace", clusterID, sets)
+}
+
+// upsertLabels replaces the stored labels of each name unless a newer set is already stored,
+// so reports ingested out of order keep the most recent labels
+func (r *Repository) upsertLabels(ctx context.Context, table, column string, clusterID uuid.UUID, sets []LabelSet) error {
+	if len(sets) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf(`
+		INSERT INTO %[1]s (cluster_id, %[2]s, labels, last_seen)
+		VALUES ($1, $2, $3, $4)
+		ON CONFLICT (cluster_id, %[2]s) DO UPDATE
+		SET labels = EXCLUDED.labels, last_seen = EXCLUDED.last_seen
+		WHERE %[1]s.last_seen <= EXCLUDED.last_seen`, table, column)
+
+	batch := &pgx.Batch{}
+	for _, s := range sets {
+		batch.Queue(query, clusterID, s.Name, s.Labels, s.LastSeen)
+	}
+
+	results := r.db.SendBatch(ctx, batch)
+	for range sets {
+		if _, err := results.Exec(); err != nil {
+			results.Close()
+			return fmt.Errorf("failed to upsert %s: %w", table, err)
+		}
+	}
+	if err := results.Close(); err != nil {
+		return fmt.Errorf("failed to upsert %s: %w", table, err)
+	}
+	return nil
+}
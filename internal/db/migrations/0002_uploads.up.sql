@@ -0,0 +1,21 @@
+-- Upload tracking for asynchronous ingestion
+CREATE TABLE uploads (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    status TEXT NOT NULL,
+    cluster_id UUID REFERENCES clusters(id),
+    error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    started_at TIMESTAMPTZ,
+    completed_at TIMESTAMPTZ
+);
+
+CREATE INDEX uploads_status_idx ON uploads (status);
+
+CREATE TABLE upload_files (
+    upload_id UUID NOT NULL REFERENCES uploads(id) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    status TEXT NOT NULL,
+    rows_processed INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    PRIMARY KEY (upload_id, name)
+);
@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS upload_files_checksum_idx;
+DROP INDEX IF EXISTS uploads_manifest_uuid_idx;
+ALTER TABLE IF EXISTS upload_files DROP COLUMN IF EXISTS checksum;
+ALTER TABLE IF EXISTS uploads DROP COLUMN IF EXISTS manifest_uuid;
@@ -0,0 +1,6 @@
+-- Track manifest UUIDs and file checksums so re-uploads can be detected
+ALTER TABLE uploads ADD COLUMN manifest_uuid UUID;
+ALTER TABLE upload_files ADD COLUMN checksum TEXT;
+
+CREATE INDEX uploads_manifest_uuid_idx ON uploads (manifest_uuid);
+CREATE INDEX upload_files_checksum_idx ON upload_files (checksum);
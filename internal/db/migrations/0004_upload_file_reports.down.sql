@@ -0,0 +1,3 @@
+ALTER TABLE IF EXISTS upload_files DROP COLUMN IF EXISTS report;
+ALTER TABLE IF EXISTS upload_files DROP COLUMN IF EXISTS rows_skipped;
+ALTER TABLE IF EXISTS upload_files DROP COLUMN IF EXISTS rows_rejected;
@@ -0,0 +1,4 @@
+-- Per-file ingestion report: rejected and skipped row counts, reason codes and sample rows
+ALTER TABLE upload_files ADD COLUMN rows_rejected INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE upload_files ADD COLUMN rows_skipped INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE upload_files ADD COLUMN report JSONB;
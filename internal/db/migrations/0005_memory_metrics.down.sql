@@ -0,0 +1,15 @@
+ALTER TABLE IF EXISTS pod_daily_summary
+    DROP COLUMN IF EXISTS total_pod_effective_memory_gib_hours,
+    DROP COLUMN IF EXISTS total_pod_effective_memory_byte_seconds;
+
+ALTER TABLE IF EXISTS pod_metrics
+    DROP COLUMN IF EXISTS pod_effective_memory_byte_seconds,
+    DROP COLUMN IF EXISTS node_capacity_memory_byte_seconds,
+    DROP COLUMN IF EXISTS node_capacity_memory_bytes,
+    DROP COLUMN IF EXISTS pod_limit_memory_byte_seconds,
+    DROP COLUMN IF EXISTS pod_request_memory_byte_seconds,
+    DROP COLUMN IF EXISTS pod_usage_memory_byte_seconds;
+
+ALTER TABLE IF EXISTS node_daily_summary DROP COLUMN IF EXISTS memory_bytes;
+
+ALTER TABLE IF EXISTS node_metrics DROP COLUMN IF EXISTS memory_bytes;
@@ -0,0 +1,22 @@
+-- Memory usage from the pod usage report, summarized alongside CPU for chargeback
+ALTER TABLE node_metrics ADD COLUMN memory_bytes BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE node_daily_summary ADD COLUMN memory_bytes BIGINT NOT NULL DEFAULT 0;
+
+ALTER TABLE pod_metrics
+    ADD COLUMN pod_usage_memory_byte_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN pod_request_memory_byte_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN pod_limit_memory_byte_seconds DOUBLE PRECISION NOT NULL DEFAULT 0,
+    ADD COLUMN node_capacity_memory_bytes BIGINT NOT NULL DEFAULT 0,
+    ADD COLUMN node_capacity_memory_byte_seconds DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+ALTER TABLE pod_metrics ADD COLUMN pod_effective_memory_byte_seconds DOUBLE PRECISION GENERATED ALWAYS AS (
+    GREATEST(pod_usage_memory_byte_seconds, pod_request_memory_byte_seconds)
+) STORED;
+
+ALTER TABLE pod_daily_summary ADD COLUMN total_pod_effective_memory_byte_seconds DOUBLE PRECISION NOT NULL DEFAULT 0;
+
+-- 1 GiB-hour = 1024^3 bytes for 3600 seconds
+ALTER TABLE pod_daily_summary ADD COLUMN total_pod_effective_memory_gib_hours DOUBLE PRECISION GENERATED ALWAYS AS (
+    total_pod_effective_memory_byte_seconds / 3865470566400.0
+) STORED;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS storage_daily_summary;
+DROP TABLE IF EXISTS storage_metrics;
+DROP TABLE IF EXISTS persistent_volume_claims;
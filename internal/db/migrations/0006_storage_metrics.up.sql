@@ -0,0 +1,31 @@
+-- Persistent volume claim usage from the operator's storage report
+CREATE TABLE persistent_volume_claims (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cluster_id UUID NOT NULL REFERENCES clusters(id),
+    namespace TEXT NOT NULL,
+    name TEXT NOT NULL,
+    persistent_volume TEXT NOT NULL,
+    storage_class TEXT NOT NULL,
+    UNIQUE (name, namespace, cluster_id)
+);
+
+CREATE TABLE storage_metrics (
+    pvc_id UUID NOT NULL REFERENCES persistent_volume_claims(id),
+    timestamp TIMESTAMPTZ NOT NULL,
+    capacity_bytes BIGINT NOT NULL,
+    capacity_byte_seconds DOUBLE PRECISION NOT NULL,
+    request_byte_seconds DOUBLE PRECISION NOT NULL,
+    usage_byte_seconds DOUBLE PRECISION NOT NULL,
+    PRIMARY KEY (pvc_id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE TABLE storage_daily_summary (
+    pvc_id UUID NOT NULL REFERENCES persistent_volume_claims(id),
+    date DATE NOT NULL,
+    capacity_bytes BIGINT NOT NULL,
+    total_capacity_byte_seconds DOUBLE PRECISION NOT NULL,
+    total_request_byte_seconds DOUBLE PRECISION NOT NULL,
+    total_usage_byte_seconds DOUBLE PRECISION NOT NULL,
+    total_hours INTEGER NOT NULL,
+    PRIMARY KEY (pvc_id, date)
+);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS namespace_labels;
+DROP TABLE IF EXISTS node_labels;
+ALTER TABLE IF EXISTS upload_files DROP COLUMN IF EXISTS report_type;
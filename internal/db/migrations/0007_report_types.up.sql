@@ -0,0 +1,19 @@
+-- Report type detected for each uploaded file
+ALTER TABLE upload_files ADD COLUMN report_type TEXT;
+
+-- Latest labels reported for each node and namespace by the operator's label reports
+CREATE TABLE node_labels (
+    cluster_id UUID NOT NULL REFERENCES clusters(id),
+    node TEXT NOT NULL,
+    labels JSONB NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (cluster_id, node)
+);
+
+CREATE TABLE namespace_labels (
+    cluster_id UUID NOT NULL REFERENCES clusters(id),
+    namespace TEXT NOT NULL,
+    labels JSONB NOT NULL,
+    last_seen TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (cluster_id, namespace)
+);
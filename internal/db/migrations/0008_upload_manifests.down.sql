@@ -0,0 +1,11 @@
+DROP INDEX IF EXISTS uploads_operator_version_idx;
+
+ALTER TABLE IF EXISTS uploads
+    DROP COLUMN IF EXISTS manifest,
+    DROP COLUMN IF EXISTS certified,
+    DROP COLUMN IF EXISTS report_end,
+    DROP COLUMN IF EXISTS report_start,
+    DROP COLUMN IF EXISTS manifest_date,
+    DROP COLUMN IF EXISTS cluster_version,
+    DROP COLUMN IF EXISTS operator_commit,
+    DROP COLUMN IF EXISTS operator_version;
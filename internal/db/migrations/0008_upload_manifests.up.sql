@@ -0,0 +1,11 @@
+-- Operator manifest details saved with each upload, so the operator version behind any data can be audited
+ALTER TABLE uploads ADD COLUMN operator_version TEXT;
+ALTER TABLE uploads ADD COLUMN operator_commit TEXT;
+ALTER TABLE uploads ADD COLUMN cluster_version TEXT;
+ALTER TABLE uploads ADD COLUMN manifest_date TIMESTAMPTZ;
+ALTER TABLE uploads ADD COLUMN report_start TIMESTAMPTZ;
+ALTER TABLE uploads ADD COLUMN report_end TIMESTAMPTZ;
+ALTER TABLE uploads ADD COLUMN certified BOOLEAN;
+ALTER TABLE uploads ADD COLUMN manifest JSONB;
+
+CREATE INDEX uploads_operator_version_idx ON uploads (operator_version);
@@ -0,0 +1,7 @@
+ALTER TABLE IF EXISTS storage_daily_summary ALTER COLUMN total_hours TYPE INTEGER USING CEIL(total_hours)::integer;
+ALTER TABLE IF EXISTS pod_daily_summary ALTER COLUMN total_hours TYPE INTEGER USING CEIL(total_hours)::integer;
+ALTER TABLE IF EXISTS node_daily_summary ALTER COLUMN total_hours TYPE INTEGER USING CEIL(total_hours)::integer;
+
+ALTER TABLE IF EXISTS storage_metrics DROP COLUMN IF EXISTS interval_seconds;
+ALTER TABLE IF EXISTS pod_metrics DROP COLUMN IF EXISTS interval_seconds;
+ALTER TABLE IF EXISTS node_metrics DROP COLUMN IF EXISTS interval_seconds;
@@ -0,0 +1,9 @@
+-- Hours are derived from each sample's interval length instead of counting one hour per row.
+-- Samples stored before this migration were hourly.
+ALTER TABLE node_metrics ADD COLUMN interval_seconds DOUBLE PRECISION NOT NULL DEFAULT 3600;
+ALTER TABLE pod_metrics ADD COLUMN interval_seconds DOUBLE PRECISION NOT NULL DEFAULT 3600;
+ALTER TABLE storage_metrics ADD COLUMN interval_seconds DOUBLE PRECISION NOT NULL DEFAULT 3600;
+
+ALTER TABLE node_daily_summary ALTER COLUMN total_hours TYPE NUMERIC(12, 6) USING total_hours::numeric;
+ALTER TABLE pod_daily_summary ALTER COLUMN total_hours TYPE NUMERIC(12, 6) USING total_hours::numeric;
+ALTER TABLE storage_daily_summary ALTER COLUMN total_hours TYPE NUMERIC(12, 6) USING total_hours::numeric;
@@ -0,0 +1,2 @@
+-- Timezone a cluster's samples are bucketed into days in; NULL uses the configured reporting timezone
+ALTER TABLE clusters ADD COLUMN timezone TEXT;
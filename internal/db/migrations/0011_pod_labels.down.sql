@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS pods_labels_idx;
+ALTER TABLE pods DROP COLUMN IF EXISTS labels;
@@ -0,0 +1,3 @@
+-- Every pod is stored with all of its labels; label filtering happens when querying
+ALTER TABLE pods ADD COLUMN labels JSONB NOT NULL DEFAULT '{}'::jsonb;
+CREATE INDEX pods_labels_idx ON pods USING GIN (labels);
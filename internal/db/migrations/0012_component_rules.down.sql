@@ -0,0 +1,2 @@
+ALTER TABLE pods DROP COLUMN IF EXISTS product;
+DROP TABLE IF EXISTS component_rules;
@@ -0,0 +1,11 @@
+-- Ordered rules attributing pods to a component and product; see internal/components
+CREATE TABLE component_rules (
+    position INTEGER PRIMARY KEY,
+    label_key TEXT NOT NULL DEFAULT '',
+    value_pattern TEXT NOT NULL DEFAULT '',
+    namespace_pattern TEXT NOT NULL DEFAULT '',
+    component TEXT NOT NULL DEFAULT '',
+    product TEXT NOT NULL DEFAULT ''
+);
+
+ALTER TABLE pods ADD COLUMN product TEXT;
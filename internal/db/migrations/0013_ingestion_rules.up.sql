@@ -0,0 +1,13 @@
+-- Include and exclude rules evaluated on report rows during ingestion; rules without a
+-- cluster_id apply to every cluster
+CREATE TABLE ingestion_rules (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    cluster_id UUID REFERENCES clusters(id) ON DELETE CASCADE,
+    action TEXT NOT NULL CHECK (action IN ('include', 'exclude')),
+    field TEXT NOT NULL CHECK (field IN ('namespace', 'node_role', 'cluster_name')),
+    pattern TEXT NOT NULL,
+    pattern_type TEXT NOT NULL DEFAULT 'glob' CHECK (pattern_type IN ('glob', 'regex')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX ingestion_rules_cluster_id_idx ON ingestion_rules (cluster_id);
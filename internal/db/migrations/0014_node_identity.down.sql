@@ -0,0 +1,4 @@
+-- Split nodes are not merged back, so identifier is no longer unique on its own
+DROP TABLE IF EXISTS pending_node_summary_rebuilds;
+ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_cluster_node_key;
+ALTER TABLE nodes DROP COLUMN IF EXISTS node_key;
@@ -0,0 +1,68 @@
+-- Nodes are identified within their cluster by resource ID, or by name when the cluster
+-- reports none. Until now identifier was unique across every cluster and nodes without a
+-- resource ID were stored with an empty one, so they all shared a single row that moved to
+-- whichever cluster uploaded last.
+--
+-- Samples and pods do not record the name of their node, so what the shared rows merged
+-- can only be split by cluster: the distinct nodes of one cluster that reported no
+-- resource ID stay a single node, and their history cannot be told apart.
+ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_identifier_key;
+UPDATE nodes SET identifier = NULL WHERE identifier = '';
+
+-- Give each cluster that stored samples or pods under another cluster's node its own copy
+-- of that node, and move its samples and pods there
+CREATE TEMP TABLE node_splits AS
+SELECT used.node_id AS old_id, used.cluster_id, gen_random_uuid() AS new_id
+FROM (
+    SELECT DISTINCT u.node_id, u.cluster_id
+    FROM (
+        SELECT node_id, cluster_id FROM node_metrics WHERE cluster_id IS NOT NULL
+        UNION
+        SELECT node_id, cluster_id FROM pods
+    ) u
+    JOIN nodes n ON n.id = u.node_id
+    WHERE n.cluster_id IS DISTINCT FROM u.cluster_id
+) used;
+
+-- The stored name belongs to the cluster that uploaded last, so a copy is named after its
+-- resource ID, which the cluster's next upload replaces with its own name. A copy without
+-- a resource ID never had a name of its own and keeps a placeholder.
+INSERT INTO nodes (id, cluster_id, name, identifier, type)
+SELECT s.new_id, s.cluster_id, COALESCE(n.identifier, 'unknown-' || s.old_id::text), n.identifier, n.type
+FROM node_splits s
+JOIN nodes n ON n.id = s.old_id;
+
+UPDATE node_metrics nm SET node_id = s.new_id
+FROM node_splits s
+WHERE nm.node_id = s.old_id AND nm.cluster_id = s.cluster_id;
+
+UPDATE pods p SET node_id = s.new_id
+FROM node_splits s
+WHERE p.node_id = s.old_id AND p.cluster_id = s.cluster_id;
+
+-- The daily summaries of a split node mixed several clusters. They are removed here and
+-- the days of every cluster that shared the node are queued for the server, which rebuilds
+-- them on startup with its hours rounding and reporting timezone.
+CREATE TABLE pending_node_summary_rebuilds (
+    cluster_id UUID NOT NULL REFERENCES clusters(id),
+    date DATE NOT NULL,
+    PRIMARY KEY (cluster_id, date)
+);
+
+INSERT INTO pending_node_summary_rebuilds (cluster_id, date)
+SELECT DISTINCT shared.cluster_id, ds.date
+FROM node_daily_summary ds
+JOIN (
+    SELECT old_id, cluster_id FROM node_splits
+    UNION
+    SELECT s.old_id, n.cluster_id FROM node_splits s JOIN nodes n ON n.id = s.old_id WHERE n.cluster_id IS NOT NULL
+) shared ON ds.node_id = shared.old_id;
+
+DELETE FROM node_daily_summary ds
+USING (SELECT DISTINCT old_id FROM node_splits) s
+WHERE ds.node_id = s.old_id;
+
+DROP TABLE node_splits;
+
+ALTER TABLE nodes ADD COLUMN node_key TEXT GENERATED ALWAYS AS (COALESCE(identifier, name)) STORED;
+ALTER TABLE nodes ADD CONSTRAINT nodes_cluster_node_key UNIQUE (cluster_id, node_key);
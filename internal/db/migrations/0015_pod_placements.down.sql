@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS pod_placements;
+ALTER TABLE pods DROP COLUMN IF EXISTS last_seen;
+ALTER TABLE pods DROP COLUMN IF EXISTS first_seen;
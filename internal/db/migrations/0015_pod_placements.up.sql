@@ -0,0 +1,30 @@
+-- When each pod was first and last seen: the start of its first and the end of its last
+-- reported interval
+ALTER TABLE pods ADD COLUMN first_seen TIMESTAMPTZ;
+ALTER TABLE pods ADD COLUMN last_seen TIMESTAMPTZ;
+
+-- The nodes each pod ran on over time. Placements of a pod on the same node never overlap
+-- or touch; contiguous intervals are merged into one placement.
+CREATE TABLE pod_placements (
+    pod_id UUID NOT NULL REFERENCES pods(id) ON DELETE CASCADE,
+    node_id UUID NOT NULL REFERENCES nodes(id),
+    valid_from TIMESTAMPTZ NOT NULL,
+    valid_to TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (pod_id, node_id, valid_from),
+    CHECK (valid_to > valid_from)
+);
+CREATE INDEX pod_placements_node_id_idx ON pod_placements (node_id);
+
+-- Pods ingested before placements were tracked only know their last node, which is taken
+-- as their node for the whole time they were seen
+UPDATE pods p SET first_seen = s.first_seen, last_seen = s.last_seen
+FROM (
+    SELECT pod_id, MIN(timestamp) AS first_seen,
+           MAX(timestamp + make_interval(secs => interval_seconds)) AS last_seen
+    FROM pod_metrics
+    GROUP BY pod_id
+) s
+WHERE p.id = s.pod_id;
+
+INSERT INTO pod_placements (pod_id, node_id, valid_from, valid_to)
+SELECT id, node_id, first_seen, last_seen FROM pods WHERE first_seen IS NOT NULL;
@@ -0,0 +1,3 @@
+-- Incarnations are not merged back, so the name is no longer unique on its own
+ALTER TABLE pods DROP CONSTRAINT IF EXISTS pods_cluster_namespace_name_uid_key;
+ALTER TABLE pods DROP COLUMN IF EXISTS pod_uid;
@@ -0,0 +1,6 @@
+-- Pods recreated under the same name, such as StatefulSet pods, are told apart by their UID
+-- when the operator reports one. Pods without a UID have an empty pod_uid and keep being
+-- identified by cluster, namespace and name.
+ALTER TABLE pods ADD COLUMN pod_uid TEXT NOT NULL DEFAULT '';
+ALTER TABLE pods DROP CONSTRAINT IF EXISTS pods_name_namespace_cluster_id_key;
+ALTER TABLE pods ADD CONSTRAINT pods_cluster_namespace_name_uid_key UNIQUE (cluster_id, namespace, name, pod_uid);
@@ -0,0 +1,229 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// ErrPodNotFound is returned when no pod exists for the requested ID
+var ErrPodNotFound = errors.New("pod not found")
+
+// PodPlacementRow is a span of time during which a pod ran on a node
+type PodPlacementRow struct {
+	PodID     uuid.UUID
+	NodeID    uuid.UUID
+	ValidFrom time.Time
+	ValidTo   time.Time
+}
+
+// PodPlacement is a stored placement of a pod with the usage the pod reported during it
+type PodPlacement struct {
+	NodeID                        uuid.UUID
+	NodeName                      string
+	NodeIdentifier                string
+	NodeType                      string
+	ValidFrom                     time.Time
+	ValidTo                       time.Time
+	TotalHours                    float64
+	PodEffectiveCoreSeconds       float64
+	PodEffectiveMemoryByteSeconds float64
+}
+
+// PodHistory is a pod with the times it was first and last seen and its placements in
+// chronological order
+type PodHistory struct {
+	PodID       uuid.UUID
+	PodUID      string
+	ClusterID   uuid.UUID
+	ClusterName string
+	Namespace   string
+	PodName     string
+	Component   string
+	Product     string
+	FirstSeen   *time.Time
+	LastSeen    *time.Time
+	Placements  []PodPlacement
+}
+
+// mergePlacements sorts placements and merges those of a pod on the same node that overlap
+// or touch, so a batch of hourly samples becomes one row per pod and node
+func mergePlacements(rows []PodPlacementRow) []PodPlacementRow {
+	sorted := append([]PodPlacementRow(nil), rows...)
+	sort.Slice(sorted, func(i, j int) bool {
+		a, b := sorted[i], sorted[j]
+		if a.PodID != b.PodID {
+			return a.PodID.String() < b.PodID.String()
+		}
+		if a.NodeID != b.NodeID {
+			return a.NodeID.String() < b.NodeID.String()
+		}
+		return a.ValidFrom.Before(b.ValidFrom)
+	})
+
+	var merged []PodPlacementRow
+	for _, row := range sorted {
+		if n := len(merged); n > 0 {
+			last := &merged[n-1]
+			if last.PodID == row.PodID && last.NodeID == row.NodeID && !row.ValidFrom.After(last.ValidTo) {
+				if row.ValidTo.After(last.ValidTo) {
+					last.ValidTo = row.ValidTo
+				}
+				continue
+			}
+		}
+		merged = append(merged, row)
+	}
+	return merged
+}
+
+// RecordPodPlacements merges placements into pod_placements, joining them with stored
+// placements of the same pod and node that they overlap or touch. It widens each pod's
+// first_seen and last_seen to cover them and moves the pod to the node of its latest
+// placement, so data processed out of order still leaves the pod on its last node.
+func (r *Repository) RecordPodPlacements(ctx context.Context, rows []PodPlacementRow) error {
+	rows = mergePlacements(rows)
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS pod_placements_staging (
+				pod_id UUID,
+				node_id UUID,
+				valid_from TIMESTAMPTZ,
+				valid_to TIMESTAMPTZ
+			) ON COMMIT DROP;
+			TRUNCATE pod_placements_staging`)
+		if err != nil {
+			return fmt.Errorf("failed to create pod_placements staging table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"pod_placements_staging"},
+			[]string{"pod_id", "node_id", "valid_from", "valid_to"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				return []any{rows[i].PodID, rows[i].NodeID, rows[i].ValidFrom, rows[i].ValidTo}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to copy pod placements: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE pods p
+			SET first_seen = LEAST(p.first_seen, s.first_seen), last_seen = GREATEST(p.last_seen, s.last_seen)
+			FROM (
+				SELECT pod_id, MIN(valid_from) AS first_seen, MAX(valid_to) AS last_seen
+				FROM pod_placements_staging
+				GROUP BY pod_id
+			) s
+			WHERE p.id = s.pod_id`)
+		if err != nil {
+			return fmt.Errorf("failed to update pod first_seen and last_seen: %w", err)
+		}
+
+		// Stored placements that overlap or touch a new one are taken out and merged with it
+		_, err = tx.Exec(ctx, `
+			WITH absorbed AS (
+				DELETE FROM pod_placements pp
+				USING pod_placements_staging s
+				WHERE pp.pod_id = s.pod_id AND pp.node_id = s.node_id
+				  AND pp.valid_from <= s.valid_to AND pp.valid_to >= s.valid_from
+				RETURNING pp.pod_id, pp.node_id, pp.valid_from, pp.valid_to
+			)
+			INSERT INTO pod_placements_staging (pod_id, node_id, valid_from, valid_to)
+			SELECT pod_id, node_id, valid_from, valid_to FROM absorbed`)
+		if err != nil {
+			return fmt.Errorf("failed to merge stored pod placements: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO pod_placements (pod_id, node_id, valid_from, valid_to)
+			SELECT pod_id, node_id, MIN(valid_from), MAX(valid_to)
+			FROM (
+				SELECT pod_id, node_id, valid_from, valid_to,
+				       SUM(CASE WHEN valid_from <= prev_to THEN 0 ELSE 1 END)
+				           OVER (PARTITION BY pod_id, node_id ORDER BY valid_from, valid_to) AS island
+				FROM (
+					SELECT pod_id, node_id, valid_from, valid_to,
+					       MAX(valid_to) OVER (PARTITION BY pod_id, node_id ORDER BY valid_from, valid_to
+					                           ROWS BETWEEN UNBOUNDED PRECEDING AND 1 PRECEDING) AS prev_to
+					FROM pod_placements_staging
+				) spans
+			) islands
+			GROUP BY pod_id, node_id, island`)
+		if err != nil {
+			return fmt.Errorf("failed to insert pod placements: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			UPDATE pods p SET node_id = latest.node_id
+			FROM (
+				SELECT DISTINCT ON (pp.pod_id) pp.pod_id, pp.node_id
+				FROM pod_placements pp
+				WHERE pp.pod_id IN (SELECT pod_id FROM pod_placements_staging)
+				ORDER BY pp.pod_id, pp.valid_to DESC, pp.valid_from DESC
+			) latest
+			WHERE p.id = latest.pod_id AND p.node_id <> latest.node_id`)
+		if err != nil {
+			return fmt.Errorf("failed to update pod nodes: %w", err)
+		}
+		return nil
+	})
+}
+
+// PodHistory returns a pod with its placements, each with the usage of the pod samples whose
+// interval starts within it, or ErrPodNotFound
+func (r *Repository) PodHistory(podID uuid.UUID) (*PodHistory, error) {
+	ctx := context.Background()
+	h := PodHistory{PodID: podID, Placements: []PodPlacement{}}
+	err := r.db.QueryRow(ctx, `
+		SELECT p.pod_uid, c.id, c.name, p.namespace, p.name, COALESCE(p.component, ''), COALESCE(p.product, ''),
+		       p.first_seen, p.last_seen
+		FROM pods p
+		JOIN clusters c ON p.cluster_id = c.id
+		WHERE p.id = $1`, podID).Scan(
+		&h.PodUID, &h.ClusterID, &h.ClusterName, &h.Namespace, &h.PodName, &h.Component, &h.Product, &h.FirstSeen, &h.LastSeen)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrPodNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get pod %s: %w", podID, err)
+	}
+
+	rows, err := r.db.Query(ctx, `
+		SELECT pp.node_id, n.name, COALESCE(n.identifier, ''), n.type, pp.valid_from, pp.valid_to,
+		       COALESCE(SUM(pm.interval_seconds), 0) / 3600,
+		       COALESCE(SUM(pm.pod_effective_core_seconds), 0),
+		       COALESCE(SUM(pm.pod_effective_memory_byte_seconds), 0)
+		FROM pod_placements pp
+		JOIN nodes n ON pp.node_id = n.id
+		LEFT JOIN pod_metrics pm ON pm.pod_id = pp.pod_id
+		     AND pm.timestamp >= pp.valid_from AND pm.timestamp < pp.valid_to
+		WHERE pp.pod_id = $1
+		GROUP BY pp.node_id, n.name, n.identifier, n.type, pp.valid_from, pp.valid_to
+		ORDER BY pp.valid_from, pp.valid_to`, podID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pod_placements: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p PodPlacement
+		if err := rows.Scan(&p.NodeID, &p.NodeName, &p.NodeIdentifier, &p.NodeType, &p.ValidFrom, &p.ValidTo,
+			&p.TotalHours, &p.PodEffectiveCoreSeconds, &p.PodEffectiveMemoryByteSeconds); err != nil {
+			return nil, fmt.Errorf("failed to scan pod placement: %w", err)
+		}
+		h.Placements = append(h.Placements, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+	return &h, nil
+}
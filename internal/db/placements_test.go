@@ -0,0 +1,100 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMergePlacements(t *testing.T) {
+	pod, nodeA, nodeB := uuid.New(), uuid.New(), uuid.New()
+	at := func(hour int) time.Time { return time.Date(2025, 5, 17, hour, 0, 0, 0, time.UTC) }
+
+	merged := mergePlacements([]PodPlacementRow{
+		{PodID: pod, NodeID: nodeA, ValidFrom: at(1), ValidTo: at(2)},
+		{PodID: pod, NodeID: nodeB, ValidFrom: at(2), ValidTo: at(3)},
+		{PodID: pod, NodeID: nodeA, ValidFrom: at(0), ValidTo: at(1)},
+		{PodID: pod, NodeID: nodeA, ValidFrom: at(4), ValidTo: at(5)},
+	})
+
+	want := map[uuid.UUID][]PodPlacementRow{
+		nodeA: {
+			{PodID: pod, NodeID: nodeA, ValidFrom: at(0), ValidTo: at(2)},
+			{PodID: pod, NodeID: nodeA, ValidFrom: at(4), ValidTo: at(5)},
+		},
+		nodeB: {{PodID: pod, NodeID: nodeB, ValidFrom: at(2), ValidTo: at(3)}},
+	}
+	got := make(map[uuid.UUID][]PodPlacementRow)
+	for _, row := range merged {
+		got[row.NodeID] = append(got[row.NodeID], row)
+	}
+	assert.Equal(t, want, got)
+}
+
+func TestRecordPodPlacements(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+
+	nodeA, err := upsertNode(repo, clusterID, "worker-1", "", "worker")
+	require.NoError(t, err)
+	nodeB, err := upsertNode(repo, clusterID, "worker-2", "", "worker")
+	require.NoError(t, err)
+	podID, err := upsertPod(repo, clusterID, nodeB, "zip-1", "test", "", nil)
+	require.NoError(t, err)
+
+	day := time.Now().UTC().Truncate(24 * time.Hour)
+	at := func(hour int) time.Time { return day.Add(time.Duration(hour) * time.Hour) }
+	for hour := 0; hour < 4; hour++ {
+		require.NoError(t, insertPodMetric(repo, PodMetricRow{
+			PodID: podID, Timestamp: at(hour), PodUsage: 100, PodRequest: 50, NodeCapacityCPUCoreSeconds: 14400,
+			NodeCapacityCPUCores: 4, PodUsageMemory: 1000, NodeCapacityMemoryBytes: 1 << 30,
+		}))
+	}
+
+	// The pod ran on worker-1, moved to worker-2 and the data for its first hour came last
+	require.NoError(t, repo.RecordPodPlacements(ctx, []PodPlacementRow{
+		{PodID: podID, NodeID: nodeA, ValidFrom: at(1), ValidTo: at(2)},
+		{PodID: podID, NodeID: nodeB, ValidFrom: at(2), ValidTo: at(3)},
+	}))
+	require.NoError(t, repo.RecordPodPlacements(ctx, []PodPlacementRow{
+		{PodID: podID, NodeID: nodeB, ValidFrom: at(3), ValidTo: at(4)},
+	}))
+	require.NoError(t, repo.RecordPodPlacements(ctx, []PodPlacementRow{
+		{PodID: podID, NodeID: nodeA, ValidFrom: at(0), ValidTo: at(1)},
+	}))
+
+	var nodeID uuid.UUID
+	require.NoError(t, pool.QueryRow(ctx, "SELECT node_id FROM pods WHERE id = $1", podID).Scan(&nodeID))
+	assert.Equal(t, nodeB, nodeID, "the pod stays on the node of its latest placement")
+
+	history, err := repo.PodHistory(podID)
+	require.NoError(t, err)
+	assert.Equal(t, "zip-1", history.PodName)
+	require.NotNil(t, history.FirstSeen)
+	require.NotNil(t, history.LastSeen)
+	assert.True(t, at(0).Equal(*history.FirstSeen))
+	assert.True(t, at(4).Equal(*history.LastSeen))
+
+	require.Len(t, history.Placements, 2)
+	first, second := history.Placements[0], history.Placements[1]
+	assert.Equal(t, nodeA, first.NodeID)
+	assert.Equal(t, "worker-1", first.NodeName)
+	assert.True(t, at(0).Equal(first.ValidFrom))
+	assert.True(t, at(2).Equal(first.ValidTo))
+	assert.Equal(t, 2.0, first.TotalHours)
+	assert.Equal(t, 200.0, first.PodEffectiveCoreSeconds)
+	assert.Equal(t, nodeB, second.NodeID)
+	assert.True(t, at(2).Equal(second.ValidFrom))
+	assert.True(t, at(4).Equal(second.ValidTo))
+	assert.Equal(t, 2.0, second.TotalHours)
+
+	_, err = repo.PodHistory(uuid.New())
+	assert.ErrorIs(t, err, ErrPodNotFound)
+}
@@ -0,0 +1,214 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/selector"
+	"github.com/google/uuid"
+)
+
+// PodLabelSummary aggregates the pod_daily_summary rows of a day whose pods share a label value
+type PodLabelSummary struct {
+	Date     time.Time
+	LabelKey string
+	// LabelValue is nil for the pods without the label
+	LabelValue                         *string
+	Pods                               int
+	TotalPodEffectiveCoreSeconds       float64
+	TotalPodEffectiveMemoryByteSeconds float64
+	TotalPodEffectiveMemoryGiBHours    float64
+	TotalHours                         float64
+}
+
+// PodIncarnationSummary rolls up the pod_daily_summary rows of a day whose pods share a
+// cluster, namespace and name, adding together the incarnations of a pod recreated under
+// the same name
+type PodIncarnationSummary struct {
+	Date                               time.Time
+	ClusterID                          uuid.UUID
+	ClusterName                        string
+	Namespace                          string
+	PodName                            string
+	Incarnations                       int
+	MaxCoresUsed                       float64
+	TotalPodEffectiveCoreSeconds       float64
+	TotalPodEffectiveMemoryByteSeconds float64
+	TotalPodEffectiveMemoryGiBHours    float64
+	TotalHours                         float64
+}
+
+// QueryPodMetricsByName pages through pod_daily_summary grouped by day, cluster, namespace
+// and pod name, filtered as in QueryPodMetrics, so each pod name is one row per day however
+// many times it was recreated
+func (r *Repository) QueryPodMetricsByName(start, end time.Time, clusterID, clusterName, namespace, podName, component string, labelKeys []string, sel selector.Selector, limit, offset int) ([]PodIncarnationSummary, int, error) {
+	conditions, args := podConditions([]interface{}{start, end}, clusterID, clusterName, namespace, podName, component, labelKeys, sel)
+	groups := `
+		SELECT
+			ds.date,
+			c.id AS cluster_id,
+			c.name AS cluster_name,
+			p.namespace,
+			p.name AS pod_name,
+			COUNT(DISTINCT p.id) AS incarnations,
+			MAX(ds.max_cores_used) AS max_cores_used,
+			SUM(ds.total_pod_effective_core_seconds) AS total_pod_effective_core_seconds,
+			SUM(ds.total_pod_effective_memory_byte_seconds) AS total_pod_effective_memory_byte_seconds,
+			SUM(ds.total_pod_effective_memory_gib_hours) AS total_pod_effective_memory_gib_hours,
+			SUM(ds.total_hours) AS total_hours
+		FROM pod_daily_summary ds
+		JOIN pods p ON ds.pod_id = p.id
+		JOIN clusters c ON p.cluster_id = c.id
+		WHERE ds.date BETWEEN $1 AND $2` + conditions + `
+		GROUP BY ds.date, c.id, c.name, p.namespace, p.name`
+
+	var total int
+	err := r.db.QueryRow(context.Background(), `SELECT COUNT(*) FROM (`+groups+`) g`, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count pod_daily_summary groups: %w", err)
+	}
+
+	query := groups + fmt.Sprintf(" ORDER BY ds.date, c.name, p.namespace, p.name LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+	rows, err := r.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query pod_daily_summary groups: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []PodIncarnationSummary
+	for rows.Next() {
+		var s PodIncarnationSummary
+		if err := rows.Scan(
+			&s.Date,
+			&s.ClusterID,
+			&s.ClusterName,
+			&s.Namespace,
+			&s.PodName,
+			&s.Incarnations,
+			&s.MaxCoresUsed,
+			&s.TotalPodEffectiveCoreSeconds,
+			&s.TotalPodEffectiveMemoryByteSeconds,
+			&s.TotalPodEffectiveMemoryGiBHours,
+			&s.TotalHours,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return summaries, total, nil
+}
+
+// QueryPodMetricsByLabel pages through pod_daily_summary grouped by day and the value of
+// the pod label labelKey, filtered as in QueryPodMetrics. Groups are ordered by day and
+// label value, with the pods lacking the label last.
+func (r *Repository) QueryPodMetricsByLabel(start, end time.Time, clusterID, clusterName, namespace, podName, component string, labelKeys []string, sel selector.Selector, labelKey string, limit, offset int) ([]PodLabelSummary, int, error) {
+	conditions, args := podConditions([]interface{}{start, end, labelKey}, clusterID, clusterName, namespace, podName, component, labelKeys, sel)
+	groups := `
+		SELECT
+			ds.date,
+			p.labels->>$3 AS label_value,
+			COUNT(DISTINCT p.id) AS pods,
+			SUM(ds.total_pod_effective_core_seconds) AS total_pod_effective_core_seconds,
+			SUM(ds.total_pod_effective_memory_byte_seconds) AS total_pod_effective_memory_byte_seconds,
+			SUM(ds.total_pod_effective_memory_gib_hours) AS total_pod_effective_memory_gib_hours,
+			SUM(ds.total_hours) AS total_hours
+		FROM pod_daily_summary ds
+		JOIN pods p ON ds.pod_id = p.id
+		JOIN clusters c ON p.cluster_id = c.id
+		WHERE ds.date BETWEEN $1 AND $2` + conditions + `
+		GROUP BY ds.date, p.labels->>$3`
+
+	var total int
+	err := r.db.QueryRow(context.Background(), `SELECT COUNT(*) FROM (`+groups+`) g`, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count pod_daily_summary groups: %w", err)
+	}
+
+	query := groups + fmt.Sprintf(" ORDER BY ds.date, label_value NULLS LAST LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+	rows, err := r.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query pod_daily_summary groups: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []PodLabelSummary
+	for rows.Next() {
+		s := PodLabelSummary{LabelKey: labelKey}
+		if err := rows.Scan(
+			&s.Date,
+			&s.LabelValue,
+			&s.Pods,
+			&s.TotalPodEffectiveCoreSeconds,
+			&s.TotalPodEffectiveMemoryByteSeconds,
+			&s.TotalPodEffectiveMemoryGiBHours,
+			&s.TotalHours,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return summaries, total, nil
+}
+
+// podConditions appends the pod query filters to args, returning the AND clauses that
+// reference them. Pods are aliased p and clusters c.
+func podConditions(args []interface{}, clusterID, clusterName, namespace, podName, component string, labelKeys []string, sel selector.Selector) (string, []interface{}) {
+	var conditions string
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + fmt.Sprint(len(args))
+	}
+	if clusterID != "" {
+		conditions += " AND c.id::text = " + arg(clusterID)
+	}
+	if clusterName != "" {
+		conditions += " AND c.name ILIKE " + arg("%"+clusterName+"%")
+	}
+	if namespace != "" {
+		conditions += " AND p.namespace ILIKE " + arg("%"+namespace+"%")
+	}
+	if podName != "" {
+		conditions += " AND p.name ILIKE " + arg("%"+podName+"%")
+	}
+	if component != "" {
+		conditions += " AND p.component ILIKE " + arg("%"+component+"%")
+	}
+	if len(labelKeys) > 0 {
+		conditions += " AND p.labels ?| " + arg(labelKeys)
+	}
+	for _, req := range sel {
+		conditions += " AND " + requirementCondition(req, arg)
+	}
+	return conditions, args
+}
+
+// requirementCondition is the SQL for a label selector requirement on p.labels. Equality
+// and existence use the containment operators served by the labels GIN index.
+func requirementCondition(req selector.Requirement, arg func(interface{}) string) string {
+	switch req.Operator {
+	case selector.Exists:
+		return "p.labels ? " + arg(req.Key)
+	case selector.DoesNotExist:
+		return "NOT p.labels ? " + arg(req.Key)
+	case selector.Equals:
+		return "p.labels @> " + arg(map[string]string{req.Key: req.Values[0]})
+	case selector.NotEquals:
+		return "NOT p.labels @> " + arg(map[string]string{req.Key: req.Values[0]})
+	case selector.In:
+		return "p.labels->>" + arg(req.Key) + " = ANY(" + arg(req.Values) + "::text[])"
+	case selector.NotIn:
+		return "NOT COALESCE(p.labels->>" + arg(req.Key) + " = ANY(" + arg(req.Values) + "::text[]), false)"
+	}
+	return "false"
+}
@@ -0,0 +1,103 @@
+package db
+
+import (
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/chambridge/cost-metrics-aggregator/internal/selector"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// seedLabeledPods stores a day of pod summaries for pods with the given labels
+func seedLabeledPods(t *testing.T, repo *Repository, day time.Time, pods map[string]map[string]string) {
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	for name, labels := range pods {
+		podID, err := upsertPod(repo, clusterID, nodeID, name, "test", "", labels)
+		require.NoError(t, err)
+		require.NoError(t, insertPodMetric(repo, PodMetricRow{PodID: podID, Timestamp: day.Add(14 * time.Hour), PodUsage: 1800, PodRequest: 3600, NodeCapacityCPUCoreSeconds: 14400, NodeCapacityCPUCores: 4}))
+	}
+	require.NoError(t, repo.RefreshPodDailySummaries(clusterID, day))
+}
+
+func TestQueryPodMetricsLabelSelector(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	repo := NewRepository(pool)
+	day := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), 15, 0, 0, 0, 0, time.UTC)
+	seedLabeledPods(t, repo, day, map[string]map[string]string{
+		"api-1":    {"label_env": "prod", "label_team": "a"},
+		"web-1":    {"label_env": "prod", "label_team": "b", "label_canary": "true"},
+		"batch-1":  {"label_env": "dev", "label_team": "c"},
+		"legacy-1": {},
+	})
+
+	tests := []struct {
+		selector string
+		want     []string
+	}{
+		{selector: "env=prod", want: []string{"api-1", "web-1"}},
+		{selector: "env!=prod", want: []string{"batch-1", "legacy-1"}},
+		{selector: "team in (a,c)", want: []string{"api-1", "batch-1"}},
+		{selector: "team notin (a,c)", want: []string{"legacy-1", "web-1"}},
+		{selector: "canary", want: []string{"web-1"}},
+		{selector: "env=prod,team in (a,b),!canary", want: []string{"api-1"}},
+		{selector: "label_env=dev", want: []string{"batch-1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			sel, err := selector.Parse(tt.selector)
+			require.NoError(t, err)
+			summaries, total, err := repo.QueryPodMetrics(day, day, "", "", "", "", "", nil, sel, 100, 0)
+			require.NoError(t, err)
+			assert.Equal(t, len(tt.want), total)
+			var got []string
+			for _, s := range summaries {
+				got = append(got, s.PodName)
+			}
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+}
+
+func TestQueryPodMetricsByLabel(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	repo := NewRepository(pool)
+	day := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), 15, 0, 0, 0, 0, time.UTC)
+	seedLabeledPods(t, repo, day, map[string]map[string]string{
+		"api-1":    {"label_env": "prod", "label_team": "a"},
+		"api-2":    {"label_env": "prod", "label_team": "a"},
+		"web-1":    {"label_env": "prod", "label_team": "b"},
+		"legacy-1": {"label_env": "prod"},
+		"batch-1":  {"label_env": "dev", "label_team": "a"},
+	})
+
+	sel, err := selector.Parse("env=prod")
+	require.NoError(t, err)
+	groups, total, err := repo.QueryPodMetricsByLabel(day, day, "", "", "", "", "", nil, sel, "label_team", 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, groups, 3)
+
+	// Groups are ordered by value with the pods lacking the label last
+	require.NotNil(t, groups[0].LabelValue)
+	assert.Equal(t, "a", *groups[0].LabelValue)
+	assert.Equal(t, "label_team", groups[0].LabelKey)
+	assert.Equal(t, 2, groups[0].Pods)
+	assert.Equal(t, 2*groups[1].TotalPodEffectiveCoreSeconds, groups[0].TotalPodEffectiveCoreSeconds)
+	require.NotNil(t, groups[1].LabelValue)
+	assert.Equal(t, "b", *groups[1].LabelValue)
+	assert.Nil(t, groups[2].LabelValue)
+	assert.Equal(t, 1, groups[2].Pods)
+
+	// Groups are paged
+	groups, total, err = repo.QueryPodMetricsByLabel(day, day, "", "", "", "", "", nil, sel, "label_team", 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, 3, total)
+	require.Len(t, groups, 1)
+	assert.Equal(t, "b", *groups[0].LabelValue)
+}
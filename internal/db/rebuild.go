@@ -0,0 +1,277 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// RebuildResult counts the pods re-attributed to components and the summary rows written
+// by RebuildDailySummaries
+type RebuildResult struct {
+	Pods             int64 `json:"pods"`
+	NodeSummaries    int64 `json:"node_summaries"`
+	PodSummaries     int64 `json:"pod_summaries"`
+	StorageSummaries int64 `json:"storage_summaries"`
+}
+
+// summaryTable describes a daily summary table and how its rows derive from raw metrics
+type summaryTable struct {
+	// name is the table name without the _daily_summary suffix
+	name string
+	// owner holds the cluster_id of the entity whose id is the first key column
+	owner string
+	// key identifies a row together with its date
+	key []string
+	// values are the aggregated columns
+	values []string
+	// derive returns a query producing the key, date and value columns of the summary rows
+	// for the range given by summaryRange
+	derive func(r *Repository) string
+}
+
+func (t summaryTable) table() string {
+	return t.name + "_daily_summary"
+}
+
+var (
+	nodeSummaries = summaryTable{
+		name:   "node",
+		owner:  "nodes",
+		key:    []string{"node_id", "core_count"},
+		values: []string{"memory_bytes", "total_hours"},
+		derive: func(r *Repository) string {
+			return `SELECT s.node_id, s.core_count, s.day AS date, MAX(s.memory_bytes) AS memory_bytes,
+			        ` + r.hoursExpr("s.interval_seconds") + ` AS total_hours
+			 FROM (
+				SELECT nm.node_id, nm.core_count, nm.memory_bytes, nm.interval_seconds, ` + sampleDay("nm.timestamp") + ` AS day
+				FROM node_metrics nm
+				JOIN nodes n ON nm.node_id = n.id
+				LEFT JOIN clusters c ON n.cluster_id = c.id
+				WHERE nm.timestamp >= $5 AND nm.timestamp < $6 AND ($3::uuid IS NULL OR n.cluster_id = $3)
+			 ) s
+			 WHERE s.day BETWEEN $1 AND $2
+			 GROUP BY s.node_id, s.day, s.core_count`
+		},
+	}
+	podSummaries = summaryTable{
+		name:   "pod",
+		owner:  "pods",
+		key:    []string{"pod_id"},
+		values: []string{"max_cores_used", "total_pod_effective_core_seconds", "total_pod_effective_memory_byte_seconds", "total_hours"},
+		derive: func(r *Repository) string {
+			return `SELECT s.pod_id, s.day AS date, MAX(s.pod_effective_core_usage) AS max_cores_used,
+			        SUM(s.pod_effective_core_seconds) AS total_pod_effective_core_seconds,
+			        SUM(s.pod_effective_memory_byte_seconds) AS total_pod_effective_memory_byte_seconds,
+			        ` + r.hoursExpr("s.interval_seconds") + ` AS total_hours
+			 FROM (
+				SELECT pm.pod_id, pm.pod_effective_core_usage, pm.pod_effective_core_seconds,
+				       pm.pod_effective_memory_byte_seconds, pm.interval_seconds, ` + sampleDay("pm.timestamp") + ` AS day
+				FROM pod_metrics pm
+				JOIN pods p ON pm.pod_id = p.id
+				LEFT JOIN clusters c ON p.cluster_id = c.id
+				WHERE pm.timestamp >= $5 AND pm.timestamp < $6 AND ($3::uuid IS NULL OR p.cluster_id = $3)
+			 ) s
+			 WHERE s.day BETWEEN $1 AND $2
+			 GROUP BY s.pod_id, s.day`
+		},
+	}
+	storageSummaries = summaryTable{
+		name:   "storage",
+		owner:  "persistent_volume_claims",
+		key:    []string{"pvc_id"},
+		values: []string{"capacity_bytes", "total_capacity_byte_seconds", "total_request_byte_seconds", "total_usage_byte_seconds", "total_hours"},
+		derive: func(r *Repository) string {
+			return `SELECT s.pvc_id, s.day AS date, MAX(s.capacity_bytes) AS capacity_bytes,
+			        SUM(s.capacity_byte_seconds) AS total_capacity_byte_seconds,
+			        SUM(s.request_byte_seconds) AS total_request_byte_seconds,
+			        SUM(s.usage_byte_seconds) AS total_usage_byte_seconds,
+			        ` + r.hoursExpr("s.interval_seconds") + ` AS total_hours
+			 FROM (
+				SELECT sm.pvc_id, sm.capacity_bytes, sm.capacity_byte_seconds, sm.request_byte_seconds,
+				       sm.usage_byte_seconds, sm.interval_seconds, ` + sampleDay("sm.timestamp") + ` AS day
+				FROM storage_metrics sm
+				JOIN persistent_volume_claims pvc ON sm.pvc_id = pvc.id
+				LEFT JOIN clusters c ON pvc.cluster_id = c.id
+				WHERE sm.timestamp >= $5 AND sm.timestamp < $6 AND ($3::uuid IS NULL OR pvc.cluster_id = $3)
+			 ) s
+			 WHERE s.day BETWEEN $1 AND $2
+			 GROUP BY s.pvc_id, s.day`
+		},
+	}
+)
+
+// summaryTables are the daily summaries in the order they are rebuilt and verified
+var summaryTables = []summaryTable{nodeSummaries, podSummaries, storageSummaries}
+
+// RebuildDailySummaries recomputes node_daily_summary, pod_daily_summary and
+// storage_daily_summary from the raw metrics for the calendar days first to last,
+// inclusive, of every cluster, or of a single cluster when clusterID is not uuid.Nil.
+// The affected summary rows are replaced in one transaction, so readers see either the
+// old or the new summaries. Days are bounded as in RefreshNodeDailySummaries, using each
+// cluster's own reporting timezone. With WithComponentRules, the cluster's pods are first
+// re-attributed to components from their stored labels in the same transaction.
+func (r *Repository) RebuildDailySummaries(ctx context.Context, first, last time.Time, clusterID uuid.UUID) (RebuildResult, error) {
+	var result RebuildResult
+	first, last = calendarDay(first), calendarDay(last)
+	if last.Before(first) {
+		return result, fmt.Errorf("invalid date range: %s is before %s", last.Format("2006-01-02"), first.Format("2006-01-02"))
+	}
+
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		var err error
+		if r.componentRules != nil {
+			if result.Pods, err = r.applyComponentRules(ctx, tx, clusterID); err != nil {
+				return err
+			}
+		}
+		if result.NodeSummaries, err = r.rebuildSummaries(ctx, tx, nodeSummaries, first, last, clusterID); err != nil {
+			return err
+		}
+		if result.PodSummaries, err = r.rebuildSummaries(ctx, tx, podSummaries, first, last, clusterID); err != nil {
+			return err
+		}
+		result.StorageSummaries, err = r.rebuildSummaries(ctx, tx, storageSummaries, first, last, clusterID)
+		return err
+	})
+	if err != nil {
+		return RebuildResult{}, err
+	}
+	return result, nil
+}
+
+// summaryRange holds the arguments shared by the summary queries: $1 and $2 are the first
+// and last calendar day, $3 the cluster or NULL for all, $4 the timezone of clusters
+// without their own, and $5 and $6 bound the sample timestamps widely enough for any
+// timezone so that only the partitions of the range are scanned
+func (r *Repository) summaryRange(first, last time.Time, clusterID uuid.UUID) []any {
+	var cluster *uuid.UUID
+	if clusterID != uuid.Nil {
+		cluster = &clusterID
+	}
+	timezone := "UTC"
+	if r.location != nil {
+		timezone = r.location.String()
+	}
+	return []any{first, last, cluster, timezone, first.AddDate(0, 0, -1), last.AddDate(0, 0, 2)}
+}
+
+// sampleDay is the calendar day, in its cluster's reporting timezone, a sample taken at
+// column belongs to; the query must join the sample's cluster as c
+func sampleDay(column string) string {
+	return "(" + column + " AT TIME ZONE COALESCE(c.timezone, $4))::date"
+}
+
+// Advisory lock classes serializing summary rebuilds. A rebuild of every cluster holds the
+// summaryLockAll lock exclusively; a rebuild of one cluster holds it shared together with
+// an exclusive summaryLockCluster lock keyed by a hash of the cluster ID.
+const (
+	summaryLockAll     = 0x5345
+	summaryLockCluster = 0x5346
+)
+
+// lockSummaries waits until no other transaction is rebuilding the summaries of the cluster,
+// or of any cluster when clusterID is uuid.Nil, and holds the lock until tx ends. Without it,
+// two transactions rebuilding the same rows both delete them and then both insert them,
+// and the later insert fails on the primary key.
+func lockSummaries(ctx context.Context, tx pgx.Tx, clusterID uuid.UUID) error {
+	var err error
+	if clusterID == uuid.Nil {
+		_, err = tx.Exec(ctx, `SELECT pg_advisory_xact_lock($1, 0)`, summaryLockAll)
+	} else {
+		_, err = tx.Exec(ctx,
+			`SELECT pg_advisory_xact_lock_shared($1, 0), pg_advisory_xact_lock($2, hashtext($3::text))`,
+			summaryLockAll, summaryLockCluster, clusterID.String())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to lock summaries: %w", err)
+	}
+	return nil
+}
+
+// clusterDay is a calendar day of one cluster
+type clusterDay struct {
+	cluster uuid.UUID
+	date    time.Time
+}
+
+// sortClusterDays orders days by cluster and date. Rebuilding them in this order takes the
+// locks of lockSummaries in a fixed order, so concurrent rebuilds cannot deadlock.
+func sortClusterDays(days []clusterDay) {
+	sort.Slice(days, func(i, j int) bool {
+		if days[i].cluster != days[j].cluster {
+			return days[i].cluster.String() < days[j].cluster.String()
+		}
+		return days[i].date.Before(days[j].date)
+	})
+}
+
+// rebuildSummaries replaces the rows of a summary table in a range, returning how many were
+// written. It first takes the lock of lockSummaries, so concurrent rebuilds of the same
+// cluster run one after the other.
+func (r *Repository) rebuildSummaries(ctx context.Context, tx pgx.Tx, t summaryTable, first, last time.Time, clusterID uuid.UUID) (int64, error) {
+	if err := lockSummaries(ctx, tx, clusterID); err != nil {
+		return 0, err
+	}
+
+	args := r.summaryRange(first, last, clusterID)
+	_, err := tx.Exec(ctx,
+		`DELETE FROM `+t.table()+` ds
+		 USING `+t.owner+` o
+		 WHERE ds.`+t.key[0]+` = o.id AND ds.date BETWEEN $1 AND $2 AND ($3::uuid IS NULL OR o.cluster_id = $3)`,
+		args[:3]...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to clear %s: %w", t.table(), err)
+	}
+
+	columns := append(append(append([]string{}, t.key...), "date"), t.values...)
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO `+t.table()+` (`+strings.Join(columns, ", ")+`)
+		 SELECT `+strings.Join(columns, ", ")+` FROM (`+t.derive(r)+`) derived`,
+		args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rebuild %s: %w", t.table(), err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+// RebuildPendingSummaries rebuilds the node daily summaries of the cluster days that a
+// migration removed and queued in pending_node_summary_rebuilds, and clears the queue,
+// returning how many cluster days were rebuilt
+func (r *Repository) RebuildPendingSummaries(ctx context.Context) (int, error) {
+	var days []clusterDay
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `DELETE FROM pending_node_summary_rebuilds RETURNING cluster_id, date`)
+		if err != nil {
+			return fmt.Errorf("failed to take pending summary rebuilds: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var day clusterDay
+			if err := rows.Scan(&day.cluster, &day.date); err != nil {
+				return fmt.Errorf("failed to scan pending summary rebuild: %w", err)
+			}
+			days = append(days, day)
+		}
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("row iteration error: %w", err)
+		}
+
+		sortClusterDays(days)
+		for _, day := range days {
+			if _, err := r.rebuildSummaries(ctx, tx, nodeSummaries, day.date, day.date, day.cluster); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return len(days), nil
+}
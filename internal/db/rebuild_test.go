@@ -0,0 +1,181 @@
+package db
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebuildDailySummaries(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	podID, err := upsertPod(repo, clusterID, nodeID, "zip-1", "test", "EAP", nil)
+	require.NoError(t, err)
+
+	// Two hours on each of two days, written without refreshing any summary
+	now := time.Now().UTC()
+	first := time.Date(now.Year(), now.Month(), 10, 0, 0, 0, 0, time.UTC)
+	last := first.AddDate(0, 0, 1)
+	var nodeRows []NodeMetricRow
+	for _, day := range []time.Time{first, last} {
+		for hour := 0; hour < 2; hour++ {
+			ts := day.Add(time.Duration(hour) * time.Hour)
+			nodeRows = append(nodeRows, NodeMetricRow{NodeID: nodeID, ClusterID: clusterID, Timestamp: ts, CoreCount: 4, MemoryBytes: 17179869184})
+			require.NoError(t, insertPodMetric(repo, PodMetricRow{PodID: podID, Timestamp: ts, PodUsage: 1800, PodRequest: 3600, NodeCapacityCPUCoreSeconds: 14400, NodeCapacityCPUCores: 4}))
+		}
+	}
+	require.NoError(t, repo.CopyNodeMetrics(ctx, nodeRows))
+
+	// A stale row, such as one left by an ingestion bug, is replaced
+	_, err = tx.Exec(ctx, "INSERT INTO node_daily_summary (node_id, date, core_count, memory_bytes, total_hours) VALUES ($1, $2, 8, 0, 24)", nodeID, first)
+	require.NoError(t, err)
+	require.NoError(t, tx.Commit(ctx))
+
+	result, err := repo.RebuildDailySummaries(ctx, first, last, uuid.Nil)
+	require.NoError(t, err)
+	assert.Equal(t, RebuildResult{NodeSummaries: 2, PodSummaries: 2}, result)
+
+	var nodeHours, podHours float64
+	var rows int
+	err = pool.QueryRow(ctx, "SELECT COUNT(*), SUM(total_hours) FROM node_daily_summary WHERE node_id = $1", nodeID).Scan(&rows, &nodeHours)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+	assert.Equal(t, 4.0, nodeHours)
+	err = pool.QueryRow(ctx, "SELECT SUM(total_hours) FROM pod_daily_summary WHERE pod_id = $1 AND date = $2", podID, last).Scan(&podHours)
+	require.NoError(t, err)
+	assert.Equal(t, 2.0, podHours)
+
+	// Another cluster's rebuild leaves these summaries alone
+	result, err = repo.RebuildDailySummaries(ctx, first, last, uuid.New())
+	require.NoError(t, err)
+	assert.Equal(t, RebuildResult{}, result)
+	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM node_daily_summary WHERE node_id = $1", nodeID).Scan(&rows)
+	require.NoError(t, err)
+	assert.Equal(t, 2, rows)
+
+	_, err = repo.RebuildDailySummaries(ctx, last, first, uuid.Nil)
+	assert.Error(t, err)
+}
+
+func TestVerifyDailySummaries(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	podID, err := upsertPod(repo, clusterID, nodeID, "zip-1", "test", "EAP", nil)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 10, 0, 0, 0, 0, time.UTC)
+	for hour := 0; hour < 3; hour++ {
+		ts := day.Add(time.Duration(hour) * time.Hour)
+		require.NoError(t, repo.CopyNodeMetrics(ctx, []NodeMetricRow{{NodeID: nodeID, ClusterID: clusterID, Timestamp: ts, CoreCount: 4, MemoryBytes: 17179869184}}))
+		require.NoError(t, insertPodMetric(repo, PodMetricRow{PodID: podID, Timestamp: ts, PodUsage: 1800, PodRequest: 3600, NodeCapacityCPUCoreSeconds: 14400, NodeCapacityCPUCores: 4}))
+	}
+	require.NoError(t, repo.RefreshNodeDailySummaries(clusterID, day))
+	require.NoError(t, repo.RefreshPodDailySummaries(clusterID, day))
+
+	mismatches, err := repo.VerifyDailySummaries(ctx, day, day, uuid.Nil, false)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+
+	// A drifted pod summary is reported with its magnitude
+	_, err = pool.Exec(ctx, "UPDATE pod_daily_summary SET total_hours = 5 WHERE pod_id = $1", podID)
+	require.NoError(t, err)
+	mismatches, err = repo.VerifyDailySummaries(ctx, day, day, clusterID, false)
+	require.NoError(t, err)
+	require.Len(t, mismatches, 1)
+	assert.Equal(t, "pod", mismatches[0].Summary)
+	assert.Equal(t, clusterID, mismatches[0].ClusterID)
+	assert.Equal(t, day, mismatches[0].Date)
+	assert.Equal(t, 1, mismatches[0].MismatchedRows)
+	assert.Equal(t, ValueDifference{Expected: 3, Actual: 5, Difference: 2}, mismatches[0].Values["total_hours"])
+
+	// A missing node summary is reported, and repair rebuilds both
+	_, err = pool.Exec(ctx, "DELETE FROM node_daily_summary WHERE node_id = $1", nodeID)
+	require.NoError(t, err)
+	mismatches, err = repo.VerifyDailySummaries(ctx, day, day, uuid.Nil, true)
+	require.NoError(t, err)
+	assert.Len(t, mismatches, 2)
+	assert.Equal(t, 0, mismatches[0].ActualRows)
+	assert.Equal(t, 1, mismatches[0].ExpectedRows)
+
+	mismatches, err = repo.VerifyDailySummaries(ctx, day, day, uuid.Nil, false)
+	require.NoError(t, err)
+	assert.Empty(t, mismatches)
+}
+
+func TestRefreshPodDailySummariesConcurrent(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	podID, err := upsertPod(repo, clusterID, nodeID, "zip-1", "test", "EAP", nil)
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, insertPodMetric(repo, PodMetricRow{
+		PodID: podID, Timestamp: day.Add(14 * time.Hour), PodUsage: 100, PodRequest: 200,
+		NodeCapacityCPUCoreSeconds: 14400, NodeCapacityCPUCores: 4, NodeCapacityMemoryBytes: 1 << 30,
+	}))
+
+	// Ingest workers and a repair refreshing the same day must not collide on the primary key
+	errs := make(chan error, 8)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- repo.RefreshPodDailySummaries(clusterID, day) }()
+	}
+	for i := 0; i < cap(errs); i++ {
+		assert.NoError(t, <-errs)
+	}
+
+	var count int
+	require.NoError(t, pool.QueryRow(context.Background(),
+		"SELECT COUNT(*) FROM pod_daily_summary WHERE pod_id = $1 AND date = $2", podID, day).Scan(&count))
+	assert.Equal(t, 1, count)
+}
+
+func TestRebuildPendingSummaries(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, insertNodeMetric(repo, nodeID, day.Add(14*time.Hour), 4, 17179869184, clusterID))
+	_, err = pool.Exec(ctx, "INSERT INTO pending_node_summary_rebuilds (cluster_id, date) VALUES ($1, $2)", clusterID, day)
+	require.NoError(t, err)
+
+	rebuilt, err := repo.RebuildPendingSummaries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, rebuilt)
+
+	var totalHours float64
+	require.NoError(t, pool.QueryRow(ctx,
+		"SELECT total_hours FROM node_daily_summary WHERE node_id = $1 AND date = $2", nodeID, day).Scan(&totalHours))
+	assert.Equal(t, 1.0, totalHours)
+
+	// The queue is cleared, so the next startup has nothing to rebuild
+	rebuilt, err = repo.RebuildPendingSummaries(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 0, rebuilt)
+}
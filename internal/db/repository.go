@@ -3,10 +3,15 @@ package db
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
+	"github.com/chambridge/cost-metrics-aggregator/internal/selector"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -19,111 +24,194 @@ type NodeDailySummary struct {
 	NodeIdentifier string
 	NodeType       string
 	CoreCount      int
-	TotalHours     int
+	MemoryBytes    int64
+	TotalHours     float64
 }
 
 // PodDailySummary represents a row in the pod_daily_summary table
 type PodDailySummary struct {
-	Date                         time.Time
-	MaxCoresUsed                 float64
-	TotalPodEffectiveCoreSeconds float64
-	TotalHours                   int
-	ClusterID                    uuid.UUID
-	ClusterName                  string
-	PodName                      string
-	Namespace                    string
-	Component                    string
+	Date                               time.Time
+	MaxCoresUsed                       float64
+	TotalPodEffectiveCoreSeconds       float64
+	TotalPodEffectiveMemoryByteSeconds float64
+	TotalPodEffectiveMemoryGiBHours    float64
+	TotalHours                         float64
+	ClusterID                          uuid.UUID
+	ClusterName                        string
+	PodID                              uuid.UUID
+	PodUID                             string
+	PodName                            string
+	Namespace                          string
+	Component                          string
+	Product                            string
+	Labels                             map[string]string
 }
 
+// dbtx is the subset of pgx shared by pools and transactions, letting a
+// Repository run either directly against the pool or inside a transaction
+type dbtx interface {
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	SendBatch(ctx context.Context, b *pgx.Batch) pgx.BatchResults
+	CopyFrom(ctx context.Context, tableName pgx.Identifier, columnNames []string, rowSrc pgx.CopyFromSource) (int64, error)
+	Begin(ctx context.Context) (pgx.Tx, error)
+}
+
+// Billing rounding rules for the hours in daily summaries
+const (
+	// HoursRoundingExact sums the exact length of every interval
+	HoursRoundingExact = "exact"
+	// HoursRoundingDay rounds each day's total up to a whole hour
+	HoursRoundingDay = "day"
+	// HoursRoundingInterval rounds every interval up to a whole hour before summing
+	HoursRoundingInterval = "interval"
+)
+
 type Repository struct {
-	db *pgxpool.Pool
+	db             dbtx
+	hoursRounding  string
+	location       *time.Location
+	componentRules *components.RuleSet
 }
 
 func NewRepository(db *pgxpool.Pool) *Repository {
 	return &Repository{db: db}
 }
 
-func (r *Repository) UpsertCluster(id uuid.UUID, name string) error {
-	_, err := r.db.Exec(context.Background(),
-		`INSERT INTO clusters (id, name) VALUES ($1, $2)
-		 ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name`,
-		id, name)
-	return err
+// WithHoursRounding returns a Repository that rounds the hours of the daily summaries it
+// rebuilds by one of the HoursRounding rules; the default is HoursRoundingExact
+func (r *Repository) WithHoursRounding(rule string) *Repository {
+	c := *r
+	c.hoursRounding = rule
+	return &c
 }
 
-func (r *Repository) UpsertNode(clusterID uuid.UUID, name, identifier, nodeType string) (uuid.UUID, error) {
-	var id uuid.UUID
-	query := `
-		INSERT INTO nodes (id, cluster_id, name, identifier, type)
-		VALUES (gen_random_uuid(), $1, $2, $3, $4)
-		ON CONFLICT (identifier) DO UPDATE
-		SET name = EXCLUDED.name, cluster_id = EXCLUDED.cluster_id, type = EXCLUDED.type
-		RETURNING id`
-	err := r.db.QueryRow(context.Background(), query, clusterID, name, identifier, nodeType).Scan(&id)
-	return id, err
+// WithReportingLocation returns a Repository that buckets samples into days in loc for
+// clusters without a timezone of their own; the default is UTC
+func (r *Repository) WithReportingLocation(loc *time.Location) *Repository {
+	c := *r
+	c.location = loc
+	return &c
 }
 
-func (r *Repository) InsertNodeMetric(nodeID uuid.UUID, timestamp time.Time, coreCount int, clusterID uuid.UUID) error {
-	_, err := r.db.Exec(context.Background(),
-		`INSERT INTO node_metrics (node_id, timestamp, core_count, cluster_id)
-		 VALUES ($1, $2, $3, $4)
-		 ON CONFLICT DO NOTHING`, nodeID, timestamp, coreCount, clusterID)
-	return err
+// ReportingLocation is the timezone a cluster's samples are bucketed into days in: the
+// cluster's timezone when it has one and the Repository's reporting location otherwise
+func (r *Repository) ReportingLocation(clusterID uuid.UUID) (*time.Location, error) {
+	var timezone sql.NullString
+	err := r.db.QueryRow(context.Background(), `SELECT timezone FROM clusters WHERE id = $1`, clusterID).Scan(&timezone)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("failed to get timezone of cluster %s: %w", clusterID, err)
+	}
+	if timezone.String != "" {
+		loc, err := time.LoadLocation(timezone.String)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timezone of cluster %s: %w", clusterID, err)
+		}
+		return loc, nil
+	}
+	if r.location == nil {
+		return time.UTC, nil
+	}
+	return r.location, nil
 }
 
-func (r *Repository) UpdateNodeDailySummary(nodeID uuid.UUID, timestamp time.Time, coreCount int) error {
-	date := timestamp.Truncate(24 * time.Hour)
-	_, err := r.db.Exec(context.Background(),
-		`INSERT INTO node_daily_summary (node_id, date, core_count, total_hours)
-		 VALUES ($1, $2, $3, 1)
-		 ON CONFLICT (node_id, date, core_count)
-		 DO UPDATE SET total_hours = node_daily_summary.total_hours + 1`,
-		nodeID, date, coreCount)
-	return err
+// ErrInvalidTimezone is returned when a cluster timezone is not an IANA timezone
+var ErrInvalidTimezone = errors.New("invalid timezone")
+
+// SetClusterTimezone sets the timezone a cluster's samples are bucketed into days in. An
+// empty timezone falls back to the reporting location. Summaries already built are not
+// rebucketed. It returns ErrInvalidTimezone or ErrClusterNotFound.
+func (r *Repository) SetClusterTimezone(clusterID uuid.UUID, timezone string) error {
+	if _, err := time.LoadLocation(timezone); err != nil || timezone == "Local" {
+		return fmt.Errorf("%w %q", ErrInvalidTimezone, timezone)
+	}
+	tag, err := r.db.Exec(context.Background(), `UPDATE clusters SET timezone = NULLIF($2, '') WHERE id = $1`, clusterID, timezone)
+	if err != nil {
+		return fmt.Errorf("failed to set timezone of cluster %s: %w", clusterID, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrClusterNotFound
+	}
+	return nil
 }
 
-func (r *Repository) UpsertPod(clusterID, nodeID uuid.UUID, name, namespace, component string) (uuid.UUID, error) {
-	var id uuid.UUID
-	query := `
-		INSERT INTO pods (id, cluster_id, node_id, name, namespace, component)
-		VALUES (gen_random_uuid(), $1, $2, $3, $4, $5)
-		ON CONFLICT (name, namespace, cluster_id) DO UPDATE
-		SET node_id = EXCLUDED.node_id, component = EXCLUDED.component
-		RETURNING id`
-	err := r.db.QueryRow(context.Background(), query, clusterID, nodeID, name, namespace, component).Scan(&id)
-	return id, err
+// ReportingDate is the calendar day t falls on in loc, as midnight UTC
+func ReportingDate(t time.Time, loc *time.Location) time.Time {
+	t = t.In(loc)
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
 }
 
-func (r *Repository) InsertPodMetric(podID uuid.UUID, timestamp time.Time, podUsage, podRequest, nodeCapacityCPUCoreSeconds float64, nodeCapacityCPUCores int) error {
-	_, err := r.db.Exec(context.Background(),
-		`INSERT INTO pod_metrics (
-			pod_id, timestamp, pod_usage_cpu_core_seconds, 
-			pod_request_cpu_core_seconds, node_capacity_cpu_core_seconds, 
-			node_capacity_cpu_cores
-		) VALUES ($1, $2, $3, $4, $5, $6)
-		 ON CONFLICT (pod_id, timestamp) DO UPDATE
-		 SET pod_usage_cpu_core_seconds = pod_metrics.pod_usage_cpu_core_seconds + EXCLUDED.pod_usage_cpu_core_seconds,
-		     pod_request_cpu_core_seconds = pod_metrics.pod_request_cpu_core_seconds + EXCLUDED.pod_request_cpu_core_seconds,
-		     node_capacity_cpu_core_seconds = EXCLUDED.node_capacity_cpu_core_seconds,
-		     node_capacity_cpu_cores = EXCLUDED.node_capacity_cpu_cores`,
-		podID, timestamp, podUsage, podRequest, nodeCapacityCPUCoreSeconds, nodeCapacityCPUCores)
-	return err
+// calendarDay is the calendar day of t in the location it carries, as midnight UTC
+func calendarDay(t time.Time) time.Time {
+	return ReportingDate(t, t.Location())
+}
+
+// hoursExpr aggregates column, the interval lengths of a group of samples in seconds, into hours
+func (r *Repository) hoursExpr(column string) string {
+	switch r.hoursRounding {
+	case HoursRoundingDay:
+		return "CEIL(SUM(" + column + ") / 3600)"
+	case HoursRoundingInterval:
+		return "SUM(CEIL(" + column + " / 3600))"
+	default:
+		return "ROUND((SUM(" + column + ") / 3600)::numeric, 6)"
+	}
+}
+
+// intervalSeconds is the length of a sample's interval, where zero means an hour
+func intervalSeconds(seconds float64) float64 {
+	if seconds <= 0 {
+		return 3600
+	}
+	return seconds
+}
+
+// WithTx runs fn with a Repository bound to a single transaction. The transaction
+// commits if fn returns nil and rolls back otherwise. Calling WithTx on a
+// Repository that is already in a transaction uses a savepoint.
+func (r *Repository) WithTx(ctx context.Context, fn func(tx *Repository) error) error {
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		c := *r
+		c.db = tx
+		return fn(&c)
+	})
 }
 
-func (r *Repository) UpdatePodDailySummary(podID uuid.UUID, timestamp time.Time, podEffectiveCoreSeconds, podEffectiveCoreUsage float64) error {
-	date := timestamp.Truncate(24 * time.Hour)
+func (r *Repository) UpsertCluster(id uuid.UUID, name string) error {
 	_, err := r.db.Exec(context.Background(),
-		`INSERT INTO pod_daily_summary (
-			pod_id, date, max_cores_used, total_pod_effective_core_seconds, total_hours
-		) VALUES ($1, $2, $3, $4, 1)
-		 ON CONFLICT (pod_id, date) DO UPDATE
-		 SET max_cores_used = GREATEST(pod_daily_summary.max_cores_used, EXCLUDED.max_cores_used),
-		     total_pod_effective_core_seconds = pod_daily_summary.total_pod_effective_core_seconds + EXCLUDED.total_pod_effective_core_seconds,
-		     total_hours = pod_daily_summary.total_hours + 1`,
-		podID, date, podEffectiveCoreUsage, podEffectiveCoreSeconds)
+		`INSERT INTO clusters (id, name) VALUES ($1, $2)
+		 ON CONFLICT (id) DO UPDATE SET name = EXCLUDED.name`,
+		id, name)
 	return err
 }
 
+// RefreshNodeDailySummaries rebuilds node_daily_summary for a cluster and day from node_metrics,
+// so refreshing the same day again gives the same result. Each sample adds the length of its
+// interval to the hours of its node and core count. The day is date's calendar day and runs
+// from midnight to midnight in the cluster's reporting timezone; a sample belongs to the day
+// its interval starts in.
+func (r *Repository) RefreshNodeDailySummaries(clusterID uuid.UUID, date time.Time) error {
+	ctx := context.Background()
+	day := calendarDay(date)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := r.rebuildSummaries(ctx, tx, nodeSummaries, day, day, clusterID)
+		return err
+	})
+}
+
+// RefreshPodDailySummaries rebuilds pod_daily_summary for a cluster and day from pod_metrics,
+// so refreshing the same day again gives the same result. Hours are the summed interval lengths.
+// Days are bounded as in RefreshNodeDailySummaries.
+func (r *Repository) RefreshPodDailySummaries(clusterID uuid.UUID, date time.Time) error {
+	ctx := context.Background()
+	day := calendarDay(date)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := r.rebuildSummaries(ctx, tx, podSummaries, day, day, clusterID)
+		return err
+	})
+}
+
 func (r *Repository) QueryNodeMetrics(start, end time.Time, clusterID, clusterName, nodeType string, limit, offset int) ([]NodeDailySummary, int, error) {
 	// Count total records
 	countQuery := `
@@ -162,6 +250,7 @@ func (r *Repository) QueryNodeMetrics(start, end time.Time, clusterID, clusterNa
 			COALESCE(n.identifier, '') AS node_identifier,
 			COALESCE(n.type, '') AS node_type,
 			ds.core_count, 
+			ds.memory_bytes,
 			ds.total_hours
 		FROM node_daily_summary ds
 		JOIN nodes n ON ds.node_id = n.id
@@ -201,6 +290,7 @@ func (r *Repository) QueryNodeMetrics(start, end time.Time, clusterID, clusterNa
 			&nodeIdentifier,
 			&nodeType,
 			&s.CoreCount,
+			&s.MemoryBytes,
 			&s.TotalHours,
 		); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
@@ -216,38 +306,21 @@ func (r *Repository) QueryNodeMetrics(start, end time.Time, clusterID, clusterNa
 	return summaries, total, nil
 }
 
-func (r *Repository) QueryPodMetrics(start, end time.Time, clusterID, clusterName, namespace, podName, component string, limit, offset int) ([]PodDailySummary, int, error) {
+// QueryPodMetrics pages through pod_daily_summary. When labelKeys is not empty, only pods
+// carrying at least one of the label keys are included, and only pods matching sel.
+func (r *Repository) QueryPodMetrics(start, end time.Time, clusterID, clusterName, namespace, podName, component string, labelKeys []string, sel selector.Selector, limit, offset int) ([]PodDailySummary, int, error) {
+	conditions, args := podConditions([]interface{}{start, end}, clusterID, clusterName, namespace, podName, component, labelKeys, sel)
+
 	// Count total records
 	countQuery := `
 		SELECT COUNT(*) 
 		FROM pod_daily_summary ds
 		JOIN pods p ON ds.pod_id = p.id
 		JOIN clusters c ON p.cluster_id = c.id
-		WHERE ds.date BETWEEN $1 AND $2`
-	countArgs := []interface{}{start, end}
-	if clusterID != "" {
-		countQuery += " AND c.id::text = $" + fmt.Sprint(len(countArgs)+1)
-		countArgs = append(countArgs, clusterID)
-	}
-	if clusterName != "" {
-		countQuery += " AND c.name ILIKE $" + fmt.Sprint(len(countArgs)+1)
-		countArgs = append(countArgs, "%"+clusterName+"%")
-	}
-	if namespace != "" {
-		countQuery += " AND p.namespace ILIKE $" + fmt.Sprint(len(countArgs)+1)
-		countArgs = append(countArgs, "%"+namespace+"%")
-	}
-	if podName != "" {
-		countQuery += " AND p.name ILIKE $" + fmt.Sprint(len(countArgs)+1)
-		countArgs = append(countArgs, "%"+podName+"%")
-	}
-	if component != "" {
-		countQuery += " AND p.component ILIKE $" + fmt.Sprint(len(countArgs)+1)
-		countArgs = append(countArgs, "%"+component+"%")
-	}
+		WHERE ds.date BETWEEN $1 AND $2` + conditions
 
 	var total int
-	err := r.db.QueryRow(context.Background(), countQuery, countArgs...).Scan(&total)
+	err := r.db.QueryRow(context.Background(), countQuery, args...).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count pod_daily_summary: %w", err)
 	}
@@ -258,37 +331,22 @@ func (r *Repository) QueryPodMetrics(start, end time.Time, clusterID, clusterNam
 			ds.date,
 			ds.max_cores_used,
 			ds.total_pod_effective_core_seconds,
+			ds.total_pod_effective_memory_byte_seconds,
+			ds.total_pod_effective_memory_gib_hours,
 			ds.total_hours,
 			c.id AS cluster_id,
 			c.name AS cluster_name,
+			p.id AS pod_id,
+			p.pod_uid,
 			p.namespace,
 			p.name AS pod_name,
-			COALESCE(p.component, '') AS component
+			COALESCE(p.component, '') AS component,
+			COALESCE(p.product, '') AS product,
+			p.labels
 		FROM pod_daily_summary ds
 		JOIN pods p ON ds.pod_id = p.id
 		JOIN clusters c ON p.cluster_id = c.id
-		WHERE ds.date BETWEEN $1 AND $2`
-	args := []interface{}{start, end}
-	if clusterID != "" {
-		query += " AND c.id::text = $" + fmt.Sprint(len(args)+1)
-		args = append(args, clusterID)
-	}
-	if clusterName != "" {
-		query += " AND c.name ILIKE $" + fmt.Sprint(len(args)+1)
-		args = append(args, "%"+clusterName+"%")
-	}
-	if namespace != "" {
-		query += " AND p.namespace ILIKE $" + fmt.Sprint(len(args)+1)
-		args = append(args, "%"+namespace+"%")
-	}
-	if podName != "" {
-		query += " AND p.name ILIKE $" + fmt.Sprint(len(args)+1)
-		args = append(args, "%"+podName+"%")
-	}
-	if component != "" {
-		query += " AND p.component ILIKE $" + fmt.Sprint(len(args)+1)
-		args = append(args, "%"+component+"%")
-	}
+		WHERE ds.date BETWEEN $1 AND $2` + conditions
 	query += fmt.Sprintf(" ORDER BY ds.date LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
 	args = append(args, limit, offset)
 
@@ -306,12 +364,18 @@ func (r *Repository) QueryPodMetrics(start, end time.Time, clusterID, clusterNam
 			&s.Date,
 			&s.MaxCoresUsed,
 			&s.TotalPodEffectiveCoreSeconds,
+			&s.TotalPodEffectiveMemoryByteSeconds,
+			&s.TotalPodEffectiveMemoryGiBHours,
 			&s.TotalHours,
 			&s.ClusterID,
 			&s.ClusterName,
+			&s.PodID,
+			&s.PodUID,
 			&s.Namespace,
 			&s.PodName,
 			&component,
+			&s.Product,
+			&s.Labels,
 		); err != nil {
 			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
 		}
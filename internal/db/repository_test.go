@@ -2,6 +2,7 @@ package db
 
 import (
 	"context"
+	"errors"
 	"testing"
 	"time"
 
@@ -24,7 +25,7 @@ func TestUpsertNode(t *testing.T) {
 	nodeRole := "worker"
 
 	time.Sleep(500 * time.Millisecond)
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	assert.NoError(t, err)
 	assert.NotEqual(t, uuid.Nil, nodeID)
 	time.Sleep(500 * time.Millisecond)
@@ -35,6 +36,51 @@ func TestUpsertNode(t *testing.T) {
 	assert.Equal(t, 1, count)
 }
 
+func TestUpsertNodeIdentity(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+	repo := NewRepository(pool)
+	ctx := context.Background()
+
+	clusterA := uuid.MustParse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	clusterB := uuid.New()
+	require.NoError(t, repo.UpsertCluster(clusterB, "on-prem"))
+
+	// Nodes without a resource ID are identified by name within their cluster
+	a1, err := upsertNode(repo, clusterA, "worker-1", "", "worker")
+	require.NoError(t, err)
+	a2, err := upsertNode(repo, clusterA, "worker-2", "", "worker")
+	require.NoError(t, err)
+	b1, err := upsertNode(repo, clusterB, "worker-1", "", "worker")
+	require.NoError(t, err)
+	again, err := upsertNode(repo, clusterA, "worker-1", "", "infra")
+	require.NoError(t, err)
+	assert.NotEqual(t, a1, a2)
+	assert.NotEqual(t, a1, b1)
+	assert.Equal(t, a1, again)
+
+	// The same resource ID in two clusters is two nodes; a renamed node keeps its ID
+	withID, err := upsertNode(repo, clusterA, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	otherCluster, err := upsertNode(repo, clusterB, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	renamed, err := upsertNode(repo, clusterA, "ip-10-0-1-64.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+	assert.NotEqual(t, withID, otherCluster)
+	assert.Equal(t, withID, renamed)
+
+	var clusterID uuid.UUID
+	var nodeType string
+	var identifier *string
+	require.NoError(t, pool.QueryRow(ctx, "SELECT cluster_id, type, identifier FROM nodes WHERE id = $1", a1).Scan(&clusterID, &nodeType, &identifier))
+	assert.Equal(t, clusterA, clusterID)
+	assert.Equal(t, "infra", nodeType)
+	assert.Nil(t, identifier, "an empty resource ID is stored as NULL")
+
+	var name string
+	require.NoError(t, pool.QueryRow(ctx, "SELECT name FROM nodes WHERE id = $1", withID).Scan(&name))
+	assert.Equal(t, "ip-10-0-1-64.ec2.internal", name)
+}
+
 func TestInsertNodeMetric(t *testing.T) {
 	pool, newTx := testutils.SetupTestDB(t)
 	tx := newTx()
@@ -48,7 +94,7 @@ func TestInsertNodeMetric(t *testing.T) {
 	nodeRole := "worker"
 
 	// Ensure node exists
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	require.NoError(t, err)
 	time.Sleep(500 * time.Millisecond)
 	now := time.Now().UTC()
@@ -57,7 +103,7 @@ func TestInsertNodeMetric(t *testing.T) {
 	timestamp := time.Date(year, month, 15, 14, 0, 0, 0, time.UTC)
 	coreCount := 4
 
-	err = repo.InsertNodeMetric(nodeID, timestamp, coreCount, clusterID)
+	err = insertNodeMetric(repo, nodeID, timestamp, coreCount, 17179869184, clusterID)
 	assert.NoError(t, err)
 
 	var count int
@@ -66,7 +112,7 @@ func TestInsertNodeMetric(t *testing.T) {
 	assert.Equal(t, 1, count, "Expected one row in node_metrics")
 }
 
-func TestUpdateNodeDailySummary(t *testing.T) {
+func TestRefreshNodeDailySummaries(t *testing.T) {
 	pool, newTx := testutils.SetupTestDB(t)
 	tx := newTx()
 	defer tx.Rollback(context.Background())
@@ -79,19 +125,126 @@ func TestUpdateNodeDailySummary(t *testing.T) {
 	nodeRole := "worker"
 
 	// Ensure node exists
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	require.NoError(t, err)
 
-	timestamp, _ := time.Parse("2006-01-02 15:04:05 +0000 MST", "2025-05-17 14:00:00 +0000 UTC")
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
 	coreCount := 4
-
-	err = repo.UpdateNodeDailySummary(nodeID, timestamp, coreCount)
+	memoryBytes := int64(17179869184)
+	require.NoError(t, insertNodeMetric(repo, nodeID, day.Add(14*time.Hour), coreCount, memoryBytes, clusterID))
+	require.NoError(t, insertNodeMetric(repo, nodeID, day.Add(15*time.Hour), coreCount, memoryBytes, clusterID))
+
+	// Re-sending the same samples and refreshing again must not change the result
+	for i := 0; i < 2; i++ {
+		require.NoError(t, insertNodeMetric(repo, nodeID, day.Add(14*time.Hour), coreCount, memoryBytes, clusterID))
+		err = repo.RefreshNodeDailySummaries(clusterID, day)
+		assert.NoError(t, err)
+	}
+
+	var totalHours float64
+	var storedMemory int64
+	err = tx.QueryRow(context.Background(), "SELECT total_hours, memory_bytes FROM node_daily_summary WHERE node_id = $1 AND date = $2", nodeID, day).Scan(&totalHours, &storedMemory)
 	assert.NoError(t, err)
+	assert.Equal(t, 2.0, totalHours)
+	assert.Equal(t, memoryBytes, storedMemory)
+}
 
-	var totalHours int
-	err = tx.QueryRow(context.Background(), "SELECT total_hours FROM node_daily_summary WHERE node_id = $1 AND date = $2", nodeID, "2025-05-17").Scan(&totalHours)
-	assert.NoError(t, err)
-	assert.Equal(t, 1, totalHours)
+func TestRefreshNodeDailySummariesHoursRounding(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+
+	// Three 15 minute intervals and a node that stopped 10 minutes into the next one
+	day := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), 15, 0, 0, 0, 0, time.UTC)
+	var rows []NodeMetricRow
+	for i, seconds := range []float64{900, 900, 900, 600} {
+		rows = append(rows, NodeMetricRow{
+			NodeID:          nodeID,
+			ClusterID:       clusterID,
+			Timestamp:       day.Add(14*time.Hour + time.Duration(i)*15*time.Minute),
+			CoreCount:       4,
+			MemoryBytes:     17179869184,
+			IntervalSeconds: seconds,
+		})
+	}
+	require.NoError(t, repo.CopyNodeMetrics(ctx, rows))
+
+	for rule, want := range map[string]float64{
+		HoursRoundingExact:    0.916667,
+		HoursRoundingDay:      1,
+		HoursRoundingInterval: 4,
+	} {
+		require.NoError(t, repo.WithHoursRounding(rule).RefreshNodeDailySummaries(clusterID, day))
+		var totalHours float64
+		err = tx.QueryRow(ctx, "SELECT total_hours FROM node_daily_summary WHERE node_id = $1 AND date = $2", nodeID, day).Scan(&totalHours)
+		require.NoError(t, err)
+		assert.Equal(t, want, totalHours, rule)
+	}
+}
+
+func TestReportingDate(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+
+	at := time.Date(2025, 5, 18, 3, 0, 0, 0, time.UTC)
+	assert.Equal(t, time.Date(2025, 5, 18, 0, 0, 0, 0, time.UTC), ReportingDate(at, time.UTC))
+	assert.Equal(t, time.Date(2025, 5, 17, 0, 0, 0, 0, time.UTC), ReportingDate(at, newYork))
+}
+
+func TestRefreshNodeDailySummariesTimezone(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	ctx := context.Background()
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	require.NoError(t, repo.UpsertCluster(clusterID, "test-cluster"))
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+
+	// 03:00 UTC is 23:00 the day before in New York, 05:00 UTC is 01:00 the same day
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	next := day.AddDate(0, 0, 1)
+	require.NoError(t, repo.CopyNodeMetrics(ctx, []NodeMetricRow{
+		{NodeID: nodeID, ClusterID: clusterID, Timestamp: next.Add(3 * time.Hour), CoreCount: 4, MemoryBytes: 17179869184},
+		{NodeID: nodeID, ClusterID: clusterID, Timestamp: next.Add(5 * time.Hour), CoreCount: 4, MemoryBytes: 17179869184},
+	}))
+
+	hours := func(date time.Time) float64 {
+		var totalHours float64
+		err := tx.QueryRow(ctx, "SELECT COALESCE(SUM(total_hours), 0) FROM node_daily_summary WHERE node_id = $1 AND date = $2", nodeID, date).Scan(&totalHours)
+		require.NoError(t, err)
+		return totalHours
+	}
+
+	// The configured reporting location applies to clusters without a timezone
+	newYork, err := time.LoadLocation("America/New_York")
+	require.NoError(t, err)
+	for _, date := range []time.Time{day, next} {
+		require.NoError(t, repo.WithReportingLocation(newYork).RefreshNodeDailySummaries(clusterID, date))
+	}
+	assert.Equal(t, 1.0, hours(day))
+	assert.Equal(t, 1.0, hours(next))
+
+	// A cluster's own timezone wins over the reporting location
+	require.NoError(t, repo.SetClusterTimezone(clusterID, "UTC"))
+	for _, date := range []time.Time{day, next} {
+		require.NoError(t, repo.WithReportingLocation(newYork).RefreshNodeDailySummaries(clusterID, date))
+	}
+	assert.Equal(t, 0.0, hours(day))
+	assert.Equal(t, 2.0, hours(next))
+
+	assert.ErrorIs(t, repo.SetClusterTimezone(clusterID, "Eastern"), ErrInvalidTimezone)
+	assert.ErrorIs(t, repo.SetClusterTimezone(uuid.New(), "UTC"), ErrClusterNotFound)
 }
 
 func TestUpsertPod(t *testing.T) {
@@ -110,10 +263,11 @@ func TestUpsertPod(t *testing.T) {
 	component := "EAP"
 
 	// Ensure node exists
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	require.NoError(t, err)
 
-	podID, err := repo.UpsertPod(clusterID, nodeID, podName, namespace, component)
+	labels := map[string]string{"label_app": "web", "label_rht_comp": component}
+	podID, err := upsertPod(repo, clusterID, nodeID, podName, namespace, component, labels)
 	assert.NoError(t, err)
 	assert.NotEqual(t, uuid.Nil, podID)
 
@@ -121,6 +275,11 @@ func TestUpsertPod(t *testing.T) {
 	err = tx.QueryRow(context.Background(), "SELECT COUNT(*) FROM pods WHERE id = $1 AND name = $2 AND namespace = $3", podID, podName, namespace).Scan(&count)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
+
+	var storedLabels map[string]string
+	err = tx.QueryRow(context.Background(), "SELECT labels FROM pods WHERE id = $1", podID).Scan(&storedLabels)
+	assert.NoError(t, err)
+	assert.Equal(t, labels, storedLabels)
 }
 
 func TestInsertPodMetric(t *testing.T) {
@@ -139,11 +298,11 @@ func TestInsertPodMetric(t *testing.T) {
 	component := "EAP"
 
 	// Ensure node exists
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	require.NoError(t, err)
 
 	// Insert pod to satisfy foreign key constraint
-	podID, err := repo.UpsertPod(clusterID, nodeID, podName, namespace, component)
+	podID, err := upsertPod(repo, clusterID, nodeID, podName, namespace, component, nil)
 	require.NoError(t, err)
 	now := time.Now().UTC()
 	year, month := now.Year(), now.Month()
@@ -156,7 +315,14 @@ func TestInsertPodMetric(t *testing.T) {
 	nodeCap := 14400.0
 	coreCount := 4
 
-	err = repo.InsertPodMetric(podID, timestamp, usage, request, nodeCap, coreCount)
+	err = insertPodMetric(repo, PodMetricRow{
+		PodID:                      podID,
+		Timestamp:                  timestamp,
+		PodUsage:                   usage,
+		PodRequest:                 request,
+		NodeCapacityCPUCoreSeconds: nodeCap,
+		NodeCapacityCPUCores:       coreCount,
+	})
 	assert.NoError(t, err)
 
 	var count int
@@ -165,7 +331,7 @@ func TestInsertPodMetric(t *testing.T) {
 	assert.Equal(t, 1, count, "Expected one row in pod_metrics")
 }
 
-func TestUpdatePodDailySummary(t *testing.T) {
+func TestRefreshPodDailySummaries(t *testing.T) {
 	pool, newTx := testutils.SetupTestDB(t)
 	tx := newTx()
 	defer tx.Rollback(context.Background())
@@ -181,26 +347,98 @@ func TestUpdatePodDailySummary(t *testing.T) {
 	component := "EAP"
 
 	// Ensure node exists
-	nodeID, err := repo.UpsertNode(clusterID, nodeName, identifier, nodeRole)
+	nodeID, err := upsertNode(repo, clusterID, nodeName, identifier, nodeRole)
 	require.NoError(t, err)
 
 	// Insert pod to satisfy foreign key constraint
-	podID, err := repo.UpsertPod(clusterID, nodeID, podName, namespace, component)
+	podID, err := upsertPod(repo, clusterID, nodeID, podName, namespace, component, nil)
 	require.NoError(t, err)
 
-	timestamp, _ := time.Parse("2006-01-02 15:04:05 +0000 MST", "2025-05-17 14:00:00 +0000 UTC")
-	effectiveCoreSeconds := 200.0
-	coreUsage := 0.013888 // 200 / 14400
-
-	err = repo.UpdatePodDailySummary(podID, timestamp, effectiveCoreSeconds, coreUsage)
+	now := time.Now().UTC()
+	day := time.Date(now.Year(), now.Month(), 15, 0, 0, 0, 0, time.UTC)
+	timestamp := day.Add(14 * time.Hour)
+
+	// Re-sending the same sample replaces it rather than adding to it
+	for i := 0; i < 2; i++ {
+		err = insertPodMetric(repo, PodMetricRow{
+			PodID:                         podID,
+			Timestamp:                     timestamp,
+			PodUsage:                      100.0,
+			PodRequest:                    200.0,
+			NodeCapacityCPUCoreSeconds:    14400.0,
+			NodeCapacityCPUCores:          4,
+			PodUsageMemory:                2 * 3865470566400.0, // 2 GiB for an hour
+			PodRequestMemory:              3865470566400.0,
+			PodLimitMemory:                4 * 3865470566400.0,
+			NodeCapacityMemoryBytes:       17179869184,
+			NodeCapacityMemoryByteSeconds: 61847529062400,
+		})
+		require.NoError(t, err)
+		err = repo.RefreshPodDailySummaries(clusterID, day)
+		assert.NoError(t, err)
+	}
+
+	var totalHours float64
+	var maxCoresUsed, effectiveCoreSeconds, memoryGiBHours float64
+	err = tx.QueryRow(context.Background(), "SELECT total_hours, max_cores_used, total_pod_effective_core_seconds, total_pod_effective_memory_gib_hours FROM pod_daily_summary WHERE pod_id = $1 AND date = $2", podID, day).Scan(&totalHours, &maxCoresUsed, &effectiveCoreSeconds, &memoryGiBHours)
 	assert.NoError(t, err)
+	assert.InDelta(t, 2.0, memoryGiBHours, 0.000001) // usage exceeds request
+	assert.Equal(t, 1.0, totalHours)
+	assert.InDelta(t, 200.0, effectiveCoreSeconds, 0.000001)
+	assert.InDelta(t, 0.013888, maxCoresUsed, 0.000001) // 200 / 14400
+}
 
-	var totalHours int
-	var maxCoresUsed float64
-	err = tx.QueryRow(context.Background(), "SELECT total_hours FROM pod_daily_summary WHERE pod_id = $1 AND date = $2", podID, "2025-05-17").Scan(&totalHours)
-	assert.NoError(t, err)
-	err = tx.QueryRow(context.Background(), "SELECT max_cores_used FROM pod_daily_summary WHERE pod_id = $1 AND date = $2", podID, "2025-05-17").Scan(&maxCoresUsed)
+func TestWithTxRollsBackOnError(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+
+	var nodeID uuid.UUID
+	err := repo.WithTx(context.Background(), func(txRepo *Repository) error {
+		var err error
+		nodeID, err = upsertNode(txRepo, clusterID, "rollback-node", "i-rollback", "worker")
+		require.NoError(t, err)
+		return errors.New("abort")
+	})
+	assert.EqualError(t, err, "abort")
+
+	var count int
+	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM nodes WHERE id = $1", nodeID).Scan(&count)
 	assert.NoError(t, err)
-	assert.Equal(t, 1, totalHours)
-	assert.InDelta(t, 0.013888, maxCoresUsed, 0.000001)
+	assert.Equal(t, 0, count, "Node should not exist after rollback")
+}
+
+func TestQueryPodMetricsLabelKeys(t *testing.T) {
+	pool, _ := testutils.SetupTestDB(t)
+
+	repo := NewRepository(pool)
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	nodeID, err := upsertNode(repo, clusterID, "ip-10-0-1-63.ec2.internal", "i-09ad6102842b9a786", "worker")
+	require.NoError(t, err)
+
+	day := time.Date(time.Now().UTC().Year(), time.Now().UTC().Month(), 15, 0, 0, 0, 0, time.UTC)
+	for name, labels := range map[string]map[string]string{
+		"zip-1": {"label_rht_comp": "EAP", "label_app": "zip"},
+		"web-1": {"label_app": "web"},
+	} {
+		podID, err := upsertPod(repo, clusterID, nodeID, name, "test", labels["label_rht_comp"], labels)
+		require.NoError(t, err)
+		require.NoError(t, insertPodMetric(repo, PodMetricRow{PodID: podID, Timestamp: day.Add(14 * time.Hour), PodUsage: 1800, PodRequest: 3600, NodeCapacityCPUCoreSeconds: 14400, NodeCapacityCPUCores: 4}))
+	}
+	require.NoError(t, repo.RefreshPodDailySummaries(clusterID, day))
+
+	// Every pod is summarized; label keys only filter the query
+	summaries, total, err := repo.QueryPodMetrics(day, day, "", "", "", "", "", []string{"label_rht_comp"}, nil, 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "zip-1", summaries[0].PodName)
+	assert.Equal(t, map[string]string{"label_rht_comp": "EAP", "label_app": "zip"}, summaries[0].Labels)
+
+	_, total, err = repo.QueryPodMetrics(day, day, "", "", "", "", "", nil, nil, 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total)
 }
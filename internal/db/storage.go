@@ -0,0 +1,220 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// PVCKey identifies a persistent volume claim to insert or update in bulk
+type PVCKey struct {
+	ClusterID        uuid.UUID
+	Namespace        string
+	Name             string
+	PersistentVolume string
+	StorageClass     string
+}
+
+// StorageMetricRow is a persistent volume claim sample destined for storage_metrics
+type StorageMetricRow struct {
+	PVCID               uuid.UUID
+	Timestamp           time.Time
+	CapacityBytes       int64
+	CapacityByteSeconds float64
+	RequestByteSeconds  float64
+	UsageByteSeconds    float64
+	// IntervalSeconds is the length of the sample's interval; zero means an hour
+	IntervalSeconds float64
+}
+
+// StorageDailySummary represents a row in the storage_daily_summary table
+type StorageDailySummary struct {
+	Date                     time.Time
+	ClusterID                uuid.UUID
+	ClusterName              string
+	Namespace                string
+	PersistentVolumeClaim    string
+	PersistentVolume         string
+	StorageClass             string
+	CapacityBytes            int64
+	TotalCapacityByteSeconds float64
+	TotalRequestByteSeconds  float64
+	TotalUsageByteSeconds    float64
+	TotalHours               float64
+}
+
+const upsertPVCQuery = `
+	INSERT INTO persistent_volume_claims (id, cluster_id, namespace, name, persistent_volume, storage_class)
+	VALUES (gen_random_uuid(), $1, $2, $3, $4, $5)
+	ON CONFLICT (name, namespace, cluster_id) DO UPDATE
+	SET persistent_volume = EXCLUDED.persistent_volume, storage_class = EXCLUDED.storage_class
+	RETURNING id`
+
+// UpsertPVCs inserts or updates persistent volume claims in a single round trip, returning their IDs in input order
+func (r *Repository) UpsertPVCs(ctx context.Context, pvcs []PVCKey) ([]uuid.UUID, error) {
+	batch := &pgx.Batch{}
+	for _, p := range pvcs {
+		batch.Queue(upsertPVCQuery, p.ClusterID, p.Namespace, p.Name, p.PersistentVolume, p.StorageClass)
+	}
+	return r.sendUpsertBatch(ctx, batch, "persistent_volume_claims")
+}
+
+// CopyStorageMetrics loads claim samples into a staging table with COPY and merges them
+// into storage_metrics. A claim mounted by several pods is reported once per pod with the
+// same values, so it is stored once per interval; the last sample wins.
+func (r *Repository) CopyStorageMetrics(ctx context.Context, rows []StorageMetricRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `
+			CREATE TEMP TABLE IF NOT EXISTS storage_metrics_staging (
+				seq INTEGER,
+				pvc_id UUID,
+				timestamp TIMESTAMPTZ,
+				capacity_bytes BIGINT,
+				capacity_byte_seconds DOUBLE PRECISION,
+				request_byte_seconds DOUBLE PRECISION,
+				usage_byte_seconds DOUBLE PRECISION,
+				interval_seconds DOUBLE PRECISION
+			) ON COMMIT DROP;
+			TRUNCATE storage_metrics_staging`)
+		if err != nil {
+			return fmt.Errorf("failed to create storage_metrics staging table: %w", err)
+		}
+
+		_, err = tx.CopyFrom(ctx,
+			pgx.Identifier{"storage_metrics_staging"},
+			[]string{"seq", "pvc_id", "timestamp", "capacity_bytes", "capacity_byte_seconds",
+				"request_byte_seconds", "usage_byte_seconds", "interval_seconds"},
+			pgx.CopyFromSlice(len(rows), func(i int) ([]any, error) {
+				row := rows[i]
+				return []any{i, row.PVCID, row.Timestamp, row.CapacityBytes, row.CapacityByteSeconds,
+					row.RequestByteSeconds, row.UsageByteSeconds, intervalSeconds(row.IntervalSeconds)}, nil
+			}))
+		if err != nil {
+			return fmt.Errorf("failed to copy storage_metrics: %w", err)
+		}
+
+		_, err = tx.Exec(ctx, `
+			INSERT INTO storage_metrics (
+				pvc_id, timestamp, capacity_bytes, capacity_byte_seconds, request_byte_seconds, usage_byte_seconds,
+				interval_seconds
+			)
+			SELECT DISTINCT ON (pvc_id, timestamp)
+				pvc_id, timestamp, capacity_bytes, capacity_byte_seconds, request_byte_seconds, usage_byte_seconds,
+				interval_seconds
+			FROM storage_metrics_staging
+			ORDER BY pvc_id, timestamp, seq DESC
+			ON CONFLICT (pvc_id, timestamp) DO UPDATE
+			SET capacity_bytes = EXCLUDED.capacity_bytes,
+			    capacity_byte_seconds = EXCLUDED.capacity_byte_seconds,
+			    request_byte_seconds = EXCLUDED.request_byte_seconds,
+			    usage_byte_seconds = EXCLUDED.usage_byte_seconds,
+			    interval_seconds = EXCLUDED.interval_seconds`)
+		if err != nil {
+			return fmt.Errorf("failed to merge storage_metrics: %w", err)
+		}
+		return nil
+	})
+}
+
+// RefreshStorageDailySummaries rebuilds storage_daily_summary for a cluster and day from
+// storage_metrics, so refreshing the same day again gives the same result. Hours are the
+// summed interval lengths. Days are bounded as in RefreshNodeDailySummaries.
+func (r *Repository) RefreshStorageDailySummaries(clusterID uuid.UUID, date time.Time) error {
+	ctx := context.Background()
+	day := calendarDay(date)
+	return pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		_, err := r.rebuildSummaries(ctx, tx, storageSummaries, day, day, clusterID)
+		return err
+	})
+}
+
+func (r *Repository) QueryStorageMetrics(start, end time.Time, clusterID, clusterName, namespace, storageClass string, limit, offset int) ([]StorageDailySummary, int, error) {
+	where := " WHERE ds.date BETWEEN $1 AND $2"
+	args := []interface{}{start, end}
+	if clusterID != "" {
+		where += " AND c.id::text = $" + fmt.Sprint(len(args)+1)
+		args = append(args, clusterID)
+	}
+	if clusterName != "" {
+		where += " AND c.name ILIKE $" + fmt.Sprint(len(args)+1)
+		args = append(args, "%"+clusterName+"%")
+	}
+	if namespace != "" {
+		where += " AND pvc.namespace ILIKE $" + fmt.Sprint(len(args)+1)
+		args = append(args, "%"+namespace+"%")
+	}
+	if storageClass != "" {
+		where += " AND pvc.storage_class = $" + fmt.Sprint(len(args)+1)
+		args = append(args, storageClass)
+	}
+
+	from := `
+		FROM storage_daily_summary ds
+		JOIN persistent_volume_claims pvc ON ds.pvc_id = pvc.id
+		JOIN clusters c ON pvc.cluster_id = c.id`
+
+	// Count total records
+	var total int
+	err := r.db.QueryRow(context.Background(), "SELECT COUNT(*)"+from+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count storage_daily_summary: %w", err)
+	}
+
+	// Query with pagination
+	query := `
+		SELECT
+			ds.date,
+			c.id AS cluster_id,
+			c.name AS cluster_name,
+			pvc.namespace,
+			pvc.name AS persistent_volume_claim,
+			pvc.persistent_volume,
+			pvc.storage_class,
+			ds.capacity_bytes,
+			ds.total_capacity_byte_seconds,
+			ds.total_request_byte_seconds,
+			ds.total_usage_byte_seconds,
+			ds.total_hours` + from + where
+	query += fmt.Sprintf(" ORDER BY ds.date LIMIT $%d OFFSET $%d", len(args)+1, len(args)+2)
+	args = append(args, limit, offset)
+
+	rows, err := r.db.Query(context.Background(), query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query storage_daily_summary: %w", err)
+	}
+	defer rows.Close()
+
+	var summaries []StorageDailySummary
+	for rows.Next() {
+		var s StorageDailySummary
+		if err := rows.Scan(
+			&s.Date,
+			&s.ClusterID,
+			&s.ClusterName,
+			&s.Namespace,
+			&s.PersistentVolumeClaim,
+			&s.PersistentVolume,
+			&s.StorageClass,
+			&s.CapacityBytes,
+			&s.TotalCapacityByteSeconds,
+			&s.TotalRequestByteSeconds,
+			&s.TotalUsageByteSeconds,
+			&s.TotalHours,
+		); err != nil {
+			return nil, 0, fmt.Errorf("failed to scan row: %w", err)
+		}
+		summaries = append(summaries, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return summaries, total, nil
+}
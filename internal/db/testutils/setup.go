@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -46,7 +47,8 @@ func SetupTestDB(t *testing.T) (*pgxpool.Pool, func() pgx.Tx) {
 	require.NoError(t, err)
 
 	_, err = tx.Exec(context.Background(), `
-		DROP TABLE IF EXISTS pod_daily_summary, pod_metrics, pods, 
+		DROP TABLE IF EXISTS pending_node_summary_rebuilds, pod_placements, ingestion_rules, component_rules, upload_files, uploads, node_labels, namespace_labels, storage_daily_summary, storage_metrics,
+		persistent_volume_claims, pod_daily_summary, pod_metrics, pods,
 		node_daily_summary, node_metrics, nodes, clusters CASCADE
 	`)
 	require.NoError(t, err)
@@ -57,11 +59,16 @@ func SetupTestDB(t *testing.T) (*pgxpool.Pool, func() pgx.Tx) {
 	}
 	currentDir := filepath.Dir(currentFile)
 
-	schemaPath := filepath.Join(currentDir, "..", "migrations", "0001_init.up.sql")
-	schema, err := os.ReadFile(schemaPath)
-	require.NoError(t, err)
-	_, err = tx.Exec(context.Background(), string(schema))
+	// Apply every up migration in order
+	migrations, err := filepath.Glob(filepath.Join(currentDir, "..", "migrations", "*.up.sql"))
 	require.NoError(t, err)
+	sort.Strings(migrations)
+	for _, schemaPath := range migrations {
+		schema, err := os.ReadFile(schemaPath)
+		require.NoError(t, err)
+		_, err = tx.Exec(context.Background(), string(schema))
+		require.NoError(t, err)
+	}
 
 	// Insert test data
 	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
@@ -84,14 +91,18 @@ func SetupTestDB(t *testing.T) (*pgxpool.Pool, func() pgx.Tx) {
 
 	partitionNameNode := fmt.Sprintf("node_metrics_%d%02d", year, int(month))
 	partitionNamePod := fmt.Sprintf("pod_metrics_%d%02d", year, int(month))
+	partitionNameStorage := fmt.Sprintf("storage_metrics_%d%02d", year, int(month))
 
 	sql := fmt.Sprintf(`
 	CREATE TABLE IF NOT EXISTS %s PARTITION OF node_metrics
 		FOR VALUES FROM ('%s') TO ('%s');
 	CREATE TABLE IF NOT EXISTS %s PARTITION OF pod_metrics
+		FOR VALUES FROM ('%s') TO ('%s');
+	CREATE TABLE IF NOT EXISTS %s PARTITION OF storage_metrics
 		FOR VALUES FROM ('%s') TO ('%s');`,
 		partitionNameNode, start.Format("2006-01-02"), end.Format("2006-01-02"),
-		partitionNamePod, start.Format("2006-01-02"), end.Format("2006-01-02"))
+		partitionNamePod, start.Format("2006-01-02"), end.Format("2006-01-02"),
+		partitionNameStorage, start.Format("2006-01-02"), end.Format("2006-01-02"))
 
 	_, err = tx.Exec(context.Background(), sql)
 	require.NoError(t, err)
@@ -107,7 +118,8 @@ func SetupTestDB(t *testing.T) (*pgxpool.Pool, func() pgx.Tx) {
 			t.Fatalf("Failed to begin cleanup transaction: %v", err)
 		}
 		_, err = tx.Exec(context.Background(), `
-			TRUNCATE TABLE pod_daily_summary, pod_metrics, pods, 
+			TRUNCATE TABLE upload_files, uploads, node_labels, namespace_labels, storage_daily_summary, storage_metrics,
+			persistent_volume_claims, pod_daily_summary, pod_metrics, pods,
 			node_daily_summary, node_metrics, nodes, clusters CASCADE
 		`)
 		if err != nil {
@@ -0,0 +1,245 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// Upload states tracked in the uploads table
+const (
+	UploadStatusQueued     = "queued"
+	UploadStatusProcessing = "processing"
+	UploadStatusSucceeded  = "succeeded"
+	UploadStatusFailed     = "failed"
+)
+
+// FileStatusSucceeded is the upload_files status of a file whose data was committed
+const FileStatusSucceeded = "succeeded"
+
+// ErrUploadNotFound is returned when no upload exists for the requested ID
+var ErrUploadNotFound = errors.New("upload not found")
+
+// Upload represents a row in the uploads table together with its per-file results.
+// The operator fields and Manifest come from the upload's manifest.json.
+type Upload struct {
+	ID              uuid.UUID
+	Status          string
+	ClusterID       *uuid.UUID
+	ManifestUUID    *uuid.UUID
+	OperatorVersion string
+	OperatorCommit  string
+	ClusterVersion  string
+	ManifestDate    *time.Time
+	ReportStart     *time.Time
+	ReportEnd       *time.Time
+	Certified       *bool
+	Manifest        json.RawMessage
+	Error           string
+	CreatedAt       time.Time
+	StartedAt       *time.Time
+	CompletedAt     *time.Time
+	RowsProcessed   int
+	RowsRejected    int
+	RowsSkipped     int
+	Files           []UploadFile
+}
+
+// UploadFile represents a row in the upload_files table. ReportType is the kind of
+// report detected in the file. RowsProcessed counts the rows stored; Reasons and
+// Samples explain the rejected and skipped rows.
+type UploadFile struct {
+	Name          string
+	ReportType    string
+	Status        string
+	Checksum      string
+	RowsProcessed int
+	RowsRejected  int
+	RowsSkipped   int
+	Reasons       map[string]int
+	Samples       []UploadFileSample
+	Error         string
+}
+
+// UploadFileSample is an offending row kept in an upload file's report
+type UploadFileSample struct {
+	Line    int
+	Reason  string
+	Message string
+	Row     string
+}
+
+// uploadFileReport is the JSON stored in upload_files.report
+type uploadFileReport struct {
+	Reasons map[string]int
+	Samples []UploadFileSample
+}
+
+// CreateUpload records a newly received upload in the queued state
+func (r *Repository) CreateUpload() (uuid.UUID, error) {
+	var id uuid.UUID
+	err := r.db.QueryRow(context.Background(),
+		`INSERT INTO uploads (id, status) VALUES (gen_random_uuid(), $1) RETURNING id`,
+		UploadStatusQueued).Scan(&id)
+	return id, err
+}
+
+// MarkUploadProcessing moves a queued upload into the processing state
+func (r *Repository) MarkUploadProcessing(id uuid.UUID) error {
+	_, err := r.db.Exec(context.Background(),
+		`UPDATE uploads SET status = $2, started_at = now() WHERE id = $1`,
+		id, UploadStatusProcessing)
+	return err
+}
+
+// CompleteUpload stores the final state of an upload and its per-file results
+func (r *Repository) CompleteUpload(u *Upload) error {
+	ctx := context.Background()
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx,
+		`UPDATE uploads
+		 SET status = $2, cluster_id = $3, manifest_uuid = $4, error = NULLIF($5, ''), completed_at = now(),
+		     operator_version = NULLIF($6, ''), operator_commit = NULLIF($7, ''), cluster_version = NULLIF($8, ''),
+		     manifest_date = $9, report_start = $10, report_end = $11, certified = $12, manifest = $13
+		 WHERE id = $1`,
+		u.ID, u.Status, u.ClusterID, u.ManifestUUID, u.Error,
+		u.OperatorVersion, u.OperatorCommit, u.ClusterVersion,
+		u.ManifestDate, u.ReportStart, u.ReportEnd, u.Certified, []byte(u.Manifest))
+	if err != nil {
+		return fmt.Errorf("failed to update upload %s: %w", u.ID, err)
+	}
+
+	for _, f := range u.Files {
+		report, err := json.Marshal(uploadFileReport{Reasons: f.Reasons, Samples: f.Samples})
+		if err != nil {
+			return fmt.Errorf("failed to encode report for file %s: %w", f.Name, err)
+		}
+		_, err = tx.Exec(ctx,
+			`INSERT INTO upload_files (upload_id, name, status, checksum, rows_processed, rows_rejected, rows_skipped, report, error, report_type)
+			 VALUES ($1, $2, $3, NULLIF($4, ''), $5, $6, $7, $8, NULLIF($9, ''), NULLIF($10, ''))
+			 ON CONFLICT (upload_id, name) DO UPDATE
+			 SET status = EXCLUDED.status, checksum = EXCLUDED.checksum, report_type = EXCLUDED.report_type,
+			     rows_processed = EXCLUDED.rows_processed, rows_rejected = EXCLUDED.rows_rejected,
+			     rows_skipped = EXCLUDED.rows_skipped, report = EXCLUDED.report, error = EXCLUDED.error`,
+			u.ID, f.Name, f.Status, f.Checksum, f.RowsProcessed, f.RowsRejected, f.RowsSkipped, report, f.Error, f.ReportType)
+		if err != nil {
+			return fmt.Errorf("failed to record file %s for upload %s: %w", f.Name, u.ID, err)
+		}
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ManifestIngested reports whether an upload with the given manifest UUID was already processed successfully
+func (r *Repository) ManifestIngested(manifestUUID uuid.UUID) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(context.Background(),
+		`SELECT EXISTS (
+			SELECT 1 FROM uploads WHERE manifest_uuid = $1 AND status = $2
+		)`, manifestUUID, UploadStatusSucceeded).Scan(&exists)
+	return exists, err
+}
+
+// FileIngested reports whether a file with the given checksum was already ingested for a
+// cluster. Only files of uploads that succeeded count, so a file whose upload failed, and
+// whose data may have been rolled back with it, is processed again on retry.
+func (r *Repository) FileIngested(clusterID uuid.UUID, checksum string) (bool, error) {
+	var exists bool
+	err := r.db.QueryRow(context.Background(),
+		`SELECT EXISTS (
+			SELECT 1
+			FROM upload_files f
+			JOIN uploads u ON f.upload_id = u.id
+			WHERE u.cluster_id = $1 AND f.checksum = $2 AND f.status = $3 AND u.status = $4
+		)`, clusterID, checksum, FileStatusSucceeded, UploadStatusSucceeded).Scan(&exists)
+	return exists, err
+}
+
+// FailInterruptedUploads marks uploads left queued or processing by a previous run as failed
+func (r *Repository) FailInterruptedUploads() (int64, error) {
+	tag, err := r.db.Exec(context.Background(),
+		`UPDATE uploads
+		 SET status = $1, error = 'interrupted by server restart', completed_at = now()
+		 WHERE status IN ($2, $3)`,
+		UploadStatusFailed, UploadStatusQueued, UploadStatusProcessing)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// GetUpload returns an upload and its per-file results
+func (r *Repository) GetUpload(id uuid.UUID) (*Upload, error) {
+	var u Upload
+	var errMsg sql.NullString
+	var manifest []byte
+	err := r.db.QueryRow(context.Background(),
+		`SELECT id, status, cluster_id, manifest_uuid, error, created_at, started_at, completed_at,
+		        COALESCE(operator_version, ''), COALESCE(operator_commit, ''), COALESCE(cluster_version, ''),
+		        manifest_date, report_start, report_end, certified, manifest
+		 FROM uploads WHERE id = $1`, id).Scan(
+		&u.ID, &u.Status, &u.ClusterID, &u.ManifestUUID, &errMsg, &u.CreatedAt, &u.StartedAt, &u.CompletedAt,
+		&u.OperatorVersion, &u.OperatorCommit, &u.ClusterVersion,
+		&u.ManifestDate, &u.ReportStart, &u.ReportEnd, &u.Certified, &manifest)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrUploadNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload %s: %w", id, err)
+	}
+	u.Error = errMsg.String
+	u.Manifest = manifest
+
+	rows, err := r.db.Query(context.Background(),
+		`SELECT name, COALESCE(report_type, ''), status, COALESCE(checksum, ''), rows_processed, rows_rejected, rows_skipped, report, error
+		 FROM upload_files WHERE upload_id = $1 ORDER BY name`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload_files: %w", err)
+	}
+	defer rows.Close()
+
+	u.Files = []UploadFile{}
+	for rows.Next() {
+		var f UploadFile
+		var fileErr sql.NullString
+		var report []byte
+		if err := rows.Scan(&f.Name, &f.ReportType, &f.Status, &f.Checksum, &f.RowsProcessed, &f.RowsRejected, &f.RowsSkipped, &report, &fileErr); err != nil {
+			return nil, fmt.Errorf("failed to scan row: %w", err)
+		}
+		f.Error = fileErr.String
+		f.Reasons = map[string]int{}
+		f.Samples = []UploadFileSample{}
+		if report != nil {
+			var decoded uploadFileReport
+			if err := json.Unmarshal(report, &decoded); err != nil {
+				return nil, fmt.Errorf("failed to decode report for file %s: %w", f.Name, err)
+			}
+			if decoded.Reasons != nil {
+				f.Reasons = decoded.Reasons
+			}
+			if decoded.Samples != nil {
+				f.Samples = decoded.Samples
+			}
+		}
+		u.RowsProcessed += f.RowsProcessed
+		u.RowsRejected += f.RowsRejected
+		u.RowsSkipped += f.RowsSkipped
+		u.Files = append(u.Files, f)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("row iteration error: %w", err)
+	}
+
+	return &u, nil
+}
@@ -0,0 +1,142 @@
+package db
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db/testutils"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestUploadLifecycle(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	repo := NewRepository(pool)
+
+	uploadID, err := repo.CreateUpload()
+	require.NoError(t, err)
+
+	upload, err := repo.GetUpload(uploadID)
+	require.NoError(t, err)
+	assert.Equal(t, UploadStatusQueued, upload.Status)
+	assert.Empty(t, upload.Files)
+	assert.Nil(t, upload.Manifest)
+	assert.Nil(t, upload.Certified)
+
+	err = repo.MarkUploadProcessing(uploadID)
+	require.NoError(t, err)
+
+	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
+	reportStart := time.Date(2025, 5, 17, 14, 0, 0, 0, time.UTC)
+	certified := true
+	err = repo.CompleteUpload(&Upload{
+		ID:              uploadID,
+		Status:          UploadStatusFailed,
+		ClusterID:       &clusterID,
+		OperatorVersion: "3.2.1",
+		OperatorCommit:  "b5a2c05",
+		ReportStart:     &reportStart,
+		Certified:       &certified,
+		Manifest:        json.RawMessage(`{"operator_version": "3.2.1"}`),
+		Error:           "1 of 2 files failed",
+		Files: []UploadFile{
+			{
+				Name: "data1.csv", ReportType: "pod_usage", Status: "succeeded", Checksum: "abc123", RowsProcessed: 24, RowsRejected: 1,
+				Reasons: map[string]int{"invalid_interval_start": 1},
+				Samples: []UploadFileSample{{Line: 3, Reason: "invalid_interval_start", Message: `invalid interval_start "bad"`, Row: "bad"}},
+			},
+			{Name: "data2.csv", Status: "failed", Checksum: "def456", RowsProcessed: 3, Error: "missing required header: pod"},
+		},
+	})
+	require.NoError(t, err)
+
+	upload, err = repo.GetUpload(uploadID)
+	require.NoError(t, err)
+	assert.Equal(t, UploadStatusFailed, upload.Status)
+	assert.Equal(t, "1 of 2 files failed", upload.Error)
+	assert.Equal(t, &clusterID, upload.ClusterID)
+	assert.Equal(t, "3.2.1", upload.OperatorVersion)
+	assert.Equal(t, "b5a2c05", upload.OperatorCommit)
+	assert.Empty(t, upload.ClusterVersion)
+	require.NotNil(t, upload.ReportStart)
+	assert.True(t, reportStart.Equal(*upload.ReportStart))
+	assert.Nil(t, upload.ReportEnd)
+	assert.Equal(t, &certified, upload.Certified)
+	assert.JSONEq(t, `{"operator_version": "3.2.1"}`, string(upload.Manifest))
+	assert.NotNil(t, upload.StartedAt)
+	assert.NotNil(t, upload.CompletedAt)
+	assert.Equal(t, 27, upload.RowsProcessed)
+	require.Len(t, upload.Files, 2)
+	assert.Equal(t, "missing required header: pod", upload.Files[1].Error)
+	assert.Equal(t, "pod_usage", upload.Files[0].ReportType)
+	assert.Empty(t, upload.Files[1].ReportType)
+	assert.Equal(t, 1, upload.RowsRejected)
+	assert.Equal(t, map[string]int{"invalid_interval_start": 1}, upload.Files[0].Reasons)
+	require.Len(t, upload.Files[0].Samples, 1)
+	assert.Equal(t, 3, upload.Files[0].Samples[0].Line)
+	assert.Empty(t, upload.Files[1].Samples)
+
+	// Only succeeded files of succeeded uploads count as duplicates; a failed upload may
+	// have rolled back the files it reports as succeeded
+	ingested, err := repo.FileIngested(clusterID, "abc123")
+	require.NoError(t, err)
+	assert.False(t, ingested)
+
+	retryID, err := repo.CreateUpload()
+	require.NoError(t, err)
+	err = repo.CompleteUpload(&Upload{
+		ID:        retryID,
+		Status:    UploadStatusSucceeded,
+		ClusterID: &clusterID,
+		Files: []UploadFile{
+			{Name: "data1.csv", ReportType: "pod_usage", Status: FileStatusSucceeded, Checksum: "abc123", RowsProcessed: 24},
+			{Name: "data2.csv", Status: "failed", Checksum: "def456", Error: "missing required header: pod"},
+		},
+	})
+	require.NoError(t, err)
+	ingested, err = repo.FileIngested(clusterID, "abc123")
+	require.NoError(t, err)
+	assert.True(t, ingested)
+	ingested, err = repo.FileIngested(clusterID, "def456")
+	require.NoError(t, err)
+	assert.False(t, ingested)
+}
+
+func TestManifestIngested(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	repo := NewRepository(pool)
+	manifestUUID := uuid.New()
+
+	ingested, err := repo.ManifestIngested(manifestUUID)
+	require.NoError(t, err)
+	assert.False(t, ingested)
+
+	uploadID, err := repo.CreateUpload()
+	require.NoError(t, err)
+	err = repo.CompleteUpload(&Upload{ID: uploadID, Status: UploadStatusSucceeded, ManifestUUID: &manifestUUID})
+	require.NoError(t, err)
+
+	ingested, err = repo.ManifestIngested(manifestUUID)
+	require.NoError(t, err)
+	assert.True(t, ingested)
+}
+
+func TestGetUploadNotFound(t *testing.T) {
+	pool, newTx := testutils.SetupTestDB(t)
+	tx := newTx()
+	defer tx.Rollback(context.Background())
+
+	repo := NewRepository(pool)
+
+	_, err := repo.GetUpload(uuid.New())
+	assert.ErrorIs(t, err, ErrUploadNotFound)
+}
@@ -0,0 +1,151 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// SummaryMismatch is a cluster and day whose rows in a daily summary table differ from the
+// rows recomputed from the raw metrics. MismatchedRows counts rows that are missing, extra
+// or hold different values; Values compares each column summed over the cluster and day.
+type SummaryMismatch struct {
+	Summary        string                     `json:"summary"`
+	ClusterID      uuid.UUID                  `json:"cluster_id"`
+	Date           time.Time                  `json:"date"`
+	ExpectedRows   int                        `json:"expected_rows"`
+	ActualRows     int                        `json:"actual_rows"`
+	MismatchedRows int                        `json:"mismatched_rows"`
+	Values         map[string]ValueDifference `json:"values"`
+}
+
+// ValueDifference is the stored and the recomputed sum of a summary column
+type ValueDifference struct {
+	Expected float64 `json:"expected"`
+	Actual   float64 `json:"actual"`
+	// Difference is Actual minus Expected
+	Difference float64 `json:"difference"`
+}
+
+// VerifyDailySummaries compares node_daily_summary, pod_daily_summary and
+// storage_daily_summary with the rows RebuildDailySummaries would write for the calendar
+// days first to last, inclusive, of every cluster or of clusterID when it is not uuid.Nil.
+// Values differing by more than one part in a million count as mismatches. With repair,
+// the summaries of every mismatched cluster and day are rebuilt in one transaction.
+func (r *Repository) VerifyDailySummaries(ctx context.Context, first, last time.Time, clusterID uuid.UUID, repair bool) ([]SummaryMismatch, error) {
+	first, last = calendarDay(first), calendarDay(last)
+	if last.Before(first) {
+		return nil, fmt.Errorf("invalid date range: %s is before %s", last.Format("2006-01-02"), first.Format("2006-01-02"))
+	}
+
+	var mismatches []SummaryMismatch
+	for _, t := range summaryTables {
+		found, err := r.verifySummaries(ctx, t, first, last, clusterID)
+		if err != nil {
+			return nil, err
+		}
+		mismatches = append(mismatches, found...)
+	}
+	if !repair || len(mismatches) == 0 {
+		return mismatches, nil
+	}
+
+	repaired := make(map[clusterDay]bool)
+	var days []clusterDay
+	for _, m := range mismatches {
+		day := clusterDay{m.ClusterID, m.Date}
+		if !repaired[day] {
+			repaired[day] = true
+			days = append(days, day)
+		}
+	}
+	sortClusterDays(days)
+	err := pgx.BeginFunc(ctx, r.db, func(tx pgx.Tx) error {
+		for _, day := range days {
+			for _, t := range summaryTables {
+				if _, err := r.rebuildSummaries(ctx, tx, t, day.date, day.date, day.cluster); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return mismatches, fmt.Errorf("failed to repair summaries: %w", err)
+	}
+	return mismatches, nil
+}
+
+// verifySummaries compares one summary table with its derivation row by row and reports
+// the clusters and days with differences
+func (r *Repository) verifySummaries(ctx context.Context, t summaryTable, first, last time.Time, clusterID uuid.UUID) ([]SummaryMismatch, error) {
+	join := []string{"e.date = a.date"}
+	for _, k := range t.key {
+		join = append(join, "e."+k+" = a."+k)
+	}
+	var compared, sums, differs []string
+	for _, v := range t.values {
+		compared = append(compared, "e."+v+"::float8 AS expected_"+v, "a."+v+"::float8 AS actual_"+v)
+		sums = append(sums, "COALESCE(SUM(expected_"+v+"), 0)", "COALESCE(SUM(actual_"+v+"), 0)")
+		differs = append(differs, "ABS(COALESCE(expected_"+v+", 0) - COALESCE(actual_"+v+", 0)) > 1e-6 * GREATEST(1, ABS(COALESCE(expected_"+v+", 0)))")
+	}
+	mismatched := "NOT in_expected OR NOT in_actual OR " + strings.Join(differs, " OR ")
+
+	owner := t.key[0]
+	query := `
+		WITH expected AS (` + t.derive(r) + `),
+		actual AS (
+			SELECT ds.* FROM ` + t.table() + ` ds
+			JOIN ` + t.owner + ` o ON ds.` + owner + ` = o.id
+			WHERE ds.date BETWEEN $1 AND $2 AND ($3::uuid IS NULL OR o.cluster_id = $3)
+		),
+		compared AS (
+			SELECT COALESCE(e.` + owner + `, a.` + owner + `) AS owner_id, COALESCE(e.date, a.date) AS date,
+			       e.date IS NOT NULL AS in_expected, a.date IS NOT NULL AS in_actual,
+			       ` + strings.Join(compared, ", ") + `
+			FROM expected e FULL OUTER JOIN actual a ON ` + strings.Join(join, " AND ") + `
+		)
+		SELECT o.cluster_id, cmp.date,
+		       COUNT(*) FILTER (WHERE in_expected), COUNT(*) FILTER (WHERE in_actual),
+		       COUNT(*) FILTER (WHERE ` + mismatched + `),
+		       ` + strings.Join(sums, ", ") + `
+		FROM compared cmp
+		JOIN ` + t.owner + ` o ON cmp.owner_id = o.id
+		GROUP BY o.cluster_id, cmp.date
+		HAVING COUNT(*) FILTER (WHERE ` + mismatched + `) > 0
+		ORDER BY cmp.date, o.cluster_id`
+
+	rows, err := r.db.Query(ctx, query, r.summaryRange(first, last, clusterID)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify %s: %w", t.table(), err)
+	}
+	defer rows.Close()
+
+	var mismatches []SummaryMismatch
+	for rows.Next() {
+		var cluster uuid.NullUUID
+		m := SummaryMismatch{Summary: t.name, Values: make(map[string]ValueDifference)}
+		values := make([]float64, 2*len(t.values))
+		dest := []any{&cluster, &m.Date, &m.ExpectedRows, &m.ActualRows, &m.MismatchedRows}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return nil, fmt.Errorf("failed to scan %s verification: %w", t.table(), err)
+		}
+		m.ClusterID = cluster.UUID
+		for i, v := range t.values {
+			expected, actual := values[2*i], values[2*i+1]
+			m.Values[v] = ValueDifference{Expected: expected, Actual: actual, Difference: actual - expected}
+		}
+		mismatches = append(mismatches, m)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to verify %s: %w", t.table(), err)
+	}
+	return mismatches, nil
+}
@@ -0,0 +1,98 @@
+package ingest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// ProcessUpload returns a HandlerFunc that processes a saved upload and
+// records its outcome in the uploads table. The job's directory is removed afterwards.
+func ProcessUpload(database *pgxpool.Pool, opts processor.Options) HandlerFunc {
+	return func(ctx context.Context, job Job) {
+		defer os.RemoveAll(filepath.Dir(job.Path))
+
+		repo := db.NewRepository(database)
+		if err := repo.MarkUploadProcessing(job.UploadID); err != nil {
+			log.Printf("Failed to mark upload %s as processing: %v", job.UploadID, err)
+		}
+
+		result, err := processor.ProcessUpload(ctx, job.Path, repo, opts, processor.UploadParams{
+			Filename:    job.Filename,
+			ClusterID:   job.ClusterID,
+			ClusterName: job.ClusterName,
+		})
+
+		upload := &db.Upload{ID: job.UploadID, Status: db.UploadStatusSucceeded}
+		if result != nil {
+			if id, parseErr := uuid.Parse(result.ClusterID); parseErr == nil {
+				upload.ClusterID = &id
+			}
+			if id, parseErr := uuid.Parse(result.ManifestUUID); parseErr == nil {
+				upload.ManifestUUID = &id
+			}
+			if m := result.Manifest; m != nil {
+				upload.OperatorVersion = m.OperatorVersion
+				upload.OperatorCommit = m.Version
+				upload.ClusterVersion = m.ClusterVersion
+				upload.ManifestDate = optionalTime(m.Date)
+				upload.ReportStart = optionalTime(m.Start)
+				upload.ReportEnd = optionalTime(m.End)
+				upload.Certified = &m.Certified
+				upload.Manifest = m.Raw
+			}
+			for _, f := range result.Files {
+				file := db.UploadFile{
+					Name:          f.Name,
+					ReportType:    f.ReportType,
+					Status:        f.Status,
+					Checksum:      f.Checksum,
+					RowsProcessed: f.Report.Accepted,
+					RowsRejected:  f.Report.Rejected,
+					RowsSkipped:   f.Report.Skipped,
+					Reasons:       f.Report.Reasons,
+					Error:         f.Error,
+				}
+				for _, sample := range f.Report.Samples {
+					file.Samples = append(file.Samples, db.UploadFileSample{
+						Line:    sample.Line,
+						Reason:  sample.Reason,
+						Message: sample.Message,
+						Row:     sample.Row,
+					})
+				}
+				upload.Files = append(upload.Files, file)
+			}
+			if failed := result.Failed(); failed > 0 {
+				upload.Status = db.UploadStatusFailed
+				upload.Error = fmt.Sprintf("%d of %d files failed", failed, len(result.Files))
+			}
+		}
+		if err != nil {
+			upload.Status = db.UploadStatusFailed
+			upload.Error = err.Error()
+		}
+
+		if err := repo.CompleteUpload(upload); err != nil {
+			log.Printf("Failed to record result for upload %s: %v", job.UploadID, err)
+			return
+		}
+		log.Printf("Upload %s finished with status %s", job.UploadID, upload.Status)
+	}
+}
+
+// optionalTime returns nil for a zero time so absent manifest fields are stored as NULL
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
@@ -0,0 +1,96 @@
+package ingest
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// ErrQueueFull is returned by Submit when the queue cannot accept more jobs
+var ErrQueueFull = errors.New("ingestion queue is full")
+
+// ErrStopped is returned by Submit once the pool has been stopped
+var ErrStopped = errors.New("ingestion worker pool is stopped")
+
+// Job identifies a saved upload waiting to be processed. Filename, ClusterID and
+// ClusterName come from the upload request and describe uploads without a manifest.
+type Job struct {
+	UploadID    uuid.UUID
+	Path        string
+	Filename    string
+	ClusterID   string
+	ClusterName string
+}
+
+// HandlerFunc processes a single job
+type HandlerFunc func(ctx context.Context, job Job)
+
+// WorkerPool runs queued jobs on a fixed number of background workers
+type WorkerPool struct {
+	workers int
+	handle  HandlerFunc
+	jobs    chan Job
+
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewWorkerPool creates a pool with the given number of workers and queue capacity
+func NewWorkerPool(workers, queueSize int, handle HandlerFunc) *WorkerPool {
+	if workers < 1 {
+		workers = 1
+	}
+	if queueSize < 0 {
+		queueSize = 0
+	}
+	return &WorkerPool{
+		workers: workers,
+		handle:  handle,
+		jobs:    make(chan Job, queueSize),
+	}
+}
+
+// Start launches the workers; they run until Stop is called
+func (p *WorkerPool) Start(ctx context.Context) {
+	for i := 0; i < p.workers; i++ {
+		p.wg.Add(1)
+		go func(worker int) {
+			defer p.wg.Done()
+			for job := range p.jobs {
+				log.Printf("Worker %d processing upload %s", worker, job.UploadID)
+				p.handle(ctx, job)
+			}
+		}(i)
+	}
+}
+
+// Submit queues a job without blocking, returning ErrQueueFull when the queue is at capacity
+func (p *WorkerPool) Submit(job Job) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.stopped {
+		return ErrStopped
+	}
+
+	select {
+	case p.jobs <- job:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop stops accepting jobs and waits for queued jobs to finish
+func (p *WorkerPool) Stop() {
+	p.mu.Lock()
+	if !p.stopped {
+		p.stopped = true
+		close(p.jobs)
+	}
+	p.mu.Unlock()
+	p.wg.Wait()
+}
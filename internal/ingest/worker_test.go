@@ -0,0 +1,66 @@
+package ingest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorkerPoolProcessesJobs(t *testing.T) {
+	var mu sync.Mutex
+	processed := make(map[uuid.UUID]bool)
+	pool := NewWorkerPool(2, 10, func(ctx context.Context, job Job) {
+		mu.Lock()
+		defer mu.Unlock()
+		processed[job.UploadID] = true
+	})
+	pool.Start(context.Background())
+
+	var ids []uuid.UUID
+	for i := 0; i < 5; i++ {
+		id := uuid.New()
+		ids = append(ids, id)
+		require.NoError(t, pool.Submit(Job{UploadID: id, Path: "upload.tar.gz"}))
+	}
+
+	// Stop drains the queue before returning
+	pool.Stop()
+
+	for _, id := range ids {
+		assert.True(t, processed[id], "upload %s should have been processed", id)
+	}
+}
+
+func TestWorkerPoolQueueFull(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{}, 1)
+	pool := NewWorkerPool(1, 1, func(ctx context.Context, job Job) {
+		started <- struct{}{}
+		<-release
+	})
+	pool.Start(context.Background())
+
+	// The first job occupies the only worker, the second fills the queue
+	require.NoError(t, pool.Submit(Job{UploadID: uuid.New()}))
+	<-started
+	require.NoError(t, pool.Submit(Job{UploadID: uuid.New()}))
+
+	err := pool.Submit(Job{UploadID: uuid.New()})
+	assert.ErrorIs(t, err, ErrQueueFull)
+
+	close(release)
+	pool.Stop()
+}
+
+func TestWorkerPoolSubmitAfterStop(t *testing.T) {
+	pool := NewWorkerPool(1, 1, func(ctx context.Context, job Job) {})
+	pool.Start(context.Background())
+	pool.Stop()
+
+	err := pool.Submit(Job{UploadID: uuid.New()})
+	assert.ErrorIs(t, err, ErrStopped)
+}
@@ -0,0 +1,148 @@
+// Package ingestrules decides which report rows are ingested from include and exclude
+// rules on the namespace, node role and cluster name of each row.
+package ingestrules
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Rule actions
+const (
+	ActionInclude = "include"
+	ActionExclude = "exclude"
+)
+
+// Fields a rule may match
+const (
+	FieldNamespace   = "namespace"
+	FieldNodeRole    = "node_role"
+	FieldClusterName = "cluster_name"
+)
+
+// Pattern types; glob patterns use path.Match syntax and regex patterns Go regexp
+// syntax, matching anywhere in the value unless anchored
+const (
+	PatternGlob  = "glob"
+	PatternRegex = "regex"
+)
+
+// Rule includes or excludes the rows whose Field matches Pattern. Rules without a
+// ClusterID apply to every cluster.
+type Rule struct {
+	ID          uuid.UUID  `json:"id"`
+	ClusterID   *uuid.UUID `json:"cluster_id"`
+	Action      string     `json:"action"`
+	Field       string     `json:"field"`
+	Pattern     string     `json:"pattern"`
+	PatternType string     `json:"pattern_type"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+// Validate checks the rule's action, field and pattern, defaulting PatternType to glob
+func (r *Rule) Validate() error {
+	if r.PatternType == "" {
+		r.PatternType = PatternGlob
+	}
+	switch r.Action {
+	case ActionInclude, ActionExclude:
+	default:
+		return fmt.Errorf("invalid action %q: must be include or exclude", r.Action)
+	}
+	switch r.Field {
+	case FieldNamespace, FieldNodeRole, FieldClusterName:
+	default:
+		return fmt.Errorf("invalid field %q: must be namespace, node_role or cluster_name", r.Field)
+	}
+	if r.Pattern == "" {
+		return fmt.Errorf("pattern is required")
+	}
+	_, err := r.matcher()
+	return err
+}
+
+func (r *Rule) matcher() (func(string) bool, error) {
+	switch r.PatternType {
+	case PatternGlob:
+		if _, err := path.Match(r.Pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid glob pattern %q: %w", r.Pattern, err)
+		}
+		pattern := r.Pattern
+		return func(s string) bool {
+			ok, _ := path.Match(pattern, s)
+			return ok
+		}, nil
+	case PatternRegex:
+		re, err := regexp.Compile(r.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid regex pattern %q: %w", r.Pattern, err)
+		}
+		return re.MatchString, nil
+	default:
+		return nil, fmt.Errorf("invalid pattern_type %q: must be glob or regex", r.PatternType)
+	}
+}
+
+// fieldRules are the compiled rules of one field
+type fieldRules struct {
+	include []func(string) bool
+	exclude []func(string) bool
+}
+
+// Filter evaluates a set of rules. A value is excluded when an exclude rule on its field
+// matches it, or when its field has include rules and none matches it. A nil Filter
+// excludes nothing.
+type Filter struct {
+	fields map[string]*fieldRules
+}
+
+// Compile prepares rules for evaluation
+func Compile(rules []Rule) (*Filter, error) {
+	f := &Filter{fields: make(map[string]*fieldRules)}
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return nil, fmt.Errorf("ingestion rule %s: %w", rules[i].ID, err)
+		}
+		match, _ := rules[i].matcher()
+		fr := f.fields[rules[i].Field]
+		if fr == nil {
+			fr = &fieldRules{}
+			f.fields[rules[i].Field] = fr
+		}
+		if rules[i].Action == ActionInclude {
+			fr.include = append(fr.include, match)
+		} else {
+			fr.exclude = append(fr.exclude, match)
+		}
+	}
+	return f, nil
+}
+
+// Excludes reports whether a row whose field has value is left out
+func (f *Filter) Excludes(field, value string) bool {
+	if f == nil {
+		return false
+	}
+	fr := f.fields[field]
+	if fr == nil {
+		return false
+	}
+	for _, match := range fr.exclude {
+		if match(value) {
+			return true
+		}
+	}
+	if len(fr.include) == 0 {
+		return false
+	}
+	for _, match := range fr.include {
+		if match(value) {
+			return false
+		}
+	}
+	return true
+}
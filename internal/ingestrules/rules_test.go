@@ -0,0 +1,62 @@
+package ingestrules
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidate(t *testing.T) {
+	rule := Rule{Action: ActionExclude, Field: FieldNamespace, Pattern: "openshift-*"}
+	require.NoError(t, rule.Validate())
+	assert.Equal(t, PatternGlob, rule.PatternType)
+
+	for name, bad := range map[string]Rule{
+		"action":       {Action: "drop", Field: FieldNamespace, Pattern: "a"},
+		"field":        {Action: ActionExclude, Field: "pod", Pattern: "a"},
+		"pattern":      {Action: ActionExclude, Field: FieldNamespace},
+		"glob":         {Action: ActionExclude, Field: FieldNamespace, Pattern: "[a"},
+		"regex":        {Action: ActionExclude, Field: FieldNamespace, Pattern: "(a", PatternType: PatternRegex},
+		"pattern type": {Action: ActionExclude, Field: FieldNamespace, Pattern: "a", PatternType: "sql"},
+	} {
+		assert.Error(t, bad.Validate(), name)
+	}
+}
+
+func TestFilter(t *testing.T) {
+	f, err := Compile([]Rule{
+		{Action: ActionExclude, Field: FieldNamespace, Pattern: "openshift-*"},
+		{Action: ActionExclude, Field: FieldNamespace, Pattern: "^kube-", PatternType: PatternRegex},
+		{Action: ActionInclude, Field: FieldNodeRole, Pattern: "worker"},
+		{Action: ActionInclude, Field: FieldNodeRole, Pattern: "infra"},
+		{Action: ActionExclude, Field: FieldNodeRole, Pattern: "infra"},
+		{Action: ActionExclude, Field: FieldClusterName, Pattern: "test|sandbox", PatternType: PatternRegex},
+	})
+	require.NoError(t, err)
+
+	tests := []struct {
+		field string
+		value string
+		want  bool
+	}{
+		{field: FieldNamespace, value: "openshift-monitoring", want: true},
+		{field: FieldNamespace, value: "kube-system", want: true},
+		{field: FieldNamespace, value: "my-kube-app", want: false},
+		{field: FieldNamespace, value: "payments", want: false},
+		{field: FieldNodeRole, value: "worker", want: false},
+		{field: FieldNodeRole, value: "master", want: true},
+		{field: FieldNodeRole, value: "infra", want: true},
+		{field: FieldClusterName, value: "ci-test-01", want: true},
+		{field: FieldClusterName, value: "production", want: false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, f.Excludes(tt.field, tt.value), "%s %s", tt.field, tt.value)
+	}
+
+	var none *Filter
+	assert.False(t, none.Excludes(FieldNamespace, "openshift-monitoring"))
+
+	_, err = Compile([]Rule{{Action: ActionExclude, Field: FieldNamespace, Pattern: "[a"}})
+	assert.Error(t, err)
+}
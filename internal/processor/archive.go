@@ -0,0 +1,313 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Upload formats recognized from the content of an upload
+const (
+	FormatTarGz  = "tar.gz"
+	FormatTarZst = "tar.zst"
+	FormatZip    = "zip"
+	FormatCSV    = "csv"
+	FormatCSVGz  = "csv.gz"
+	FormatCSVZst = "csv.zst"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	zipMagic  = []byte("PK\x03\x04")
+	zipEmpty  = []byte("PK\x05\x06")
+)
+
+// DetectFormat inspects the start of an upload, decompressing it if needed, and
+// returns one of the Format constants. Anything that is not a recognized archive
+// or compressed stream is treated as a plain CSV.
+func DetectFormat(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to open upload: %w", err)
+	}
+	defer file.Close()
+
+	head := make([]byte, 4)
+	n, err := io.ReadFull(file, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+	head = head[:n]
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", fmt.Errorf("failed to read upload: %w", err)
+	}
+
+	switch {
+	case bytes.HasPrefix(head, zipMagic), bytes.HasPrefix(head, zipEmpty):
+		return FormatZip, nil
+	case bytes.HasPrefix(head, gzipMagic):
+		gzr, err := gzip.NewReader(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		defer gzr.Close()
+		if isTar(gzr) {
+			return FormatTarGz, nil
+		}
+		return FormatCSVGz, nil
+	case bytes.HasPrefix(head, zstdMagic):
+		zr, err := zstd.NewReader(file)
+		if err != nil {
+			return "", fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		defer zr.Close()
+		if isTar(zr) {
+			return FormatTarZst, nil
+		}
+		return FormatCSVZst, nil
+	}
+	return FormatCSV, nil
+}
+
+// isTar reports whether a stream starts with a tar header, whose "ustar" magic sits at offset 257
+func isTar(r io.Reader) bool {
+	block := make([]byte, 512)
+	if _, err := io.ReadFull(r, block); err != nil {
+		return false
+	}
+	return bytes.HasPrefix(block[257:], []byte("ustar"))
+}
+
+// archiveReader walks the files of an upload in order
+type archiveReader interface {
+	// next returns the name and content of the next file, or io.EOF after the last one.
+	// The content is only valid until the following call.
+	next() (string, io.Reader, error)
+	close() error
+}
+
+// openArchive opens an upload of the given format for one pass over its files.
+// A single CSV upload yields one file called csvName. Entry names are checked with
+// checkEntryName and returned cleaned; links and other special entries are rejected.
+// Reading past limits fails with a *LimitError.
+func openArchive(filePath, format, csvName string, limits Limits) (archiveReader, error) {
+	info, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+	lim := &limiter{limits: limits}
+	if format != FormatCSV {
+		lim.compressedSize = info.Size()
+	}
+
+	switch format {
+	case FormatZip:
+		zr, err := zip.OpenReader(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open zip archive: %w", err)
+		}
+		return &zipArchive{zr: zr, lim: lim}, nil
+	}
+
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open upload: %w", err)
+	}
+
+	var content io.Reader = bufio.NewReader(file)
+	closers := []io.Closer{file}
+	switch format {
+	case FormatTarGz, FormatCSVGz:
+		gzr, err := gzip.NewReader(content)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create gzip reader: %w", err)
+		}
+		content = gzr
+		closers = append(closers, gzr)
+	case FormatTarZst, FormatCSVZst:
+		zr, err := zstd.NewReader(content)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to create zstd reader: %w", err)
+		}
+		content = zr
+		closers = append(closers, zr.IOReadCloser())
+	}
+
+	switch format {
+	case FormatTarGz, FormatTarZst:
+		return &tarArchive{tr: tar.NewReader(content), lim: lim, closers: closers}, nil
+	default:
+		return &singleFile{name: csvName, r: content, lim: lim, closers: closers}, nil
+	}
+}
+
+// InspectUpload makes the checks on an upload that are cheap enough for the request that
+// uploads it: it detects the format and reads the header of the first file, so an upload
+// that is not a readable archive or starts with an unsafe entry is rejected before it is
+// queued. File contents are not read. Limits and the remaining entries are checked when
+// the upload is processed, where ProcessUpload fails with a *LimitError or *EntryError.
+func InspectUpload(uploadPath string) (string, error) {
+	format, err := DetectFormat(uploadPath)
+	if err != nil {
+		return "", err
+	}
+	archive, err := openArchive(uploadPath, format, "upload.csv", Limits{})
+	if err != nil {
+		return format, err
+	}
+	defer archive.close()
+
+	if _, _, err := archive.next(); err != nil && err != io.EOF {
+		return format, err
+	}
+	return format, nil
+}
+
+// csvUploadName names the file of a single CSV upload after the uploaded file name,
+// without its compression suffix
+func csvUploadName(filename string) string {
+	name := path.Base(filename)
+	name = strings.TrimSuffix(strings.TrimSuffix(name, ".gz"), ".zst")
+	if name == "." || name == "/" || name == "" {
+		name = "upload"
+	}
+	if !strings.HasSuffix(name, ".csv") {
+		name += ".csv"
+	}
+	return name
+}
+
+type tarArchive struct {
+	tr      *tar.Reader
+	lim     *limiter
+	closers []io.Closer
+}
+
+func (a *tarArchive) next() (string, io.Reader, error) {
+	for {
+		header, err := a.tr.Next()
+		if err != nil {
+			if err != io.EOF {
+				err = fmt.Errorf("failed to read tar header: %w", err)
+			}
+			return "", nil, err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir, tar.TypeXGlobalHeader:
+			continue
+		case tar.TypeSymlink, tar.TypeLink:
+			return "", nil, &EntryError{Name: header.Name, Reason: "links are not allowed"}
+		case tar.TypeReg:
+		default:
+			return "", nil, &EntryError{Name: header.Name, Reason: "only regular files are allowed"}
+		}
+		name, err := checkEntryName(header.Name)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := a.lim.entry(); err != nil {
+			return "", nil, err
+		}
+		return name, a.lim.wrap(name, a.tr), nil
+	}
+}
+
+func (a *tarArchive) close() error {
+	return closeAll(a.closers)
+}
+
+type zipArchive struct {
+	zr      *zip.ReadCloser
+	lim     *limiter
+	i       int
+	current io.ReadCloser
+}
+
+func (a *zipArchive) next() (string, io.Reader, error) {
+	if a.current != nil {
+		a.current.Close()
+		a.current = nil
+	}
+	for a.i < len(a.zr.File) {
+		f := a.zr.File[a.i]
+		a.i++
+		mode := f.Mode()
+		if mode.IsDir() {
+			continue
+		}
+		if mode&fs.ModeSymlink != 0 {
+			return "", nil, &EntryError{Name: f.Name, Reason: "links are not allowed"}
+		}
+		if !mode.IsRegular() {
+			return "", nil, &EntryError{Name: f.Name, Reason: "only regular files are allowed"}
+		}
+		name, err := checkEntryName(f.Name)
+		if err != nil {
+			return "", nil, err
+		}
+		if err := a.lim.entry(); err != nil {
+			return "", nil, err
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to open %s in zip archive: %w", f.Name, err)
+		}
+		a.current = rc
+		return name, a.lim.wrap(name, rc), nil
+	}
+	return "", nil, io.EOF
+}
+
+func (a *zipArchive) close() error {
+	if a.current != nil {
+		a.current.Close()
+	}
+	return a.zr.Close()
+}
+
+type singleFile struct {
+	name    string
+	r       io.Reader
+	lim     *limiter
+	done    bool
+	closers []io.Closer
+}
+
+func (a *singleFile) next() (string, io.Reader, error) {
+	if a.done {
+		return "", nil, io.EOF
+	}
+	a.done = true
+	if err := a.lim.entry(); err != nil {
+		return "", nil, err
+	}
+	return a.name, a.lim.wrap(a.name, a.r), nil
+}
+
+func (a *singleFile) close() error {
+	return closeAll(a.closers)
+}
+
+// closeAll closes readers innermost first, returning the first error
+func closeAll(closers []io.Closer) error {
+	var first error
+	for i := len(closers) - 1; i >= 0; i-- {
+		if err := closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
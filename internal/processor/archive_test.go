@@ -0,0 +1,188 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testFile is a file written into a test upload, in order
+type testFile struct {
+	name    string
+	content string
+}
+
+func tarBytes(t *testing.T, files []testFile) []byte {
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, f := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: f.name, Mode: 0600, Size: int64(len(f.content))}))
+		_, err := tw.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	return buf.Bytes()
+}
+
+func zipBytes(t *testing.T, files []testFile) []byte {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, f := range files {
+		w, err := zw.Create(f.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(f.content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func gzipBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	gzw := gzip.NewWriter(&buf)
+	_, err := gzw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, data []byte) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	require.NoError(t, err)
+	_, err = zw.Write(data)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+// writeUpload saves upload content the way the upload handler does, without an extension
+func writeUpload(t *testing.T, data []byte) string {
+	uploadPath := filepath.Join(t.TempDir(), "upload")
+	require.NoError(t, os.WriteFile(uploadPath, data, 0600))
+	return uploadPath
+}
+
+func TestDetectFormatAndOpenArchive(t *testing.T) {
+	files := []testFile{{"manifest.json", "{}"}, {"data.csv", "a,b\n1,2\n"}}
+	csvData := []byte("a,b\n1,2\n")
+
+	tests := []struct {
+		format string
+		data   []byte
+		names  []string
+	}{
+		{FormatTarGz, gzipBytes(t, tarBytes(t, files)), []string{"manifest.json", "data.csv"}},
+		{FormatTarZst, zstdBytes(t, tarBytes(t, files)), []string{"manifest.json", "data.csv"}},
+		{FormatZip, zipBytes(t, files), []string{"manifest.json", "data.csv"}},
+		{FormatCSV, csvData, []string{"usage.csv"}},
+		{FormatCSVGz, gzipBytes(t, csvData), []string{"usage.csv"}},
+		{FormatCSVZst, zstdBytes(t, csvData), []string{"usage.csv"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			uploadPath := writeUpload(t, tt.data)
+			format, err := DetectFormat(uploadPath)
+			require.NoError(t, err)
+			assert.Equal(t, tt.format, format)
+
+			archive, err := openArchive(uploadPath, format, "usage.csv", Limits{})
+			require.NoError(t, err)
+			defer archive.close()
+
+			var names []string
+			for {
+				name, content, err := archive.next()
+				if err == io.EOF {
+					break
+				}
+				require.NoError(t, err)
+				data, err := io.ReadAll(content)
+				require.NoError(t, err)
+				if name != "manifest.json" {
+					assert.Equal(t, string(csvData), string(data))
+				}
+				names = append(names, name)
+			}
+			assert.Equal(t, tt.names, names)
+		})
+	}
+}
+
+func TestCSVUploadName(t *testing.T) {
+	assert.Equal(t, "usage.csv", csvUploadName("usage.csv.gz"))
+	assert.Equal(t, "usage.csv", csvUploadName("usage.csv.zst"))
+	assert.Equal(t, "export.csv", csvUploadName("export"))
+	assert.Equal(t, "upload.csv", csvUploadName(""))
+	assert.Equal(t, "usage.csv", csvUploadName("../../usage.csv"))
+}
+
+func TestProcessUploadRequiresClusterForCSV(t *testing.T) {
+	uploadPath := writeUpload(t, []byte(storageCSV))
+
+	// The upload is rejected before the database is used
+	_, err := ProcessUpload(context.Background(), uploadPath, db.NewRepository(nil), Options{}, UploadParams{Filename: "storage.csv"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "cluster_id is required for csv uploads")
+
+	_, err = ProcessUpload(context.Background(), uploadPath, db.NewRepository(nil), Options{}, UploadParams{ClusterID: "not-a-uuid"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid cluster_id")
+}
+
+func TestProcessUploadFormats(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	params := UploadParams{Filename: "claims.csv.gz", ClusterID: clusterID, ClusterName: "backfill"}
+
+	// A gzipped CSV is described by the request parameters
+	result, err := ProcessUpload(ctx, writeUpload(t, gzipBytes(t, []byte(storageCSV))), repo, Options{}, params)
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSVGz, result.Format)
+	assert.Nil(t, result.Manifest)
+	assert.Empty(t, result.ManifestUUID)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, "claims.csv", result.Files[0].Name)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+	assert.Equal(t, ReportTypeStorage, result.Files[0].ReportType)
+
+	var clusterName string
+	require.NoError(t, pool.QueryRow(ctx, "SELECT name FROM clusters WHERE id = $1", clusterID).Scan(&clusterName))
+	assert.Equal(t, "backfill", clusterName)
+
+	// A zip without a manifest processes every CSV it contains
+	result, err = ProcessUpload(ctx, writeUpload(t, zipBytes(t, []testFile{{"export/claims.csv", storageCSV}})), repo, Options{}, params)
+	require.NoError(t, err)
+	assert.Equal(t, FormatZip, result.Format)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, "export/claims.csv", result.Files[0].Name)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+
+	// An operator archive compressed with zstd carries its own manifest
+	manifest := newTestManifest(clusterID, "storage.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+	data := zstdBytes(t, tarBytes(t, []testFile{{"manifest.json", string(manifestJSON)}, {"storage.csv", storageCSV}}))
+	result, err = ProcessUpload(ctx, writeUpload(t, data), repo, Options{}, UploadParams{})
+	require.NoError(t, err)
+	assert.Equal(t, FormatTarZst, result.Format)
+	assert.Equal(t, manifest.UUID, result.ManifestUUID)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+}
@@ -0,0 +1,156 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+)
+
+// batchSize is the number of CSV records buffered before they are written to the database
+const batchSize = 5000
+
+type podRef struct {
+	name      string
+	namespace string
+	uid       string
+}
+
+type batchPod struct {
+	node       int
+	assignment components.Assignment
+	labels     map[string]string
+}
+
+type batchNodeMetric struct {
+	node int
+	row  db.NodeMetricRow
+}
+
+type batchPodMetric struct {
+	pod  int
+	node int
+	row  db.PodMetricRow
+}
+
+// csvBatch buffers parsed records so that nodes, pods and their metrics are
+// written with a handful of bulk statements instead of several per record
+type csvBatch struct {
+	records     int
+	nodeIndex   map[db.NodeKey]int
+	nodes       []db.NodeKey
+	nodeMetrics []batchNodeMetric
+	podIndex    map[podRef]int
+	pods        []podRef
+	podDetails  []batchPod
+	podMetrics  []batchPodMetric
+}
+
+func newCSVBatch() *csvBatch {
+	return &csvBatch{
+		nodeIndex: make(map[db.NodeKey]int),
+		podIndex:  make(map[podRef]int),
+	}
+}
+
+// addNode buffers a record's node and its metric for the record's interval, returning the
+// node's position in the batch
+func (b *csvBatch) addNode(key db.NodeKey, span interval, coreCount int, memoryBytes int64) int {
+	b.records++
+	node, ok := b.nodeIndex[key]
+	if !ok {
+		node = len(b.nodes)
+		b.nodeIndex[key] = node
+		b.nodes = append(b.nodes, key)
+	}
+	b.nodeMetrics = append(b.nodeMetrics, batchNodeMetric{
+		node: node,
+		row: db.NodeMetricRow{
+			Timestamp:       span.Start,
+			CoreCount:       coreCount,
+			MemoryBytes:     memoryBytes,
+			IntervalSeconds: span.seconds(),
+		},
+	})
+	return node
+}
+
+// addPod buffers a pod and its metric. A pod seen on several nodes within a batch
+// keeps the node, component, product and labels of its latest record, as per-record upserts would;
+// the node of each record is kept in the pod's placements.
+func (b *csvBatch) addPod(node int, name, namespace, uid string, assignment components.Assignment, labels map[string]string, metric db.PodMetricRow) {
+	ref := podRef{name: name, namespace: namespace, uid: uid}
+	pod, ok := b.podIndex[ref]
+	if !ok {
+		pod = len(b.pods)
+		b.podIndex[ref] = pod
+		b.pods = append(b.pods, ref)
+		b.podDetails = append(b.podDetails, batchPod{})
+	}
+	b.podDetails[pod] = batchPod{node: node, assignment: assignment, labels: labels}
+	b.podMetrics = append(b.podMetrics, batchPodMetric{pod: pod, node: node, row: metric})
+}
+
+// flush writes the buffered records and resets the batch
+func (b *csvBatch) flush(ctx context.Context, repo *db.Repository, clusterID uuid.UUID) error {
+	if b.records == 0 {
+		return nil
+	}
+
+	nodeIDs, err := repo.UpsertNodes(ctx, b.nodes)
+	if err != nil {
+		return err
+	}
+
+	nodeRows := make([]db.NodeMetricRow, len(b.nodeMetrics))
+	for i, m := range b.nodeMetrics {
+		nodeRows[i] = m.row
+		nodeRows[i].NodeID = nodeIDs[m.node]
+		nodeRows[i].ClusterID = clusterID
+	}
+	if err := repo.CopyNodeMetrics(ctx, nodeRows); err != nil {
+		return err
+	}
+
+	podKeys := make([]db.PodKey, len(b.pods))
+	for i, ref := range b.pods {
+		podKeys[i] = db.PodKey{
+			ClusterID: clusterID,
+			NodeID:    nodeIDs[b.podDetails[i].node],
+			Name:      ref.name,
+			Namespace: ref.namespace,
+			UID:       ref.uid,
+			Component: b.podDetails[i].assignment.Component,
+			Product:   b.podDetails[i].assignment.Product,
+			Labels:    b.podDetails[i].labels,
+		}
+	}
+	podIDs, err := repo.UpsertPods(ctx, podKeys)
+	if err != nil {
+		return err
+	}
+
+	podRows := make([]db.PodMetricRow, len(b.podMetrics))
+	placements := make([]db.PodPlacementRow, len(b.podMetrics))
+	for i, m := range b.podMetrics {
+		podRows[i] = m.row
+		podRows[i].PodID = podIDs[m.pod]
+		placements[i] = db.PodPlacementRow{
+			PodID:     podIDs[m.pod],
+			NodeID:    nodeIDs[m.node],
+			ValidFrom: m.row.Timestamp,
+			ValidTo:   m.row.Timestamp.Add(time.Duration(m.row.IntervalSeconds * float64(time.Second))),
+		}
+	}
+	if err := repo.CopyPodMetrics(ctx, podRows); err != nil {
+		return err
+	}
+	if err := repo.RecordPodPlacements(ctx, placements); err != nil {
+		return err
+	}
+
+	*b = *newCSVBatch()
+	return nil
+}
@@ -3,13 +3,15 @@ package processor
 import (
 	"context"
 	"encoding/csv"
+	"errors"
 	"fmt"
+	"io"
 	"log"
-	"os"
 	"strconv"
 	"strings"
 	"time"
 
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
 	"github.com/google/uuid"
 )
@@ -26,263 +28,260 @@ var RequiredHeaders = []string{
 	"pod_labels",
 }
 
-// ProcessCSV processes a CSV reader, extracting distinct node data and inserting into data tables
-func ProcessCSV(ctx context.Context, repo *db.Repository, reader *csv.Reader, clusterID string) error {
-	// Configure CSV reader
+// ProcessCSV processes a CSV reader, extracting distinct node data and inserting into data tables.
+// Records are streamed one at a time so memory stays flat regardless of file size.
+// Rows excluded by the cluster's ingestion rules are skipped. Every other pod is kept with
+// all of its labels and attributed to a component by the components.Default rules; see
+// ReportSource.ComponentRules. It returns a Report of the rows that were accepted,
+// rejected or skipped and why. Run it through Repository.WithTx so that a file which
+// fails part way leaves no rows behind.
+func ProcessCSV(ctx context.Context, repo *db.Repository, reader *csv.Reader, clusterID string) (*Report, error) {
+	headers, err := readHeader(reader)
+	if err != nil {
+		return newReport(), err
+	}
+	return processPodUsage(ctx, repo, reader, headers, ReportSource{ClusterID: clusterID})
+}
+
+// readHeader configures a report reader and reads its header record
+func readHeader(reader *csv.Reader) ([]string, error) {
+	// Configure CSV reader; field counts are checked per record so a malformed
+	// record is skipped instead of failing the whole file
 	reader.Comma = ','
-	reader.FieldsPerRecord = len(RequiredHeaders) // Expect 20 fields per record
+	reader.FieldsPerRecord = -1
 	reader.TrimLeadingSpace = true
 
-	// Read all records
-	records, err := reader.ReadAll()
+	headers, err := reader.Read()
+	if err == io.EOF {
+		return nil, fmt.Errorf("empty CSV file")
+	}
 	if err != nil {
-		return fmt.Errorf("failed to read CSV records: %w", err)
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
 	}
 
-	// Check if CSV is empty
-	if len(records) < 1 {
-		return fmt.Errorf("empty CSV file")
-	}
+	// Data records share one backing slice; nothing below keeps a reference past the current record
+	reader.ReuseRecord = true
+	return headers, nil
+}
 
-	// Map header indices
-	headers := records[0]
+// headerIndex maps header names to their column, failing if a required header is missing
+func headerIndex(headers, required []string) (map[string]int, error) {
 	headerIndices := make(map[string]int)
 	for i, h := range headers {
 		headerIndices[strings.TrimSpace(h)] = i
 	}
+	for _, r := range required {
+		if _, exists := headerIndices[r]; !exists {
+			return nil, fmt.Errorf("missing required header: %s", r)
+		}
+	}
+	return headerIndices, nil
+}
 
-	log.Printf("Headers: %v", headers)
-	log.Printf("Header indices: %v", headerIndices)
+// hasHeaders reports whether every required header is present
+func hasHeaders(headers, required []string) bool {
+	_, err := headerIndex(headers, required)
+	return err == nil
+}
 
-	// Validate headers
-	for _, required := range RequiredHeaders {
-		if _, exists := headerIndices[required]; !exists {
-			return fmt.Errorf("missing required header: %s", required)
+// eachRecord reads the data records of a report and calls fn with each record and its line.
+// Rows the CSV reader cannot parse and rows with a different number of fields than the
+// header are rejected in report without reaching fn. Reading stops at the first error
+// returned by fn or by the reader.
+func eachRecord(reader *csv.Reader, headers []string, report *Report, fn func(line int, record []string) error) error {
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			var parseErr *csv.ParseError
+			if errors.As(err, &parseErr) {
+				report.reject(parseErr.StartLine, ReasonMalformedRow, err.Error(), nil)
+				continue
+			}
+			return fmt.Errorf("failed to read CSV: %w", err)
+		}
+		line, _ := reader.FieldPos(0)
+		if len(record) != len(headers) {
+			report.reject(line, ReasonFieldCount, fmt.Sprintf("expected %d fields, got %d", len(headers), len(record)), record)
+			continue
+		}
+		if err := fn(line, record); err != nil {
+			return err
 		}
 	}
+}
 
-	// Get pod label keys from environment, default to "label_rht_comp"
-	podLabelKeysStr := os.Getenv("POD_LABEL_KEYS")
-	if podLabelKeysStr == "" {
-		podLabelKeysStr = "label_rht_comp"
+// summaryTable is a daily summary table that a report's rows feed
+type summaryTable struct {
+	name    string
+	refresh func(repo *db.Repository, clusterID uuid.UUID, date time.Time) error
+}
+
+var (
+	nodeSummaries    = summaryTable{"node_daily_summary", (*db.Repository).RefreshNodeDailySummaries}
+	podSummaries     = summaryTable{"pod_daily_summary", (*db.Repository).RefreshPodDailySummaries}
+	storageSummaries = summaryTable{"storage_daily_summary", (*db.Repository).RefreshStorageDailySummaries}
+)
+
+// refreshTouchedDays rebuilds the given summary tables of a cluster for every day a file
+// touched, in table order for each day
+func refreshTouchedDays(repo *db.Repository, clusterID uuid.UUID, dates map[time.Time]struct{}, tables ...summaryTable) error {
+	for date := range dates {
+		for _, table := range tables {
+			if err := table.refresh(repo, clusterID, date); err != nil {
+				return fmt.Errorf("failed to refresh %s for %s: %w", table.name, date.Format("2006-01-02"), err)
+			}
+		}
 	}
-	podLabelKeys := strings.Split(podLabelKeysStr, ",")
-	podLabelKeySet := make(map[string]struct{})
-	for _, key := range podLabelKeys {
-		podLabelKeySet[strings.TrimSpace(key)] = struct{}{}
+	return nil
+}
+
+// processPodUsage ingests the records of a pod usage report whose header has already been read
+func processPodUsage(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error) {
+	report := newReport()
+
+	headerIndices, err := headerIndex(headers, RequiredHeaders)
+	if err != nil {
+		return report, err
 	}
 
-	// Track node metrics (nodeID -> date -> hour -> max core_count)
-	type hourMetric struct {
-		maxCoreCount int
-		timestamp    time.Time
+	clusterUUID, err := uuid.Parse(src.ClusterID)
+	if err != nil {
+		return report, fmt.Errorf("invalid cluster_id %s: %w", src.ClusterID, err)
 	}
-	nodeMetrics := make(map[uuid.UUID]map[string]map[time.Time]hourMetric)
-	// Track pod metrics (podID -> timestamp -> {usage, request, nodeCapacity})
-	type podMetric struct {
-		usage     float64
-		request   float64
-		nodeCap   float64
-		coreCount int
+
+	loc, err := repo.ReportingLocation(clusterUUID)
+	if err != nil {
+		return report, err
 	}
-	podMetrics := make(map[uuid.UUID]map[time.Time]podMetric)
 
-	// Process each record
-	for i, record := range records[1:] {
-		if len(record) != len(headers) {
-			log.Printf("Skipping record %d: expected %d fields, got %d: %v", i+1, len(headers), len(record), record)
-			continue
-		}
+	// Rows of excluded clusters, namespaces and node roles are skipped
+	filter, err := loadRowFilter(repo, clusterUUID)
+	if err != nil {
+		return report, err
+	}
 
-		log.Printf("Processing record %d: %v", i+1, record)
+	rules := src.ComponentRules
+	if rules == nil {
+		rules = components.Default()
+	}
 
-		intervalStartStr := record[headerIndices["interval_start"]]
-		nodeName := record[headerIndices["node"]]
-		resourceID := record[headerIndices["resource_id"]]
-		nodeRole := record[headerIndices["node_role"]]
-		capacityCPUStr := record[headerIndices["node_capacity_cpu_cores"]]
-		podName := record[headerIndices["pod"]]
-		namespace := record[headerIndices["namespace"]]
-		podLabels := record[headerIndices["pod_labels"]]
-		podUsageStr := record[headerIndices["pod_usage_cpu_core_seconds"]]
-		podRequestStr := record[headerIndices["pod_request_cpu_core_seconds"]]
-		nodeCapacityCPUCoreSecondsStr := record[headerIndices["node_capacity_cpu_core_seconds"]]
+	// Track the days touched by this file in the cluster's reporting timezone; their
+	// summaries are rebuilt from the raw metrics
+	touchedDates := make(map[time.Time]struct{})
 
-		intervalStart, err := time.Parse("2006-01-02 15:04:05 +0000 MST", intervalStartStr)
-		if err != nil {
-			log.Printf("Skipping record %d: invalid interval_start %s: %v", i+1, intervalStartStr, err)
-			continue
-		}
+	// Records are buffered and written in bulk every batchSize records
+	batch := newCSVBatch()
 
-		capacityCPU, err := strconv.ParseFloat(capacityCPUStr, 64)
-		if err != nil {
-			log.Printf("Skipping record %d: invalid node_capacity_cpu_cores %s: %v", i+1, capacityCPUStr, err)
-			continue
+	err = eachRecord(reader, headers, report, func(line int, record []string) error {
+		span, rowErr := src.parseInterval(record, headerIndices)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
 		}
-
-		clusterUUID, err := uuid.Parse(clusterID)
-		if err != nil {
-			log.Printf("Skipping record %d: invalid cluster_id %s: %v", i+1, clusterID, err)
-			continue
+		sample, rowErr := parseNodeSample(record, headerIndices, clusterUUID)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
 		}
-
-		podUsage, err := strconv.ParseFloat(podUsageStr, 64)
-		if err != nil {
-			log.Printf("Skipping record %d: invalid pod_usage_cpu_core_seconds %s: %v", i+1, podUsageStr, err)
-			continue
+		if reason, message := filter.exclusion("", sample.key.Type); reason != "" {
+			report.skip(line, reason, message, record)
+			return nil
 		}
 
-		podRequest, err := strconv.ParseFloat(podRequestStr, 64)
-		if err != nil {
-			log.Printf("Record %d: invalid pod_request_cpu_core_seconds %s: %v - setting to 0.0", i+1, podRequestStr, err)
-			podRequest = 0.0
-		}
+		// Buffer the node and its metric for the next bulk write. The node's capacity is
+		// counted whatever happens to the pod data of the row.
+		node := batch.addNode(sample.key, span, sample.coreCount, sample.memoryBytes)
+		touchedDates[db.ReportingDate(span.Start, loc)] = struct{}{}
 
-		nodeCapacityCPUCoreSeconds, err := strconv.ParseFloat(nodeCapacityCPUCoreSecondsStr, 64)
-		if err != nil {
-			log.Printf("Skipping record %d: invalid node_capacity_cpu_core_seconds %s: %v", i+1, nodeCapacityCPUCoreSecondsStr, err)
-			continue
+		podName := record[headerIndices["pod"]]
+		namespace := record[headerIndices["namespace"]]
+		if podName == "" {
+			// A node with no pods scheduled in the interval
+			report.Accepted++
+		} else if reason, message := filter.exclusion(namespace, ""); reason != "" {
+			report.skip(line, reason, message, record)
+		} else if metric, rowErr := parsePodMetric(record, headerIndices, line); rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+		} else {
+			// Every pod is stored with all of its labels; they are filtered when querying
+			labels := parseLabels(record[headerIndices["pod_labels"]])
+			metric.Timestamp = span.Start
+			metric.NodeCapacityCPUCores = sample.coreCount
+			metric.NodeCapacityMemoryBytes = sample.memoryBytes
+			metric.IntervalSeconds = span.seconds()
+			// pod_uid is optional; without it pods recreated under the same name share a row
+			uid := optionalField(record, headerIndices, "pod_uid")
+			batch.addPod(node, podName, namespace, uid, rules.Assign(namespace, labels), labels, metric)
+			report.Accepted++
 		}
 
-		// Prepare identifier (NULL if resource_id is empty)
-		var identifier string
-		if resourceID != "" {
-			identifier = resourceID
+		if batch.records >= batchSize {
+			return batch.flush(ctx, repo, clusterUUID)
 		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
 
-		// Insert into nodes table using Repository
-		nodeType := nodeRole
-		if nodeType == "" {
-			nodeType = "worker" // Default
-		}
-		nodeID, err := repo.UpsertNode(clusterUUID, nodeName, identifier, nodeType)
-		if err != nil {
-			log.Printf("Skipping record %d: failed to insert/update node %s: %v", i+1, nodeName, err)
-			continue
-		}
+	if err := batch.flush(ctx, repo, clusterUUID); err != nil {
+		return report, err
+	}
 
-		// Insert into node_metrics table using Repository
-		err = repo.InsertNodeMetric(nodeID, intervalStart, int(capacityCPU), clusterUUID)
-		if err != nil {
-			log.Printf("Skipping record %d: failed to insert node_metrics for node %s at %s: %v", i+1, nodeName, intervalStart, err)
-			continue
-		}
+	// Rebuild daily summaries for every day touched by this file
+	if err := refreshTouchedDays(repo, clusterUUID, touchedDates, nodeSummaries, podSummaries); err != nil {
+		return report, err
+	}
 
-		// Track node metrics for daily summary
-		dateStr := intervalStart.Truncate(24 * time.Hour).Format("2006-01-02")
-		hour := intervalStart.Truncate(time.Hour)
-		if _, ok := nodeMetrics[nodeID]; !ok {
-			nodeMetrics[nodeID] = make(map[string]map[time.Time]hourMetric)
-		}
-		if _, ok := nodeMetrics[nodeID][dateStr]; !ok {
-			nodeMetrics[nodeID][dateStr] = make(map[time.Time]hourMetric)
-		}
-		if metric, ok := nodeMetrics[nodeID][dateStr][hour]; ok {
-			if int(capacityCPU) > metric.maxCoreCount {
-				nodeMetrics[nodeID][dateStr][hour] = hourMetric{
-					maxCoreCount: int(capacityCPU),
-					timestamp:    intervalStart,
-				}
-			}
-		} else {
-			nodeMetrics[nodeID][dateStr][hour] = hourMetric{
-				maxCoreCount: int(capacityCPU),
-				timestamp:    intervalStart,
-			}
-		}
+	return report, nil
+}
 
-		// Process pod if it has a matching label key
-		labels := strings.Split(podLabels, "|")
-		labelMap := make(map[string]string)
-		for _, label := range labels {
-			parts := strings.SplitN(label, ":", 2)
-			if len(parts) == 2 {
-				labelMap[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
-			}
-		}
+// parsePodMetric reads the pod columns of a pod usage row. Requests and limits that do not
+// parse are logged and taken as zero.
+func parsePodMetric(record []string, headerIndices map[string]int, line int) (db.PodMetricRow, *rowError) {
+	var m db.PodMetricRow
+	var err error
 
-		var component string
-		hasMatchingLabel := false
-		for key := range labelMap {
-			if _, exists := podLabelKeySet[key]; exists {
-				hasMatchingLabel = true
-				if key == "label_rht_comp" {
-					component = labelMap[key]
-				}
-			}
-		}
+	podUsageStr := record[headerIndices["pod_usage_cpu_core_seconds"]]
+	if m.PodUsage, err = strconv.ParseFloat(podUsageStr, 64); err != nil {
+		return m, &rowError{Reason: ReasonInvalidPodUsage, Message: fmt.Sprintf("invalid pod_usage_cpu_core_seconds %q", podUsageStr)}
+	}
 
-		if !hasMatchingLabel {
-			log.Printf("Skipping pod %s in namespace %s: no matching label key in %v", podName, namespace, podLabelKeys)
-			continue
-		}
+	podRequestStr := record[headerIndices["pod_request_cpu_core_seconds"]]
+	if m.PodRequest, err = strconv.ParseFloat(podRequestStr, 64); err != nil {
+		log.Printf("Line %d: invalid pod_request_cpu_core_seconds %s: %v - setting to 0.0", line, podRequestStr, err)
+		m.PodRequest = 0.0
+	}
 
-		// Insert into pods table
-		podID, err := repo.UpsertPod(clusterUUID, nodeID, podName, namespace, component)
-		if err != nil {
-			log.Printf("Skipping record %d: failed to insert/update pod %s in namespace %s: %v", i+1, podName, namespace, err)
-			continue
-		}
+	nodeCapacityCPUCoreSecondsStr := record[headerIndices["node_capacity_cpu_core_seconds"]]
+	if m.NodeCapacityCPUCoreSeconds, err = strconv.ParseFloat(nodeCapacityCPUCoreSecondsStr, 64); err != nil {
+		return m, &rowError{Reason: ReasonInvalidNodeCapacityCPUCoreSeconds, Message: fmt.Sprintf("invalid node_capacity_cpu_core_seconds %q", nodeCapacityCPUCoreSecondsStr)}
+	}
 
-		// Insert into pod_metrics table
-		err = repo.InsertPodMetric(podID, intervalStart, podUsage, podRequest, nodeCapacityCPUCoreSeconds, int(capacityCPU))
-		if err != nil {
-			log.Printf("Skipping record %d: failed to insert pod_metrics for pod %s at %s: %v", i+1, podName, intervalStart, err)
-			continue
-		}
+	podUsageMemoryStr := record[headerIndices["pod_usage_memory_byte_seconds"]]
+	if m.PodUsageMemory, err = strconv.ParseFloat(podUsageMemoryStr, 64); err != nil {
+		return m, &rowError{Reason: ReasonInvalidPodMemoryUsage, Message: fmt.Sprintf("invalid pod_usage_memory_byte_seconds %q", podUsageMemoryStr)}
+	}
 
-		// Track pod metrics for daily summary
-		if _, ok := podMetrics[podID]; !ok {
-			podMetrics[podID] = make(map[time.Time]podMetric)
-		}
-		if metric, ok := podMetrics[podID][intervalStart]; ok {
-			// Aggregate usage and request, keep latest node capacity
-			metric.usage += podUsage
-			metric.request += podRequest
-			metric.nodeCap = nodeCapacityCPUCoreSeconds
-			metric.coreCount = int(capacityCPU)
-			podMetrics[podID][intervalStart] = metric
-		} else {
-			podMetrics[podID][intervalStart] = podMetric{
-				usage:     podUsage,
-				request:   podRequest,
-				nodeCap:   nodeCapacityCPUCoreSeconds,
-				coreCount: int(capacityCPU),
-			}
-		}
+	podRequestMemoryStr := record[headerIndices["pod_request_memory_byte_seconds"]]
+	if m.PodRequestMemory, err = strconv.ParseFloat(podRequestMemoryStr, 64); err != nil {
+		log.Printf("Line %d: invalid pod_request_memory_byte_seconds %s: %v - setting to 0.0", line, podRequestMemoryStr, err)
+		m.PodRequestMemory = 0.0
 	}
 
-	// Update node daily summaries
-	for nodeID, dates := range nodeMetrics {
-		for _, hours := range dates {
-			for _, metric := range hours {
-				err := repo.UpdateNodeDailySummary(nodeID, metric.timestamp, metric.maxCoreCount)
-				if err != nil {
-					log.Printf("Failed to update node_daily_summary for node %s at %s with core_count %d: %v", nodeID, metric.timestamp, metric.maxCoreCount, err)
-					continue
-				}
-			}
-		}
+	podLimitMemoryStr := record[headerIndices["pod_limit_memory_byte_seconds"]]
+	if m.PodLimitMemory, err = strconv.ParseFloat(podLimitMemoryStr, 64); err != nil {
+		log.Printf("Line %d: invalid pod_limit_memory_byte_seconds %s: %v - setting to 0.0", line, podLimitMemoryStr, err)
+		m.PodLimitMemory = 0.0
 	}
 
-	// Update pod daily summaries after processing all records
-	for podID, timestamps := range podMetrics {
-		for ts, metric := range timestamps {
-			podEffectiveCoreSeconds := metric.usage
-			if metric.request > metric.usage {
-				podEffectiveCoreSeconds = metric.request
-			}
-			podEffectiveCoreUsage := 0.0
-			if metric.nodeCap > 0 {
-				podEffectiveCoreUsage = podEffectiveCoreSeconds / metric.nodeCap
-			}
-			err := repo.UpdatePodDailySummary(podID, ts, podEffectiveCoreSeconds, podEffectiveCoreUsage)
-			if err != nil {
-				log.Printf("Failed to update pod_daily_summary for pod %s at %s: %v", podID, ts, err)
-				continue
-			}
-		}
+	nodeCapacityMemorySecondsStr := record[headerIndices["node_capacity_memory_byte_seconds"]]
+	if m.NodeCapacityMemoryByteSeconds, err = strconv.ParseFloat(nodeCapacityMemorySecondsStr, 64); err != nil {
+		return m, &rowError{Reason: ReasonInvalidNodeCapacityMemorySeconds, Message: fmt.Sprintf("invalid node_capacity_memory_byte_seconds %q", nodeCapacityMemorySecondsStr)}
 	}
 
-	return nil
+	return m, nil
 }
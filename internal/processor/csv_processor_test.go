@@ -1,17 +1,25 @@
 package processor
 
 import (
+	"bufio"
 	"context"
 	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
 	"os"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
 
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
 	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
-	// "github.com/google/uuid"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
-	// "github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/require"
 )
 
 // func TestProcessCSV(t *testing.T) {
@@ -78,8 +86,14 @@ func TestProcessCSVInvalidTimestamp(t *testing.T) {
 	reader.Comma = ','
 	reader.TrimLeadingSpace = true
 
-	err := ProcessCSV(ctx, repo, reader, clusterID)
+	report, err := ProcessCSV(ctx, repo, reader, clusterID)
 	assert.NoError(t, err)
+	assert.Equal(t, 0, report.Accepted)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Equal(t, map[string]int{ReasonInvalidIntervalStart: 1}, report.Reasons)
+	require.Len(t, report.Samples, 1)
+	assert.Equal(t, 2, report.Samples[0].Line)
+	assert.Contains(t, report.Samples[0].Row, "invalid-timestamp")
 
 	var count int
 	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM pod_metrics").Scan(&count)
@@ -87,7 +101,7 @@ func TestProcessCSVInvalidTimestamp(t *testing.T) {
 	assert.Equal(t, 0, count, "No metrics should be inserted for invalid timestamp")
 }
 
-func TestProcessCSVMissingLabel(t *testing.T) {
+func TestProcessCSVKeepsPodsWithoutMatchingLabel(t *testing.T) {
 	pool := testutils.SetupTestDB(t)
 	repo := db.NewRepository(pool)
 	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
@@ -103,11 +117,338 @@ func TestProcessCSVMissingLabel(t *testing.T) {
 	reader.Comma = ','
 	reader.TrimLeadingSpace = true
 
-	err := ProcessCSV(ctx, repo, reader, clusterID)
+	report, err := ProcessCSV(ctx, repo, reader, clusterID)
 	assert.NoError(t, err)
+	assert.Equal(t, 1, report.Accepted)
+	assert.Equal(t, 0, report.Skipped)
 
 	var count int
 	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM pod_metrics").Scan(&count)
 	assert.NoError(t, err)
-	assert.Equal(t, 0, count, "No pod metrics should be inserted without matching label")
+	assert.Equal(t, 1, count, "Pod metrics should be stored without a matching label")
+
+	var labels map[string]string
+	var component string
+	err = pool.QueryRow(context.Background(), "SELECT labels, COALESCE(component, '') FROM pods WHERE name = 'zip-1'").Scan(&labels, &component)
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"app": "web"}, labels)
+	assert.Equal(t, "", component)
+}
+
+func TestProcessPodUsageComponentRules(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,streams,kafka-0,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,label_app_kubernetes_io_part_of:kafka-cluster
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web`
+
+	reader := csv.NewReader(strings.NewReader(csvData))
+	headers, err := readHeader(reader)
+	require.NoError(t, err)
+	src := ReportSource{
+		ClusterID: "10f5a0f9-223a-41c1-8456-9a3eb0323a99",
+		ComponentRules: &components.RuleSet{
+			Rules:    []components.Rule{{Label: "label_app_kubernetes_io_part_of", Value: "kafka*", Component: "kafka", Product: "AMQ Streams"}},
+			Fallback: components.Assignment{Component: "unknown"},
+		},
+	}
+	report, err := processPodUsage(ctx, repo, reader, headers, src)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Accepted)
+
+	for pod, want := range map[string]components.Assignment{
+		"kafka-0": {Component: "kafka", Product: "AMQ Streams"},
+		"zip-1":   {Component: "unknown"},
+	} {
+		var got components.Assignment
+		err = pool.QueryRow(ctx, "SELECT COALESCE(component, ''), COALESCE(product, '') FROM pods WHERE name = $1", pod).Scan(&got.Component, &got.Product)
+		require.NoError(t, err)
+		assert.Equal(t, want, got, pod)
+	}
+}
+
+func TestProcessCSVIngestionRules(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	cluster := uuid.MustParse(clusterID)
+	for _, rule := range []ingestrules.Rule{
+		{Action: ingestrules.ActionExclude, Field: ingestrules.FieldNamespace, Pattern: "openshift-*"},
+		{ClusterID: &cluster, Action: ingestrules.ActionExclude, Field: ingestrules.FieldNodeRole, Pattern: "^infra$", PatternType: ingestrules.PatternRegex},
+	} {
+		require.NoError(t, repo.CreateIngestionRule(&rule))
+	}
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,openshift-monitoring,prometheus-0,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:prometheus
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-64.ec2.internal,test,router-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,infra,i-09ad6102842b9a787,app:router`
+
+	report, err := ProcessCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Accepted)
+	assert.Equal(t, 2, report.Skipped)
+	assert.Equal(t, 1, report.Reasons[ReasonExcludedNamespace])
+	assert.Equal(t, 1, report.Reasons[ReasonExcludedNodeRole])
+
+	var pods int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM pods").Scan(&pods))
+	assert.Equal(t, 1, pods)
+
+	// A rule on the cluster name skips every row of the cluster
+	require.NoError(t, repo.CreateIngestionRule(&ingestrules.Rule{Action: ingestrules.ActionExclude, Field: ingestrules.FieldClusterName, Pattern: "test-*"}))
+	report, err = ProcessCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, report.Accepted)
+	assert.Equal(t, 3, report.Reasons[ReasonExcludedCluster])
+}
+
+func TestProcessCSVCountsNodesOfRejectedPods(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	// The node's only pod row has invalid usage and the next hour has no pods scheduled
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,invalid,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,,,,,,,,,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,`
+
+	report, err := ProcessCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, report.Accepted)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Equal(t, map[string]int{ReasonInvalidPodUsage: 1}, report.Reasons)
+
+	var pods, nodeMetrics int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM pods").Scan(&pods))
+	assert.Equal(t, 0, pods)
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM node_metrics").Scan(&nodeMetrics))
+	assert.Equal(t, 2, nodeMetrics)
+	var totalHours float64
+	require.NoError(t, pool.QueryRow(ctx, "SELECT total_hours FROM node_daily_summary WHERE date = '2025-05-17'").Scan(&totalHours))
+	assert.Equal(t, 2.0, totalHours)
+}
+
+func TestProcessCSVRecordsPodPlacements(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	// zip-1 runs two hours on one node, then is rescheduled onto another
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,2025-05-17 17:00:00 +0000 UTC,ip-10-0-1-64.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a787,app:web`
+
+	_, err := ProcessCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+
+	var podID uuid.UUID
+	require.NoError(t, pool.QueryRow(ctx, "SELECT id FROM pods WHERE name = 'zip-1'").Scan(&podID))
+	history, err := repo.PodHistory(podID)
+	require.NoError(t, err)
+	require.Len(t, history.Placements, 2)
+	assert.Equal(t, "ip-10-0-1-63.ec2.internal", history.Placements[0].NodeName)
+	assert.Equal(t, 2.0, history.Placements[0].TotalHours)
+	assert.Equal(t, "ip-10-0-1-64.ec2.internal", history.Placements[1].NodeName)
+	assert.Equal(t, 1.0, history.Placements[1].TotalHours)
+	assert.True(t, time.Date(2025, 5, 17, 14, 0, 0, 0, time.UTC).Equal(*history.FirstSeen))
+	assert.True(t, time.Date(2025, 5, 17, 17, 0, 0, 0, time.UTC).Equal(*history.LastSeen))
+}
+
+func TestProcessCSVPodIncarnations(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	// db-0 is deleted and recreated under the same name within the hour
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_uid,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,db-0,0b6a2c1e-0f1d-4a51-9a63-5f0f4d1b1a01,100,0,0,1000,0,0,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:db
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,db-0,7d3e9f42-6c1b-4b7e-8d2a-2e9c0a4f5b02,300,0,0,3000,0,0,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:db`
+
+	report, err := ProcessCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Accepted)
+
+	day := time.Date(2025, 5, 17, 0, 0, 0, 0, time.UTC)
+	pods, total, err := repo.QueryPodMetrics(day, day, "", "", "", "db-0", "", nil, nil, 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 2, total, "each incarnation keeps its own usage")
+	require.Len(t, pods, 2)
+	assert.NotEqual(t, pods[0].PodUID, pods[1].PodUID)
+
+	rolledUp, total, err := repo.QueryPodMetricsByName(day, day, "", "", "", "db-0", "", nil, nil, 100, 0)
+	require.NoError(t, err)
+	require.Equal(t, 1, total)
+	assert.Equal(t, 2, rolledUp[0].Incarnations)
+	assert.InDelta(t, 400.0, rolledUp[0].TotalPodEffectiveCoreSeconds, 0.000001)
+}
+
+func TestProcessNodeCSV(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,node_capacity_cpu_cores,node_capacity_memory_bytes,node_role,resource_id
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,4,17179869184,worker,i-09ad6102842b9a786
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,4,17179869184,worker,i-09ad6102842b9a786
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-64.ec2.internal,invalid,17179869184,infra,i-09ad6102842b9a787
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,,4,17179869184,worker,`
+
+	report, err := ProcessNodeCSV(ctx, repo, csv.NewReader(strings.NewReader(csvData)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 2, report.Accepted)
+	assert.Equal(t, 2, report.Rejected)
+	assert.Equal(t, map[string]int{ReasonInvalidNodeCapacityCPUCores: 1, ReasonMissingNode: 1}, report.Reasons)
+
+	var totalHours float64
+	var coreCount int
+	require.NoError(t, pool.QueryRow(ctx,
+		"SELECT s.total_hours, s.core_count FROM node_daily_summary s JOIN nodes n ON n.id = s.node_id WHERE n.name = 'ip-10-0-1-63.ec2.internal'").Scan(&totalHours, &coreCount))
+	assert.Equal(t, 2.0, totalHours)
+	assert.Equal(t, 4, coreCount)
+}
+
+func TestProcessCSVReprocessIsIdempotent(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	os.Setenv("POD_LABEL_KEYS", "label_rht_comp")
+	defer os.Unsetenv("POD_LABEL_KEYS")
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web|label_rht_comp:EAP`
+
+	// Operators resend data after network failures; processing it twice must not double count
+	for i := 0; i < 2; i++ {
+		reader := csv.NewReader(strings.NewReader(csvData))
+		_, err := ProcessCSV(ctx, repo, reader, clusterID)
+		require.NoError(t, err)
+	}
+
+	var totalHours float64
+	err := pool.QueryRow(ctx, "SELECT total_hours FROM node_daily_summary WHERE date = '2025-05-17'").Scan(&totalHours)
+	require.NoError(t, err)
+	assert.Equal(t, 1.0, totalHours)
+
+	var effectiveCoreSeconds, effectiveMemoryByteSeconds float64
+	err = pool.QueryRow(ctx, "SELECT total_pod_effective_core_seconds, total_pod_effective_memory_byte_seconds FROM pod_daily_summary WHERE date = '2025-05-17'").Scan(&effectiveCoreSeconds, &effectiveMemoryByteSeconds)
+	require.NoError(t, err)
+	assert.InDelta(t, 200.0, effectiveCoreSeconds, 0.000001)
+	assert.InDelta(t, 2000.0, effectiveMemoryByteSeconds, 0.000001)
+}
+
+func TestProcessCSVRollsBackOnFailure(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	// The read fails after the first batch has already been written
+	input, _ := syntheticCSV(batchSize + 100)
+	failing := io.MultiReader(input, iotest.ErrReader(errors.New("connection reset")))
+
+	err := repo.WithTx(ctx, func(tx *db.Repository) error {
+		_, err := ProcessCSV(ctx, tx, csv.NewReader(failing), clusterID)
+		return err
+	})
+	require.Error(t, err)
+
+	var nodeMetrics, podMetrics int
+	err = pool.QueryRow(ctx, "SELECT (SELECT COUNT(*) FROM node_metrics), (SELECT COUNT(*) FROM pod_metrics)").Scan(&nodeMetrics, &podMetrics)
+	require.NoError(t, err)
+	assert.Equal(t, 0, nodeMetrics)
+	assert.Equal(t, 0, podMetrics)
+}
+
+// syntheticCSV streams a pod usage CSV with the given number of data rows without buffering it
+func syntheticCSV(rows int) (io.Reader, int64) {
+	header := strings.Join(RequiredHeaders, ",") + "\n"
+	start := time.Date(2025, 5, 1, 0, 0, 0, 0, time.UTC)
+	row := func(i int) string {
+		// 400 pods an hour keeps 200,000 rows within the May partition of the test database
+		interval := start.Add(time.Duration(i/400) * time.Hour)
+		return fmt.Sprintf("2025-05-01 00:00:00 +0000 UTC,2025-06-30 23:59:59 +0000 UTC,%s,%s,node-%d,test,pod-%d,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-%d,app:web|label_rht_comp:EAP\n",
+			interval.Format("2006-01-02 15:04:05 +0000 MST"), interval.Add(time.Hour).Format("2006-01-02 15:04:05 +0000 MST"), i%10, i%400, i%10)
+	}
+
+	size := int64(len(header))
+	for i := 0; i < rows; i++ {
+		size += int64(len(row(i)))
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		w := bufio.NewWriter(pw)
+		w.WriteString(header)
+		for i := 0; i < rows; i++ {
+			w.WriteString(row(i))
+		}
+		w.Flush()
+		pw.Close()
+	}()
+	return pr, size
+}
+
+// checkpointReader passes reads through to r and calls check once more than at bytes have
+// been read, failing the read when check does
+type checkpointReader struct {
+	r     io.Reader
+	read  int64
+	at    int64
+	check func() error
+}
+
+func (c *checkpointReader) Read(p []byte) (int, error) {
+	if c.check != nil && c.read > c.at {
+		check := c.check
+		c.check = nil
+		if err := check(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestProcessCSVStreamsLargeInput(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large synthetic upload in short mode")
+	}
+
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	// Halfway through 6 batches of input, at least 2 batches must already be in the
+	// database; a processor reading the whole file first would have written nothing
+	input, inputSize := syntheticCSV(6 * batchSize)
+	var written int
+	reader := &checkpointReader{r: input, at: inputSize / 2, check: func() error {
+		if err := pool.QueryRow(ctx, "SELECT COUNT(*) FROM pod_metrics").Scan(&written); err != nil {
+			return err
+		}
+		if written < 2*batchSize {
+			return fmt.Errorf("only %d pod metrics written after reading half of the input", written)
+		}
+		return nil
+	}}
+
+	report, err := ProcessCSV(ctx, repo, csv.NewReader(reader), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 6*batchSize, report.Accepted)
+	assert.Nil(t, reader.check, "the input was not read past its midpoint")
+	assert.GreaterOrEqual(t, written, 2*batchSize)
 }
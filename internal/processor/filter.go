@@ -0,0 +1,47 @@
+package processor
+
+import (
+	"fmt"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
+	"github.com/google/uuid"
+)
+
+// rowFilter applies a cluster's ingestion rules to the rows of its reports
+type rowFilter struct {
+	filter      *ingestrules.Filter
+	clusterName string
+}
+
+// loadRowFilter reads the global and cluster specific ingestion rules of a cluster
+func loadRowFilter(repo *db.Repository, clusterID uuid.UUID) (*rowFilter, error) {
+	rules, err := repo.IngestionRules(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	filter, err := ingestrules.Compile(rules)
+	if err != nil {
+		return nil, err
+	}
+	name, err := repo.ClusterName(clusterID)
+	if err != nil {
+		return nil, err
+	}
+	return &rowFilter{filter: filter, clusterName: name}, nil
+}
+
+// exclusion returns the reason and message a row is skipped for, or an empty reason when
+// it is ingested. An empty namespace or node role is not evaluated, so node rows are
+// checked against node role rules only and pod rows against namespace rules only.
+func (f *rowFilter) exclusion(namespace, nodeRole string) (string, string) {
+	switch {
+	case f.filter.Excludes(ingestrules.FieldClusterName, f.clusterName):
+		return ReasonExcludedCluster, fmt.Sprintf("cluster %q is excluded by an ingestion rule", f.clusterName)
+	case namespace != "" && f.filter.Excludes(ingestrules.FieldNamespace, namespace):
+		return ReasonExcludedNamespace, fmt.Sprintf("namespace %q is excluded by an ingestion rule", namespace)
+	case nodeRole != "" && f.filter.Excludes(ingestrules.FieldNodeRole, nodeRole):
+		return ReasonExcludedNodeRole, fmt.Sprintf("node role %q is excluded by an ingestion rule", nodeRole)
+	}
+	return "", ""
+}
@@ -0,0 +1,129 @@
+package processor
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strings"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+)
+
+// NodeLabelHeaders is the subset of node label report headers that must be present
+var NodeLabelHeaders = []string{
+	"report_period_start", "report_period_end", "interval_start", "interval_end",
+	"node", "node_labels",
+}
+
+// NamespaceLabelHeaders is the subset of namespace label report headers that must be present
+var NamespaceLabelHeaders = []string{
+	"report_period_start", "report_period_end", "interval_start", "interval_end",
+	"namespace", "namespace_labels",
+}
+
+// parseLabels splits an operator label string such as "label_app:web|label_team:a" into a map
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, label := range strings.Split(s, "|") {
+		parts := strings.SplitN(label, ":", 2)
+		if len(parts) == 2 {
+			labels[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+	return labels
+}
+
+// processNodeLabels stores the most recent labels reported for each node. Node label
+// reports usually have no node_role, so node role rules apply only when one is present.
+func processNodeLabels(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error) {
+	return processLabels(repo, reader, headers, src, NodeLabelHeaders, "node", "node_labels", ReasonMissingNode,
+		func(filter *rowFilter, name string, record []string, headerIndices map[string]int) (string, string) {
+			return filter.exclusion("", optionalField(record, headerIndices, "node_role"))
+		},
+		func(clusterUUID uuid.UUID, sets []db.LabelSet) error {
+			return repo.UpsertNodeLabels(ctx, clusterUUID, sets)
+		})
+}
+
+// processNamespaceLabels stores the most recent labels reported for each namespace
+func processNamespaceLabels(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error) {
+	return processLabels(repo, reader, headers, src, NamespaceLabelHeaders, "namespace", "namespace_labels", ReasonMissingNamespace,
+		func(filter *rowFilter, name string, record []string, headerIndices map[string]int) (string, string) {
+			return filter.exclusion(name, "")
+		},
+		func(clusterUUID uuid.UUID, sets []db.LabelSet) error {
+			return repo.UpsertNamespaceLabels(ctx, clusterUUID, sets)
+		})
+}
+
+// processLabels reads a label report, keeping the labels from the latest interval of each
+// name column value, and stores them once the whole file has been read. Label reports hold
+// one row per node or namespace per interval, so the retained sets stay small. Rows that
+// exclusion finds excluded by the cluster's ingestion rules are skipped.
+func processLabels(repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource,
+	required []string, nameColumn, labelsColumn, missingReason string,
+	exclusion func(filter *rowFilter, name string, record []string, headerIndices map[string]int) (string, string),
+	store func(clusterUUID uuid.UUID, sets []db.LabelSet) error) (*Report, error) {
+	report := newReport()
+
+	headerIndices, err := headerIndex(headers, required)
+	if err != nil {
+		return report, err
+	}
+
+	clusterUUID, err := uuid.Parse(src.ClusterID)
+	if err != nil {
+		return report, fmt.Errorf("invalid cluster_id %s: %w", src.ClusterID, err)
+	}
+
+	filter, err := loadRowFilter(repo, clusterUUID)
+	if err != nil {
+		return report, err
+	}
+
+	latest := make(map[string]db.LabelSet)
+
+	err = eachRecord(reader, headers, report, func(line int, record []string) error {
+		name := record[headerIndices[nameColumn]]
+		if name == "" {
+			report.reject(line, missingReason, "empty "+nameColumn, record)
+			return nil
+		}
+
+		span, rowErr := src.parseInterval(record, headerIndices)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
+		}
+		intervalStart := span.Start
+
+		if reason, message := exclusion(filter, name, record, headerIndices); reason != "" {
+			report.skip(line, reason, message, record)
+			return nil
+		}
+
+		report.Accepted++
+		if current, ok := latest[name]; ok && current.LastSeen.After(intervalStart) {
+			return nil
+		}
+		latest[name] = db.LabelSet{
+			Name:     name,
+			LastSeen: intervalStart,
+			Labels:   parseLabels(record[headerIndices[labelsColumn]]),
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	sets := make([]db.LabelSet, 0, len(latest))
+	for _, s := range latest {
+		sets = append(sets, s)
+	}
+	if err := store(clusterUUID, sets); err != nil {
+		return report, err
+	}
+	return report, nil
+}
@@ -0,0 +1,114 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/ingestrules"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProcessTarLabelAndVMReports(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	nodeLabelsCSV := `report_period_start,report_period_end,interval_start,interval_end,node,node_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,node-1,label_zone:b
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,node-1,label_zone:a
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,,label_zone:a`
+	namespaceLabelsCSV := `report_period_start,report_period_end,interval_start,interval_end,namespace,namespace_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,test,label_team:cost|label_env:prod`
+	vmUsageCSV := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,vm_name,vm_uptime_total_seconds
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,node-1,test,vm-1,3600`
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	manifest := newTestManifest(clusterID, "nodes.csv", "namespaces.csv", "vms.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json":  string(manifestJSON),
+		"nodes.csv":      nodeLabelsCSV,
+		"namespaces.csv": namespaceLabelsCSV,
+		"vms.csv":        vmUsageCSV,
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 3)
+	files := make(map[string]FileResult)
+	for _, f := range result.Files {
+		files[f.Name] = f
+	}
+
+	assert.Equal(t, ReportTypeNodeLabels, files["nodes.csv"].ReportType)
+	assert.Equal(t, FileStatusSucceeded, files["nodes.csv"].Status)
+	assert.Equal(t, 2, files["nodes.csv"].Report.Accepted)
+	assert.Equal(t, 1, files["nodes.csv"].Report.Reasons[ReasonMissingNode])
+	assert.Equal(t, ReportTypeNamespaceLabels, files["namespaces.csv"].ReportType)
+	assert.Equal(t, FileStatusSucceeded, files["namespaces.csv"].Status)
+	assert.Equal(t, ReportTypeVMUsage, files["vms.csv"].ReportType)
+	assert.Equal(t, FileStatusSkipped, files["vms.csv"].Status)
+
+	// The latest interval's labels are kept regardless of row order
+	var zone string
+	err = pool.QueryRow(ctx, "SELECT labels->>'label_zone' FROM node_labels WHERE node = 'node-1'").Scan(&zone)
+	require.NoError(t, err)
+	assert.Equal(t, "b", zone)
+
+	var team string
+	err = pool.QueryRow(ctx, "SELECT labels->>'label_team' FROM namespace_labels WHERE namespace = 'test'").Scan(&team)
+	require.NoError(t, err)
+	assert.Equal(t, "cost", team)
+}
+
+func TestProcessTarLabelReportsIngestionRules(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	for _, rule := range []ingestrules.Rule{
+		{Action: ingestrules.ActionExclude, Field: ingestrules.FieldNamespace, Pattern: "openshift-*"},
+		{Action: ingestrules.ActionExclude, Field: ingestrules.FieldNodeRole, Pattern: "infra"},
+	} {
+		require.NoError(t, repo.CreateIngestionRule(&rule))
+	}
+
+	nodeLabelsCSV := `report_period_start,report_period_end,interval_start,interval_end,node,node_role,node_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,node-1,worker,label_zone:a
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,node-2,infra,label_zone:b`
+	namespaceLabelsCSV := `report_period_start,report_period_end,interval_start,interval_end,namespace,namespace_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,test,label_team:cost
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,openshift-monitoring,label_team:platform`
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	manifest := newTestManifest(clusterID, "nodes.csv", "namespaces.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json":  string(manifestJSON),
+		"nodes.csv":      nodeLabelsCSV,
+		"namespaces.csv": namespaceLabelsCSV,
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 2)
+	files := make(map[string]FileResult)
+	for _, f := range result.Files {
+		files[f.Name] = f
+	}
+
+	assert.Equal(t, 1, files["nodes.csv"].Report.Accepted)
+	assert.Equal(t, 1, files["nodes.csv"].Report.Reasons[ReasonExcludedNodeRole])
+	assert.Equal(t, 1, files["namespaces.csv"].Report.Accepted)
+	assert.Equal(t, 1, files["namespaces.csv"].Report.Reasons[ReasonExcludedNamespace])
+
+	var nodes, namespaces int
+	err = pool.QueryRow(ctx, "SELECT (SELECT COUNT(*) FROM node_labels), (SELECT COUNT(*) FROM namespace_labels)").Scan(&nodes, &namespaces)
+	require.NoError(t, err)
+	assert.Equal(t, 1, nodes)
+	assert.Equal(t, 1, namespaces)
+}
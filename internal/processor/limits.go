@@ -0,0 +1,108 @@
+package processor
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+)
+
+// Limits caps what an upload may expand to. A zero field means no limit.
+type Limits struct {
+	// MaxDecompressedBytes caps the total size of all files in the upload
+	MaxDecompressedBytes int64
+	// MaxEntries caps the number of files in an archive
+	MaxEntries int
+	// MaxEntryBytes caps the size of any single file
+	MaxEntryBytes int64
+	// MaxCompressionRatio caps the decompressed size relative to the uploaded size
+	MaxCompressionRatio float64
+}
+
+// ratioCheckFloor is the decompressed size below which the compression ratio is not
+// checked; small, repetitive files compress far better than a real report would
+const ratioCheckFloor = 1 << 20
+
+// LimitError reports an upload that exceeds one of its Limits
+type LimitError struct {
+	Message string
+}
+
+func (e *LimitError) Error() string {
+	return "upload too large: " + e.Message
+}
+
+// EntryError reports an archive entry that may not be extracted
+type EntryError struct {
+	Name   string
+	Reason string
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("invalid archive entry %q: %s", e.Name, e.Reason)
+}
+
+// checkEntryName rejects entry names that could escape the archive when extracted
+// and returns the cleaned name
+func checkEntryName(name string) (string, error) {
+	if name == "" {
+		return "", &EntryError{Name: name, Reason: "empty name"}
+	}
+	if strings.HasPrefix(name, "/") || strings.HasPrefix(name, "\\") || (len(name) > 1 && name[1] == ':') {
+		return "", &EntryError{Name: name, Reason: "absolute paths are not allowed"}
+	}
+	for _, part := range strings.FieldsFunc(name, func(r rune) bool { return r == '/' || r == '\\' }) {
+		if part == ".." {
+			return "", &EntryError{Name: name, Reason: "parent directory references are not allowed"}
+		}
+	}
+	return path.Clean(name), nil
+}
+
+// limiter enforces Limits over one pass of an upload
+type limiter struct {
+	limits         Limits
+	compressedSize int64
+	entries        int
+	total          int64
+}
+
+// entry counts a file of the upload
+func (l *limiter) entry() error {
+	l.entries++
+	if l.limits.MaxEntries > 0 && l.entries > l.limits.MaxEntries {
+		return &LimitError{Message: fmt.Sprintf("more than %d files in archive", l.limits.MaxEntries)}
+	}
+	return nil
+}
+
+// wrap counts the bytes read from a file, failing the read once a limit is exceeded
+func (l *limiter) wrap(name string, r io.Reader) io.Reader {
+	return &limitedReader{l: l, name: name, r: r}
+}
+
+type limitedReader struct {
+	l    *limiter
+	name string
+	r    io.Reader
+	n    int64
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	n, err := lr.r.Read(p)
+	lr.n += int64(n)
+	lr.l.total += int64(n)
+
+	limits := lr.l.limits
+	if limits.MaxEntryBytes > 0 && lr.n > limits.MaxEntryBytes {
+		return n, &LimitError{Message: fmt.Sprintf("%s is larger than %d bytes", lr.name, limits.MaxEntryBytes)}
+	}
+	if limits.MaxDecompressedBytes > 0 && lr.l.total > limits.MaxDecompressedBytes {
+		return n, &LimitError{Message: fmt.Sprintf("decompressed size is larger than %d bytes", limits.MaxDecompressedBytes)}
+	}
+	if limits.MaxCompressionRatio > 0 && lr.l.compressedSize > 0 && lr.l.total > ratioCheckFloor &&
+		float64(lr.l.total) > limits.MaxCompressionRatio*float64(lr.l.compressedSize) {
+		return n, &LimitError{Message: fmt.Sprintf("compression ratio is higher than %g", limits.MaxCompressionRatio)}
+	}
+	return n, err
+}
@@ -0,0 +1,186 @@
+package processor
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readArchive reads every file of an upload, returning the first error
+func readArchive(t *testing.T, uploadPath string, limits Limits) error {
+	format, err := DetectFormat(uploadPath)
+	require.NoError(t, err)
+	archive, err := openArchive(uploadPath, format, "upload.csv", limits)
+	require.NoError(t, err)
+	defer archive.close()
+
+	for {
+		_, content, err := archive.next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, content); err != nil {
+			return err
+		}
+	}
+}
+
+func TestCheckEntryName(t *testing.T) {
+	for name, want := range map[string]string{
+		"manifest.json":        "manifest.json",
+		"./data.csv":           "data.csv",
+		"reports/a/../b.csv":   "",
+		"reports//usage.csv":   "reports/usage.csv",
+		"/etc/passwd":          "",
+		"\\windows\\win.ini":   "",
+		"C:/windows/win.ini":   "",
+		"../manifest.json":     "",
+		"reports\\..\\x.csv":   "",
+		"":                     "",
+		"reports/..hidden.csv": "reports/..hidden.csv",
+	} {
+		got, err := checkEntryName(name)
+		if want == "" {
+			var entryErr *EntryError
+			assert.ErrorAs(t, err, &entryErr, name)
+			continue
+		}
+		require.NoError(t, err, name)
+		assert.Equal(t, want, got)
+	}
+}
+
+func TestOpenArchiveRejectsUnsafeEntries(t *testing.T) {
+	tarWith := func(header *tar.Header) []byte {
+		var buf bytes.Buffer
+		tw := tar.NewWriter(&buf)
+		require.NoError(t, tw.WriteHeader(header))
+		require.NoError(t, tw.Close())
+		return gzipBytes(t, buf.Bytes())
+	}
+	zipSymlink := func() []byte {
+		var buf bytes.Buffer
+		zw := zip.NewWriter(&buf)
+		fh := &zip.FileHeader{Name: "data.csv"}
+		fh.SetMode(fs.ModeSymlink | 0777)
+		w, err := zw.CreateHeader(fh)
+		require.NoError(t, err)
+		_, err = w.Write([]byte("/etc/passwd"))
+		require.NoError(t, err)
+		require.NoError(t, zw.Close())
+		return buf.Bytes()
+	}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"absolute path", gzipBytes(t, tarBytes(t, []testFile{{"/tmp/data.csv", "a\n"}})), "absolute paths are not allowed"},
+		{"parent directory", gzipBytes(t, tarBytes(t, []testFile{{"../data.csv", "a\n"}})), "parent directory references are not allowed"},
+		{"symlink", tarWith(&tar.Header{Name: "data.csv", Typeflag: tar.TypeSymlink, Linkname: "/etc/passwd"}), "links are not allowed"},
+		{"hard link", tarWith(&tar.Header{Name: "data.csv", Typeflag: tar.TypeLink, Linkname: "/etc/passwd"}), "links are not allowed"},
+		{"device", tarWith(&tar.Header{Name: "data.csv", Typeflag: tar.TypeChar}), "only regular files are allowed"},
+		{"zip parent directory", zipBytes(t, []testFile{{"../../data.csv", "a\n"}}), "parent directory references are not allowed"},
+		{"zip symlink", zipSymlink(), "links are not allowed"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readArchive(t, writeUpload(t, tt.data), Limits{})
+			var entryErr *EntryError
+			require.ErrorAs(t, err, &entryErr)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestOpenArchiveLimits(t *testing.T) {
+	files := []testFile{{"a.csv", strings.Repeat("a", 1000)}, {"b.csv", strings.Repeat("b", 1000)}, {"c.csv", "c"}}
+	upload := writeUpload(t, gzipBytes(t, tarBytes(t, files)))
+	bomb := writeUpload(t, gzipBytes(t, make([]byte, 4<<20)))
+
+	tests := []struct {
+		name    string
+		path    string
+		limits  Limits
+		wantErr string
+	}{
+		{"within limits", upload, Limits{MaxEntries: 3, MaxEntryBytes: 1000, MaxDecompressedBytes: 2001, MaxCompressionRatio: 2}, ""},
+		{"too many entries", upload, Limits{MaxEntries: 2}, "more than 2 files in archive"},
+		{"entry too large", upload, Limits{MaxEntryBytes: 999}, "a.csv is larger than 999 bytes"},
+		{"decompressed too large", upload, Limits{MaxDecompressedBytes: 1500}, "decompressed size is larger than 1500 bytes"},
+		{"compression ratio", bomb, Limits{MaxCompressionRatio: 100}, "compression ratio is higher than 100"},
+		{"no limits", bomb, Limits{}, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := readArchive(t, tt.path, tt.limits)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			var limitErr *LimitError
+			require.ErrorAs(t, err, &limitErr)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestInspectUpload(t *testing.T) {
+	files := []testFile{{"manifest.json", "{}"}, {"data.csv", "a,b\n1,2\n"}}
+	format, err := InspectUpload(writeUpload(t, zstdBytes(t, tarBytes(t, files))))
+	require.NoError(t, err)
+	assert.Equal(t, FormatTarZst, format)
+
+	format, err = InspectUpload(writeUpload(t, gzipBytes(t, []byte("a,b\n1,2\n"))))
+	require.NoError(t, err)
+	assert.Equal(t, FormatCSVGz, format)
+
+	_, err = InspectUpload(writeUpload(t, gzipBytes(t, tarBytes(t, []testFile{{"../data.csv", "a\n"}}))))
+	var entryErr *EntryError
+	assert.ErrorAs(t, err, &entryErr)
+
+	// Contents are not read, so a bomb passes inspection and is left to the worker
+	_, err = InspectUpload(writeUpload(t, gzipBytes(t, make([]byte, 4<<20))))
+	assert.NoError(t, err)
+}
+
+func TestProcessUploadEnforcesLimits(t *testing.T) {
+	bomb := writeUpload(t, gzipBytes(t, make([]byte, 4<<20)))
+
+	// The limit is hit while reading the upload, before the database is used
+	_, err := ProcessUpload(context.Background(), bomb, db.NewRepository(nil), Options{Limits: Limits{MaxCompressionRatio: 100}},
+		UploadParams{ClusterID: "10f5a0f9-223a-41c1-8456-9a3eb0323a99"})
+	var limitErr *LimitError
+	require.ErrorAs(t, err, &limitErr)
+	assert.Contains(t, err.Error(), "compression ratio is higher than 100")
+}
+
+func TestProcessTarIgnoresNestedManifest(t *testing.T) {
+	manifest := newTestManifest("10f5a0f9-223a-41c1-8456-9a3eb0323a99", "data.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+	tarPath := createTarGz(t, map[string]string{
+		"evil/manifest.json": string(manifestJSON),
+		"data.csv":           storageCSV,
+	})
+
+	// The nested manifest is not used, so the upload is rejected before the database is used
+	_, err := ProcessTar(context.Background(), tarPath, db.NewRepository(nil), Options{})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "no manifest.json found in tar archive")
+}
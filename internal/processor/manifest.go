@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Manifest represents the structure of manifest.json written by the cost management operator
+type Manifest struct {
+	UUID            string    `json:"uuid"`
+	ClusterID       string    `json:"cluster_id"`
+	ClusterVersion  string    `json:"cluster_version"`
+	Version         string    `json:"version"`
+	OperatorVersion string    `json:"operator_version"`
+	Date            time.Time `json:"date"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Certified       bool      `json:"certified"`
+	Files           []string  `json:"files"`
+	CRStatus        CRStatus  `json:"cr_status"`
+
+	// Raw is the manifest as uploaded, kept for auditing
+	Raw json.RawMessage `json:"-"`
+}
+
+// CRStatus is the operator custom resource status reported with an upload
+type CRStatus struct {
+	ClusterID      string          `json:"clusterID"`
+	ClusterVersion string          `json:"clusterVersion"`
+	OperatorCommit string          `json:"operator_commit"`
+	Source         CRStatusSource  `json:"source"`
+	Reports        CRStatusReports `json:"reports"`
+}
+
+// CRStatusSource names the cost management source the cluster reports to
+type CRStatusSource struct {
+	Name string `json:"name"`
+}
+
+// CRStatusReports describes the operator's most recent report collection
+type CRStatusReports struct {
+	ReportMonth           string `json:"report_month"`
+	LastHourQueried       string `json:"last_hour_queried"`
+	DataCollected         bool   `json:"data_collected"`
+	DataCollectionMessage string `json:"data_collection_message"`
+}
+
+// parseManifest decodes manifest.json, keeping the raw document
+func parseManifest(data []byte) (Manifest, error) {
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return manifest, fmt.Errorf("failed to parse manifest.json: %w", err)
+	}
+	manifest.Raw = append(json.RawMessage(nil), data...)
+	return manifest, nil
+}
+
+// Validate checks that the manifest has its required fields, a consistent reporting
+// window and, when minOperatorVersion is set, an operator_version at least that recent
+func (m *Manifest) Validate(minOperatorVersion string) error {
+	var problems []string
+	if m.UUID == "" {
+		problems = append(problems, "missing uuid")
+	} else if _, err := uuid.Parse(m.UUID); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid uuid %q", m.UUID))
+	}
+	if m.ClusterID == "" {
+		problems = append(problems, "missing cluster_id")
+	} else if _, err := uuid.Parse(m.ClusterID); err != nil {
+		problems = append(problems, fmt.Sprintf("invalid cluster_id %q", m.ClusterID))
+	}
+	if m.Version == "" {
+		problems = append(problems, "missing version")
+	}
+	if len(m.Files) == 0 {
+		problems = append(problems, "no files listed")
+	}
+	if !m.Start.IsZero() && !m.End.IsZero() && m.End.Before(m.Start) {
+		problems = append(problems, fmt.Sprintf("end %s is before start %s", m.End.Format(time.RFC3339), m.Start.Format(time.RFC3339)))
+	}
+
+	if minOperatorVersion != "" {
+		if m.OperatorVersion == "" {
+			problems = append(problems, fmt.Sprintf("missing operator_version (minimum supported is %s)", minOperatorVersion))
+		} else if older, err := versionOlder(m.OperatorVersion, minOperatorVersion); err != nil {
+			problems = append(problems, err.Error())
+		} else if older {
+			problems = append(problems, fmt.Sprintf("unsupported operator_version %s (minimum supported is %s)", m.OperatorVersion, minOperatorVersion))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid manifest: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// ParseVersion parses a semantic version such as "v3.1.0" or "3.1.0-rc1" into its
+// major, minor and patch numbers; missing minor or patch numbers are 0
+func ParseVersion(v string) ([3]int, error) {
+	var parsed [3]int
+	s := strings.TrimPrefix(strings.TrimSpace(v), "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if s == "" || len(parts) > 3 {
+		return parsed, fmt.Errorf("invalid version %q", v)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return parsed, fmt.Errorf("invalid version %q", v)
+		}
+		parsed[i] = n
+	}
+	return parsed, nil
+}
+
+// versionOlder reports whether version v is older than minimum
+func versionOlder(v, minimum string) (bool, error) {
+	got, err := ParseVersion(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid operator_version %q", v)
+	}
+	want, err := ParseVersion(minimum)
+	if err != nil {
+		return false, err
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return got[i] < want[i], nil
+		}
+	}
+	return false, nil
+}
+
+// reportSource describes the manifest's files, bounded by its reporting window
+func (m *Manifest) reportSource() ReportSource {
+	return ReportSource{ClusterID: m.ClusterID, Start: m.Start, End: m.End}
+}
@@ -0,0 +1,168 @@
+package processor
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const operatorManifest = `{
+	"uuid": "4b9a2b2c-4c1d-4a63-9a4c-1b2f7d1f7a10",
+	"cluster_id": "10f5a0f9-223a-41c1-8456-9a3eb0323a99",
+	"cluster_version": "4.14.8",
+	"version": "b5a2c05255069215eb564dcc5c4ec6ca4b33325d",
+	"operator_version": "3.2.1",
+	"date": "2025-05-17T16:05:10.123456Z",
+	"start": "2025-05-17T14:00:00Z",
+	"end": "2025-05-17T15:59:59Z",
+	"certified": true,
+	"files": ["0_openshift_usage_report.0.csv"],
+	"cr_status": {
+		"clusterID": "10f5a0f9-223a-41c1-8456-9a3eb0323a99",
+		"clusterVersion": "4.14.8",
+		"operator_commit": "b5a2c05255069215eb564dcc5c4ec6ca4b33325d",
+		"source": {"name": "prod-east", "create_source": false},
+		"reports": {"report_month": "05", "last_hour_queried": "2025-05-17 15:00:00 - 2025-05-17 15:59:59", "data_collected": true},
+		"upload": {"upload_cycle": 360}
+	}
+}`
+
+func TestParseManifest(t *testing.T) {
+	manifest, err := parseManifest([]byte(operatorManifest))
+	require.NoError(t, err)
+
+	assert.Equal(t, "3.2.1", manifest.OperatorVersion)
+	assert.Equal(t, "b5a2c05255069215eb564dcc5c4ec6ca4b33325d", manifest.Version)
+	assert.Equal(t, "4.14.8", manifest.ClusterVersion)
+	assert.True(t, manifest.Certified)
+	assert.Equal(t, time.Date(2025, 5, 17, 14, 0, 0, 0, time.UTC), manifest.Start)
+	assert.Equal(t, "prod-east", manifest.CRStatus.Source.Name)
+	assert.Equal(t, "05", manifest.CRStatus.Reports.ReportMonth)
+	assert.True(t, manifest.CRStatus.Reports.DataCollected)
+	assert.JSONEq(t, operatorManifest, string(manifest.Raw), "Raw keeps fields that are not parsed")
+	assert.NoError(t, manifest.Validate("3.0.0"))
+}
+
+func TestManifestValidate(t *testing.T) {
+	valid := func() Manifest {
+		m, err := parseManifest([]byte(operatorManifest))
+		require.NoError(t, err)
+		return m
+	}
+
+	tests := []struct {
+		name       string
+		modify     func(m *Manifest)
+		minVersion string
+		wantErr    string
+	}{
+		{name: "valid", modify: func(m *Manifest) {}},
+		{name: "missing uuid", modify: func(m *Manifest) { m.UUID = "" }, wantErr: "missing uuid"},
+		{name: "invalid cluster_id", modify: func(m *Manifest) { m.ClusterID = "abc" }, wantErr: `invalid cluster_id "abc"`},
+		{name: "missing version", modify: func(m *Manifest) { m.Version = "" }, wantErr: "missing version"},
+		{name: "no files", modify: func(m *Manifest) { m.Files = nil }, wantErr: "no files listed"},
+		{name: "end before start", modify: func(m *Manifest) { m.End = m.Start.Add(-time.Hour) }, wantErr: "is before start"},
+		{name: "supported version", modify: func(m *Manifest) { m.OperatorVersion = "v3.10.0" }, minVersion: "3.2"},
+		{name: "unsupported version", modify: func(m *Manifest) {}, minVersion: "3.3.0", wantErr: "unsupported operator_version 3.2.1"},
+		{name: "missing operator_version", modify: func(m *Manifest) { m.OperatorVersion = "" }, minVersion: "3.0.0", wantErr: "missing operator_version"},
+		{name: "invalid operator_version", modify: func(m *Manifest) { m.OperatorVersion = "latest" }, minVersion: "3.0.0", wantErr: `invalid operator_version "latest"`},
+		{name: "no minimum", modify: func(m *Manifest) { m.OperatorVersion = "" }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := valid()
+			tt.modify(&m)
+			err := m.Validate(tt.minVersion)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+}
+
+func TestParseVersion(t *testing.T) {
+	v, err := ParseVersion("v3.1.0-rc1")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{3, 1, 0}, v)
+
+	v, err = ParseVersion("4")
+	require.NoError(t, err)
+	assert.Equal(t, [3]int{4, 0, 0}, v)
+
+	for _, bad := range []string{"", "v", "1.2.3.4", "1.x"} {
+		_, err = ParseVersion(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestReportSourceCheckWindow(t *testing.T) {
+	src := ReportSource{
+		Start: time.Date(2025, 5, 17, 14, 30, 0, 0, time.UTC),
+		End:   time.Date(2025, 5, 17, 15, 59, 59, 0, time.UTC),
+	}
+	assert.NoError(t, src.checkWindow(time.Date(2025, 5, 17, 14, 0, 0, 0, time.UTC)), "window start is widened to the hour")
+	assert.NoError(t, src.checkWindow(time.Date(2025, 5, 17, 15, 0, 0, 0, time.UTC)))
+	assert.Error(t, src.checkWindow(time.Date(2025, 5, 17, 13, 0, 0, 0, time.UTC)))
+	assert.Error(t, src.checkWindow(time.Date(2025, 5, 17, 16, 0, 0, 0, time.UTC)))
+	assert.NoError(t, ReportSource{}.checkWindow(time.Now()), "an open window accepts everything")
+}
+
+func TestProcessTarRejectsUnsupportedOperator(t *testing.T) {
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json":                  operatorManifest,
+		"0_openshift_usage_report.0.csv": "",
+	})
+
+	// The manifest is rejected before the database is used
+	result, err := ProcessTar(context.Background(), tarPath, db.NewRepository(nil), Options{MinOperatorVersion: "4.0.0"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported operator_version 3.2.1")
+	require.NotNil(t, result)
+	require.NotNil(t, result.Manifest)
+	assert.Equal(t, "3.2.1", result.Manifest.OperatorVersion)
+	assert.Empty(t, result.ClusterID, "no cluster is recorded for a rejected manifest")
+}
+
+func TestProcessTarRejectsRowsOutsideManifestWindow(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+	t.Setenv("POD_LABEL_KEYS", "label_rht_comp")
+
+	row := func(hour string) string {
+		return "2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 " + hour + ":00:00 +0000 UTC,2025-05-17 " + hour + ":59:59 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,label_rht_comp:EAP"
+	}
+	csvData := strings.Join([]string{strings.Join(RequiredHeaders, ","), row("13"), row("14"), row("15"), row("16")}, "\n")
+
+	var manifest map[string]any
+	require.NoError(t, json.Unmarshal([]byte(operatorManifest), &manifest))
+	manifest["uuid"] = "0f6f7c4e-9a55-4cc2-8f8f-6b2b0d6f7e21"
+	manifestJSON, _ := json.Marshal(manifest)
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json":                  string(manifestJSON),
+		"0_openshift_usage_report.0.csv": csvData,
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{MinOperatorVersion: "3.0.0"})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+	assert.Equal(t, 2, result.Files[0].Report.Accepted)
+	assert.Equal(t, 2, result.Files[0].Report.Reasons[ReasonOutsideManifestWindow])
+
+	var count int
+	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM pod_metrics").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
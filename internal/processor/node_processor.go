@@ -0,0 +1,146 @@
+package processor
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+)
+
+// NodeUsageHeaders is the subset of node report headers that must be present. A node
+// report holds one row per node and interval; node_role and resource_id are optional.
+var NodeUsageHeaders = []string{
+	"report_period_start", "report_period_end", "interval_start", "interval_end",
+	"node", "node_capacity_cpu_cores", "node_capacity_memory_bytes",
+}
+
+// nodeSample is the capacity a row reports for its node
+type nodeSample struct {
+	key         db.NodeKey
+	coreCount   int
+	memoryBytes int64
+}
+
+// parseNodeSample reads the node columns of a pod usage or node report row. A missing
+// node_role is a worker.
+func parseNodeSample(record []string, headerIndices map[string]int, clusterID uuid.UUID) (nodeSample, *rowError) {
+	name := record[headerIndices["node"]]
+	if name == "" {
+		return nodeSample{}, &rowError{Reason: ReasonMissingNode, Message: "empty node"}
+	}
+
+	capacityCPUStr := record[headerIndices["node_capacity_cpu_cores"]]
+	capacityCPU, err := strconv.ParseFloat(capacityCPUStr, 64)
+	if err != nil {
+		return nodeSample{}, &rowError{Reason: ReasonInvalidNodeCapacityCPUCores, Message: fmt.Sprintf("invalid node_capacity_cpu_cores %q", capacityCPUStr)}
+	}
+
+	capacityMemoryStr := record[headerIndices["node_capacity_memory_bytes"]]
+	capacityMemory, err := strconv.ParseFloat(capacityMemoryStr, 64)
+	if err != nil {
+		return nodeSample{}, &rowError{Reason: ReasonInvalidNodeCapacityMemory, Message: fmt.Sprintf("invalid node_capacity_memory_bytes %q", capacityMemoryStr)}
+	}
+
+	nodeType := optionalField(record, headerIndices, "node_role")
+	if nodeType == "" {
+		nodeType = "worker" // Default
+	}
+	return nodeSample{
+		key: db.NodeKey{
+			ClusterID:  clusterID,
+			Name:       name,
+			Identifier: optionalField(record, headerIndices, "resource_id"), // Empty is stored as NULL
+			Type:       nodeType,
+		},
+		coreCount:   int(capacityCPU),
+		memoryBytes: int64(capacityMemory),
+	}, nil
+}
+
+// optionalField returns a column of the record, or an empty string when the report lacks it
+func optionalField(record []string, headerIndices map[string]int, name string) string {
+	if i, ok := headerIndices[name]; ok {
+		return record[i]
+	}
+	return ""
+}
+
+// ProcessNodeCSV processes a node report, storing each node's capacity in node_metrics and
+// rebuilding node_daily_summary for the days it touches
+func ProcessNodeCSV(ctx context.Context, repo *db.Repository, reader *csv.Reader, clusterID string) (*Report, error) {
+	headers, err := readHeader(reader)
+	if err != nil {
+		return newReport(), err
+	}
+	return processNodeUsage(ctx, repo, reader, headers, ReportSource{ClusterID: clusterID})
+}
+
+// processNodeUsage ingests the records of a node report whose header has already been read
+func processNodeUsage(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error) {
+	report := newReport()
+
+	headerIndices, err := headerIndex(headers, NodeUsageHeaders)
+	if err != nil {
+		return report, err
+	}
+
+	clusterUUID, err := uuid.Parse(src.ClusterID)
+	if err != nil {
+		return report, fmt.Errorf("invalid cluster_id %s: %w", src.ClusterID, err)
+	}
+
+	loc, err := repo.ReportingLocation(clusterUUID)
+	if err != nil {
+		return report, err
+	}
+	filter, err := loadRowFilter(repo, clusterUUID)
+	if err != nil {
+		return report, err
+	}
+	touchedDates := make(map[time.Time]struct{})
+	batch := newCSVBatch()
+
+	err = eachRecord(reader, headers, report, func(line int, record []string) error {
+		span, rowErr := src.parseInterval(record, headerIndices)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
+		}
+		sample, rowErr := parseNodeSample(record, headerIndices, clusterUUID)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
+		}
+		if reason, message := filter.exclusion("", sample.key.Type); reason != "" {
+			report.skip(line, reason, message, record)
+			return nil
+		}
+
+		batch.addNode(sample.key, span, sample.coreCount, sample.memoryBytes)
+		touchedDates[db.ReportingDate(span.Start, loc)] = struct{}{}
+		report.Accepted++
+
+		if batch.records >= batchSize {
+			return batch.flush(ctx, repo, clusterUUID)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if err := batch.flush(ctx, repo, clusterUUID); err != nil {
+		return report, err
+	}
+
+	// Rebuild daily summaries for every day touched by this file
+	if err := refreshTouchedDays(repo, clusterUUID, touchedDates, nodeSummaries); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,147 @@
+package processor
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"path"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+)
+
+// Names of the report types the operator uploads
+const (
+	ReportTypePodUsage        = "pod_usage"
+	ReportTypeNodeUsage       = "node_usage"
+	ReportTypeStorage         = "storage"
+	ReportTypeNodeLabels      = "node_labels"
+	ReportTypeNamespaceLabels = "namespace_labels"
+	ReportTypeVMUsage         = "vm_usage"
+)
+
+// ReportProcessor ingests the records of a report whose header has already been read
+type ReportProcessor func(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error)
+
+// ReportSource describes the upload a report belongs to. Start and End are the manifest's
+// reporting window; rows whose interval starts outside it are rejected. A zero Start or
+// End leaves that side of the window open. TimestampFormats are tried in order on the
+// report's timestamps; none means DefaultTimestampFormats.
+type ReportSource struct {
+	ClusterID        string
+	Start            time.Time
+	End              time.Time
+	TimestampFormats []string
+	// ComponentRules attribute pods to components; nil is components.Default
+	ComponentRules *components.RuleSet
+}
+
+// checkWindow returns an error if an interval starting at t lies outside the reporting window.
+// The window start is widened to the hour since the operator reports hourly intervals.
+func (s ReportSource) checkWindow(t time.Time) error {
+	if (!s.Start.IsZero() && t.Before(s.Start.Truncate(time.Hour))) || (!s.End.IsZero() && t.After(s.End)) {
+		return fmt.Errorf("interval_start %s is outside the manifest window %s to %s",
+			t.Format(time.RFC3339), s.Start.Format(time.RFC3339), s.End.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// ReportType describes a kind of report found in an upload and how to ingest it.
+// A file is of this type when its header contains every one of Headers, or failing
+// that, when its base name matches one of FilePatterns (path.Match syntax). A type
+// with no Process is recognized but not ingested; its files are reported as skipped.
+// TimestampFormats are the formats its timestamps are parsed with unless overridden in
+// Options; none means DefaultTimestampFormats.
+type ReportType struct {
+	Name             string
+	Headers          []string
+	FilePatterns     []string
+	Process          ReportProcessor
+	TimestampFormats []string
+}
+
+// VMUsageHeaders is the subset of virtual machine usage report headers used to recognize it
+var VMUsageHeaders = []string{
+	"interval_start", "interval_end", "namespace", "vm_name", "vm_uptime_total_seconds",
+}
+
+// reportTypes holds the registered report types in the order they are tried
+var reportTypes = []ReportType{
+	{
+		Name:         ReportTypePodUsage,
+		Headers:      RequiredHeaders,
+		FilePatterns: []string{"*openshift_usage_report*.csv", "*cm-openshift-usage-*.csv"},
+		Process:      processPodUsage,
+	},
+	{
+		// Tried after pod usage, whose header also holds every node usage column
+		Name:         ReportTypeNodeUsage,
+		Headers:      NodeUsageHeaders,
+		FilePatterns: []string{"*openshift_node_usage_report*.csv", "*cm-openshift-node-usage-*.csv"},
+		Process:      processNodeUsage,
+	},
+	{
+		Name:         ReportTypeStorage,
+		Headers:      StorageHeaders,
+		FilePatterns: []string{"*openshift_storage_usage_report*.csv", "*cm-openshift-persistentvolumeclaim-*.csv"},
+		Process:      processStorage,
+	},
+	{
+		Name:         ReportTypeNodeLabels,
+		Headers:      NodeLabelHeaders,
+		FilePatterns: []string{"*openshift_node_labels*.csv", "*cm-openshift-node-labels-*.csv"},
+		Process:      processNodeLabels,
+	},
+	{
+		Name:         ReportTypeNamespaceLabels,
+		Headers:      NamespaceLabelHeaders,
+		FilePatterns: []string{"*openshift_namespace_labels*.csv", "*cm-openshift-namespace-labels-*.csv"},
+		Process:      processNamespaceLabels,
+	},
+	{
+		Name:         ReportTypeVMUsage,
+		Headers:      VMUsageHeaders,
+		FilePatterns: []string{"*openshift_vm_usage_report*.csv", "*cm-openshift-vm-usage-*.csv"},
+	},
+}
+
+// RegisterReportType adds a report type, replacing any registered type with the same name.
+// It is not safe to call while uploads are being processed; register types at startup.
+func RegisterReportType(t ReportType) {
+	if existing := findReportType(t.Name); existing != nil {
+		*existing = t
+		return
+	}
+	reportTypes = append(reportTypes, t)
+}
+
+// findReportType returns the registered type with the given name, or nil
+func findReportType(name string) *ReportType {
+	for i := range reportTypes {
+		if reportTypes[i].Name == name {
+			return &reportTypes[i]
+		}
+	}
+	return nil
+}
+
+// DetectReportType returns the registered type of a file given its name and header,
+// or nil if none matches. Header signatures are tried before file name patterns so a
+// renamed file is still recognized by its content.
+func DetectReportType(filename string, headers []string) *ReportType {
+	for i := range reportTypes {
+		if len(reportTypes[i].Headers) > 0 && hasHeaders(headers, reportTypes[i].Headers) {
+			return &reportTypes[i]
+		}
+	}
+	base := path.Base(filename)
+	for i := range reportTypes {
+		for _, pattern := range reportTypes[i].FilePatterns {
+			if ok, _ := path.Match(pattern, base); ok {
+				return &reportTypes[i]
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDetectReportType(t *testing.T) {
+	tests := []struct {
+		name     string
+		filename string
+		headers  []string
+		want     string
+	}{
+		{name: "pod usage header", filename: "data.csv", headers: RequiredHeaders, want: ReportTypePodUsage},
+		{name: "node usage header", filename: "data.csv", headers: append(NodeUsageHeaders, "node_role"), want: ReportTypeNodeUsage},
+		{name: "node usage name", filename: "0_openshift_node_usage_report.0.csv", headers: []string{"node"}, want: ReportTypeNodeUsage},
+		{name: "storage header", filename: "data.csv", headers: append(StorageHeaders, "persistentvolume_labels"), want: ReportTypeStorage},
+		{name: "node labels header", filename: "data.csv", headers: NodeLabelHeaders, want: ReportTypeNodeLabels},
+		{name: "namespace labels header", filename: "data.csv", headers: NamespaceLabelHeaders, want: ReportTypeNamespaceLabels},
+		{name: "vm usage header", filename: "data.csv", headers: append([]string{"node"}, VMUsageHeaders...), want: ReportTypeVMUsage},
+		{name: "header wins over name", filename: "0_openshift_usage_report.0.csv", headers: NodeLabelHeaders, want: ReportTypeNodeLabels},
+		{name: "name when header is incomplete", filename: "reports/0_openshift_storage_usage_report.1.csv", headers: []string{"namespace"}, want: ReportTypeStorage},
+		{name: "unknown", filename: "data.csv", headers: []string{"invalid_header"}, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DetectReportType(tt.filename, tt.headers)
+			if tt.want == "" {
+				assert.Nil(t, got)
+				return
+			}
+			require.NotNil(t, got)
+			assert.Equal(t, tt.want, got.Name)
+		})
+	}
+}
+
+func TestRegisterReportType(t *testing.T) {
+	saved := append([]ReportType(nil), reportTypes...)
+	defer func() { reportTypes = saved }()
+
+	RegisterReportType(ReportType{Name: "gpu_usage", Headers: []string{"gpu_name", "gpu_uptime_seconds"}})
+	got := DetectReportType("data.csv", []string{"interval_start", "gpu_name", "gpu_uptime_seconds"})
+	require.NotNil(t, got)
+	assert.Equal(t, "gpu_usage", got.Name)
+	assert.Nil(t, got.Process)
+
+	// Registering an existing name replaces it
+	RegisterReportType(ReportType{Name: ReportTypeVMUsage, FilePatterns: []string{"*vms*.csv"}})
+	assert.Nil(t, DetectReportType("data.csv", VMUsageHeaders))
+	got = DetectReportType("vms.csv", nil)
+	require.NotNil(t, got)
+	assert.Equal(t, ReportTypeVMUsage, got.Name)
+}
+
+func TestParseLabels(t *testing.T) {
+	assert.Equal(t, map[string]string{"label_app": "web", "label_url": "http://x"}, parseLabels("label_app:web| label_url:http://x|bad"))
+	assert.Empty(t, parseLabels(""))
+}
@@ -0,0 +1,139 @@
+package processor
+
+import (
+	"log"
+	"strings"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+)
+
+// File states reported for each CSV found in an upload. A file is unrecognized when
+// neither its header nor its name matches a registered report type.
+const (
+	FileStatusSucceeded    = db.FileStatusSucceeded
+	FileStatusFailed       = "failed"
+	FileStatusSkipped      = "skipped"
+	FileStatusDuplicate    = "duplicate"
+	FileStatusUnrecognized = "unrecognized"
+)
+
+// Reason codes for CSV rows that were rejected or skipped
+const (
+	ReasonMalformedRow                      = "malformed_row"
+	ReasonFieldCount                        = "field_count_mismatch"
+	ReasonInvalidIntervalStart              = "invalid_interval_start"
+	ReasonInvalidIntervalEnd                = "invalid_interval_end"
+	ReasonInvalidInterval                   = "interval_end_not_after_start"
+	ReasonInvalidReportPeriod               = "invalid_report_period"
+	ReasonOutsideReportPeriod               = "outside_report_period"
+	ReasonOutsideManifestWindow             = "outside_manifest_window"
+	ReasonInvalidNodeCapacityCPUCores       = "invalid_node_capacity_cpu_cores"
+	ReasonInvalidPodUsage                   = "invalid_pod_usage_cpu_core_seconds"
+	ReasonInvalidNodeCapacityCPUCoreSeconds = "invalid_node_capacity_cpu_core_seconds"
+	ReasonInvalidPodMemoryUsage             = "invalid_pod_usage_memory_byte_seconds"
+	ReasonInvalidNodeCapacityMemory         = "invalid_node_capacity_memory_bytes"
+	ReasonInvalidNodeCapacityMemorySeconds  = "invalid_node_capacity_memory_byte_seconds"
+	ReasonMissingPersistentVolumeClaim      = "missing_persistentvolumeclaim"
+	ReasonInvalidPVCCapacity                = "invalid_persistentvolumeclaim_capacity_bytes"
+	ReasonInvalidPVCCapacitySeconds         = "invalid_persistentvolumeclaim_capacity_byte_seconds"
+	ReasonInvalidPVCUsage                   = "invalid_persistentvolumeclaim_usage_byte_seconds"
+	ReasonMissingNode                       = "missing_node"
+	ReasonMissingNamespace                  = "missing_namespace"
+	ReasonExcludedCluster                   = "excluded_cluster"
+	ReasonExcludedNamespace                 = "excluded_namespace"
+	ReasonExcludedNodeRole                  = "excluded_node_role"
+)
+
+// maxRowSamples caps the number of offending rows kept in a file's report
+const maxRowSamples = 20
+
+// RowSample is an offending CSV row kept in a Report
+type RowSample struct {
+	Line    int
+	Reason  string
+	Message string
+	Row     string
+}
+
+// Report counts the rows of a CSV file by outcome. Accepted rows were stored in full,
+// rejected rows were invalid and not stored, and skipped rows were valid but left out
+// on purpose. A pod usage row whose pod data is rejected or skipped still stores the
+// capacity of its node, so node hours do not depend on the pods.
+type Report struct {
+	Accepted int
+	Rejected int
+	Skipped  int
+	Reasons  map[string]int
+	Samples  []RowSample
+}
+
+func newReport() *Report {
+	return &Report{Reasons: make(map[string]int)}
+}
+
+func (r *Report) reject(line int, reason, message string, record []string) {
+	log.Printf("Skipping line %d: %s", line, message)
+	r.Rejected++
+	r.addReason(line, reason, message, record)
+}
+
+func (r *Report) skip(line int, reason, message string, record []string) {
+	r.Skipped++
+	r.addReason(line, reason, message, record)
+}
+
+func (r *Report) addReason(line int, reason, message string, record []string) {
+	r.Reasons[reason]++
+	if len(r.Samples) < maxRowSamples {
+		r.Samples = append(r.Samples, RowSample{
+			Line:    line,
+			Reason:  reason,
+			Message: message,
+			Row:     strings.Join(record, ","),
+		})
+	}
+}
+
+// FileResult captures the outcome of processing a single file from an upload
+type FileResult struct {
+	Name       string
+	ReportType string
+	Status     string
+	Checksum   string
+	Report     Report
+	Error      string
+}
+
+// Result captures the outcome of processing an upload. Format is the detected upload
+// format. Manifest is set once the upload's manifest.json has been parsed, even if it
+// was then rejected; it is nil for uploads described by request parameters.
+type Result struct {
+	ClusterID    string
+	ManifestUUID string
+	Format       string
+	Manifest     *Manifest
+	Files        []FileResult
+}
+
+// Failed returns the number of files that could not be processed
+func (r *Result) Failed() int {
+	failed := 0
+	for _, f := range r.Files {
+		if f.Status == FileStatusFailed {
+			failed++
+		}
+	}
+	return failed
+}
+
+// rollBack marks files that were written in a transaction that was later rolled back,
+// giving why in their error
+func (r *Result) rollBack(reason string) {
+	for i := range r.Files {
+		if r.Files[i].Status == FileStatusSucceeded {
+			r.Files[i].Status = FileStatusFailed
+			r.Files[i].Report.Accepted = 0
+			r.Files[i].Error = "rolled back: " + reason
+		}
+	}
+}
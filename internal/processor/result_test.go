@@ -0,0 +1,35 @@
+package processor
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportCapsSamples(t *testing.T) {
+	report := newReport()
+	for i := 0; i < maxRowSamples+5; i++ {
+		report.reject(i+2, ReasonInvalidPodUsage, "invalid pod_usage_cpu_core_seconds", []string{"a", "b"})
+	}
+	report.skip(100, ReasonMissingNamespace, "missing namespace", nil)
+
+	assert.Equal(t, maxRowSamples+5, report.Rejected)
+	assert.Equal(t, 1, report.Skipped)
+	assert.Equal(t, map[string]int{ReasonInvalidPodUsage: maxRowSamples + 5, ReasonMissingNamespace: 1}, report.Reasons)
+	assert.Len(t, report.Samples, maxRowSamples)
+	assert.Equal(t, 2, report.Samples[0].Line)
+	assert.Equal(t, "a,b", report.Samples[0].Row)
+}
+
+func TestResultRollBack(t *testing.T) {
+	result := &Result{Files: []FileResult{
+		{Name: "a.csv", Status: FileStatusSucceeded, Report: Report{Accepted: 10}},
+		{Name: "b.csv", Status: FileStatusFailed, Error: "missing required header: pod"},
+	}}
+	result.rollBack("another file in the upload failed")
+
+	assert.Equal(t, 2, result.Failed())
+	assert.Equal(t, 0, result.Files[0].Report.Accepted)
+	assert.Equal(t, "rolled back: another file in the upload failed", result.Files[0].Error)
+	assert.Equal(t, "missing required header: pod", result.Files[1].Error)
+}
@@ -0,0 +1,189 @@
+package processor
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+)
+
+// StorageHeaders is the subset of storage report headers that must be present
+var StorageHeaders = []string{
+	"report_period_start", "report_period_end", "interval_start", "interval_end",
+	"namespace", "pod", "persistentvolumeclaim", "persistentvolume", "storageclass",
+	"persistentvolumeclaim_capacity_bytes", "persistentvolumeclaim_capacity_byte_seconds",
+	"volume_request_storage_byte_seconds", "persistentvolumeclaim_usage_byte_seconds",
+}
+
+// storageBatch buffers claims and their samples so they are written in bulk
+type storageBatch struct {
+	claimIndex map[db.PVCKey]int
+	claims     []db.PVCKey
+	metrics    []db.StorageMetricRow
+	claimOf    []int
+}
+
+func newStorageBatch() *storageBatch {
+	return &storageBatch{claimIndex: make(map[db.PVCKey]int)}
+}
+
+func (b *storageBatch) add(key db.PVCKey, metric db.StorageMetricRow) {
+	claim, ok := b.claimIndex[key]
+	if !ok {
+		claim = len(b.claims)
+		b.claimIndex[key] = claim
+		b.claims = append(b.claims, key)
+	}
+	b.metrics = append(b.metrics, metric)
+	b.claimOf = append(b.claimOf, claim)
+}
+
+// flush writes the buffered claims and samples and resets the batch
+func (b *storageBatch) flush(ctx context.Context, repo *db.Repository) error {
+	if len(b.metrics) == 0 {
+		return nil
+	}
+
+	ids, err := repo.UpsertPVCs(ctx, b.claims)
+	if err != nil {
+		return err
+	}
+	for i := range b.metrics {
+		b.metrics[i].PVCID = ids[b.claimOf[i]]
+	}
+	if err := repo.CopyStorageMetrics(ctx, b.metrics); err != nil {
+		return err
+	}
+
+	*b = *newStorageBatch()
+	return nil
+}
+
+// ProcessStorageCSV processes a storage report, storing persistent volume claim capacity,
+// request and usage in storage_metrics and rebuilding storage_daily_summary for the days it touches
+func ProcessStorageCSV(ctx context.Context, repo *db.Repository, reader *csv.Reader, clusterID string) (*Report, error) {
+	headers, err := readHeader(reader)
+	if err != nil {
+		return newReport(), err
+	}
+	return processStorage(ctx, repo, reader, headers, ReportSource{ClusterID: clusterID})
+}
+
+// processStorage ingests the records of a storage report whose header has already been read
+func processStorage(ctx context.Context, repo *db.Repository, reader *csv.Reader, headers []string, src ReportSource) (*Report, error) {
+	report := newReport()
+
+	headerIndices, err := headerIndex(headers, StorageHeaders)
+	if err != nil {
+		return report, err
+	}
+
+	clusterUUID, err := uuid.Parse(src.ClusterID)
+	if err != nil {
+		return report, fmt.Errorf("invalid cluster_id %s: %w", src.ClusterID, err)
+	}
+
+	// Summaries are rebuilt for the days touched in the cluster's reporting timezone
+	loc, err := repo.ReportingLocation(clusterUUID)
+	if err != nil {
+		return report, err
+	}
+	filter, err := loadRowFilter(repo, clusterUUID)
+	if err != nil {
+		return report, err
+	}
+	touchedDates := make(map[time.Time]struct{})
+	batch := newStorageBatch()
+
+	err = eachRecord(reader, headers, report, func(line int, record []string) error {
+		namespace := record[headerIndices["namespace"]]
+		claimName := record[headerIndices["persistentvolumeclaim"]]
+		capacityStr := record[headerIndices["persistentvolumeclaim_capacity_bytes"]]
+		capacitySecondsStr := record[headerIndices["persistentvolumeclaim_capacity_byte_seconds"]]
+		requestStr := record[headerIndices["volume_request_storage_byte_seconds"]]
+		usageStr := record[headerIndices["persistentvolumeclaim_usage_byte_seconds"]]
+
+		if claimName == "" {
+			report.reject(line, ReasonMissingPersistentVolumeClaim, "empty persistentvolumeclaim", record)
+			return nil
+		}
+
+		span, rowErr := src.parseInterval(record, headerIndices)
+		if rowErr != nil {
+			report.reject(line, rowErr.Reason, rowErr.Message, record)
+			return nil
+		}
+		intervalStart := span.Start
+
+		capacity, err := strconv.ParseFloat(capacityStr, 64)
+		if err != nil {
+			report.reject(line, ReasonInvalidPVCCapacity, fmt.Sprintf("invalid persistentvolumeclaim_capacity_bytes %q", capacityStr), record)
+			return nil
+		}
+
+		capacitySeconds, err := strconv.ParseFloat(capacitySecondsStr, 64)
+		if err != nil {
+			report.reject(line, ReasonInvalidPVCCapacitySeconds, fmt.Sprintf("invalid persistentvolumeclaim_capacity_byte_seconds %q", capacitySecondsStr), record)
+			return nil
+		}
+
+		request, err := strconv.ParseFloat(requestStr, 64)
+		if err != nil {
+			log.Printf("Line %d: invalid volume_request_storage_byte_seconds %s: %v - setting to 0.0", line, requestStr, err)
+			request = 0.0
+		}
+
+		usage, err := strconv.ParseFloat(usageStr, 64)
+		if err != nil {
+			report.reject(line, ReasonInvalidPVCUsage, fmt.Sprintf("invalid persistentvolumeclaim_usage_byte_seconds %q", usageStr), record)
+			return nil
+		}
+
+		// Storage reports have no node role, so only cluster and namespace rules apply
+		if reason, message := filter.exclusion(namespace, ""); reason != "" {
+			report.skip(line, reason, message, record)
+			return nil
+		}
+
+		batch.add(db.PVCKey{
+			ClusterID:        clusterUUID,
+			Namespace:        namespace,
+			Name:             claimName,
+			PersistentVolume: record[headerIndices["persistentvolume"]],
+			StorageClass:     record[headerIndices["storageclass"]],
+		}, db.StorageMetricRow{
+			Timestamp:           intervalStart,
+			CapacityBytes:       int64(capacity),
+			CapacityByteSeconds: capacitySeconds,
+			RequestByteSeconds:  request,
+			UsageByteSeconds:    usage,
+			IntervalSeconds:     span.seconds(),
+		})
+		report.Accepted++
+		touchedDates[db.ReportingDate(intervalStart, loc)] = struct{}{}
+
+		if len(batch.metrics) >= batchSize {
+			return batch.flush(ctx, repo)
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	if err := batch.flush(ctx, repo); err != nil {
+		return report, err
+	}
+
+	// Rebuild daily summaries for every day touched by this file
+	if err := refreshTouchedDays(repo, clusterUUID, touchedDates, storageSummaries); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
@@ -0,0 +1,78 @@
+package processor
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const storageCSV = `report_period_start,report_period_end,interval_start,interval_end,namespace,pod,persistentvolumeclaim,persistentvolume,storageclass,persistentvolumeclaim_capacity_bytes,persistentvolumeclaim_capacity_byte_seconds,volume_request_storage_byte_seconds,persistentvolumeclaim_usage_byte_seconds,persistentvolume_labels,persistentvolumeclaim_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,test,db-0,data-db-0,pvc-1234,gp3,10737418240,38654705664000,38654705664000,19327352832000,,
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,test,db-1,data-db-0,pvc-1234,gp3,10737418240,38654705664000,38654705664000,19327352832000,,
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,test,db-0,data-db-0,pvc-1234,gp3,10737418240,38654705664000,38654705664000,19327352832000,,
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,2025-05-17 16:00:00 +0000 UTC,test,db-0,data-db-0,pvc-1234,gp3,bad,38654705664000,38654705664000,19327352832000,,`
+
+func TestProcessStorageCSV(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	ctx := context.Background()
+
+	report, err := ProcessStorageCSV(ctx, repo, csv.NewReader(strings.NewReader(storageCSV)), clusterID)
+	require.NoError(t, err)
+	assert.Equal(t, 3, report.Accepted)
+	assert.Equal(t, 1, report.Rejected)
+	assert.Equal(t, 1, report.Reasons[ReasonInvalidPVCCapacity])
+
+	// A claim mounted by two pods in the same hour is counted once
+	day := time.Date(2025, 5, 17, 0, 0, 0, 0, time.UTC)
+	summaries, total, err := repo.QueryStorageMetrics(day, day, clusterID, "", "test", "gp3", 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 1, total)
+	require.Len(t, summaries, 1)
+	assert.Equal(t, "data-db-0", summaries[0].PersistentVolumeClaim)
+	assert.Equal(t, "pvc-1234", summaries[0].PersistentVolume)
+	assert.Equal(t, 2.0, summaries[0].TotalHours)
+	assert.Equal(t, int64(10737418240), summaries[0].CapacityBytes)
+	assert.InDelta(t, 2*19327352832000.0, summaries[0].TotalUsageByteSeconds, 1)
+
+	// Filtering on another storage class finds nothing
+	_, total, err = repo.QueryStorageMetrics(day, day, clusterID, "", "", "standard", 100, 0)
+	require.NoError(t, err)
+	assert.Equal(t, 0, total)
+}
+
+func TestProcessTarDetectsStorageReport(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	manifest := newTestManifest(clusterID, "storage.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json": string(manifestJSON),
+		"storage.csv":   storageCSV,
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+	assert.Equal(t, ReportTypeStorage, result.Files[0].ReportType)
+	assert.Equal(t, 3, result.Files[0].Report.Accepted)
+
+	var count int
+	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM storage_metrics").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 2, count)
+}
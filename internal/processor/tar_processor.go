@@ -1,109 +1,229 @@
 package processor
 
 import (
-	"archive/tar"
-	"compress/gzip"
 	"context"
+	"crypto/sha256"
 	"encoding/csv"
-	"encoding/json"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"os"
 	"strings"
+	"time"
 
+	"github.com/chambridge/cost-metrics-aggregator/internal/components"
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
 	"github.com/google/uuid"
 )
 
-// Manifest represents the structure of manifest.json
-type Manifest struct {
-	ClusterID string   `json:"cluster_id"`
-	Files     []string `json:"files"`
-	CRStatus  struct {
-		ClusterID string `json:"clusterID"`
-		Source    struct {
-			Name string `json:"name"`
-		} `json:"source"`
-	} `json:"cr_status"`
+// Transaction scopes for ingesting an upload
+const (
+	TransactionScopeFile   = "file"
+	TransactionScopeUpload = "upload"
+)
+
+// Options controls how an upload is ingested
+type Options struct {
+	// TransactionScope is TransactionScopeFile (the default) or TransactionScopeUpload
+	TransactionScope string
+	// MinOperatorVersion rejects manifests from older operators when set
+	MinOperatorVersion string
+	// Limits caps the size and number of files an upload may expand to
+	Limits Limits
+	// TimestampFormats overrides the timestamp formats of report types by name
+	TimestampFormats map[string][]string
+	// HoursRounding is the db.HoursRounding rule for the hours of daily summaries
+	HoursRounding string
+	// ReportingLocation buckets samples into days for clusters without a timezone; nil is UTC
+	ReportingLocation *time.Location
+	// ComponentRules attribute pods to components; nil resolves them for each upload
+	// with db.Repository.ResolveComponentRules
+	ComponentRules *components.RuleSet
+}
+
+// UploadParams describe an upload that carries no manifest.json, such as a single CSV
+// or an archive from another exporter. Filename names a single CSV after the uploaded
+// file; ClusterID and ClusterName stand in for the manifest's cluster.
+type UploadParams struct {
+	Filename    string
+	ClusterID   string
+	ClusterName string
 }
 
-// ProcessTar processes a tar.gz archive, extracting manifest.json and valid CSVs
-func ProcessTar(ctx context.Context, tarPath string, repo *db.Repository) error {
-	file, err := os.Open(tarPath)
+// ProcessTar processes an operator archive; see ProcessUpload
+func ProcessTar(ctx context.Context, tarPath string, repo *db.Repository, opts Options) (*Result, error) {
+	return ProcessUpload(ctx, tarPath, repo, opts, UploadParams{})
+}
+
+// ProcessUpload processes an upload in any format recognized by DetectFormat, extracting
+// manifest.json and valid CSVs. Archives without a manifest, and single CSVs, are
+// described by params instead. An upload that breaks opts.Limits, or has an entry that
+// could escape the archive, is rejected with a *LimitError or *EntryError.
+// The manifest is validated first; an invalid manifest or one from an operator older
+// than opts.MinOperatorVersion rejects the whole upload.
+// Each CSV is dispatched to the processor of its registered report type; see DetectReportType.
+// Uploads whose manifest UUID was already ingested, and files whose checksum was
+// already ingested for the cluster, are reported as duplicates and not processed again.
+// Each CSV is written in its own transaction, or the whole upload in one transaction
+// when opts.TransactionScope is TransactionScopeUpload.
+func ProcessUpload(ctx context.Context, uploadPath string, repo *db.Repository, opts Options, params UploadParams) (*Result, error) {
+	repo = repo.WithHoursRounding(opts.HoursRounding).WithReportingLocation(opts.ReportingLocation)
+	format, err := DetectFormat(uploadPath)
 	if err != nil {
-		return fmt.Errorf("failed to open tar file: %w", err)
+		return nil, err
 	}
-	defer file.Close()
+	log.Printf("Processing %s upload", format)
+	csvName := csvUploadName(params.Filename)
 
-	gzr, err := gzip.NewReader(file)
+	archive, err := openArchive(uploadPath, format, csvName, opts.Limits)
 	if err != nil {
-		return fmt.Errorf("failed to create gzip reader: %w", err)
+		return nil, err
 	}
-	defer gzr.Close()
-
-	tr := tar.NewReader(gzr)
 	var manifest Manifest
 	manifestFound := false
+	checksums := make(map[string]string)
+	var csvFiles []string
 
 	for {
-		header, err := tr.Next()
+		filename, content, err := archive.next()
 		if err == io.EOF {
 			break
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
+			archive.close()
+			return nil, err
 		}
 
-		filename := header.Name
-		if strings.HasSuffix(filename, "manifest.json") {
-			data, err := io.ReadAll(tr)
+		if strings.HasSuffix(filename, ".csv") {
+			hash := sha256.New()
+			if _, err := io.Copy(hash, content); err != nil {
+				archive.close()
+				return nil, fmt.Errorf("failed to read %s: %w", filename, err)
+			}
+			checksums[filename] = hex.EncodeToString(hash.Sum(nil))
+			csvFiles = append(csvFiles, filename)
+			continue
+		}
+		// Only the manifest at the root of the archive describes the upload
+		if filename == "manifest.json" {
+			data, err := io.ReadAll(content)
 			if err != nil {
-				return fmt.Errorf("failed to read manifest.json: %w", err)
+				archive.close()
+				return nil, fmt.Errorf("failed to read manifest.json: %w", err)
 			}
-			if err := json.Unmarshal(data, &manifest); err != nil {
-				return fmt.Errorf("failed to parse manifest.json: %w", err)
+			manifest, err = parseManifest(data)
+			if err != nil {
+				archive.close()
+				return nil, err
 			}
 			manifestFound = true
 			log.Printf("Processed manifest.json: cluster_id=%s", manifest.ClusterID)
+		}
+	}
+	archive.close()
 
-			// Insert or update clusters using Repository
-			clusterID, err := uuid.Parse(manifest.ClusterID)
-			if err != nil {
-				return fmt.Errorf("invalid cluster_id %s: %w", manifest.ClusterID, err)
-			}
-			clusterName := manifest.ClusterID // Default to cluster_id
-			if manifest.CRStatus.Source.Name != "" {
-				clusterName = manifest.CRStatus.Source.Name
-			}
-			err = repo.UpsertCluster(clusterID, clusterName)
-			if err != nil {
-				return fmt.Errorf("failed to insert/update cluster %s: %w", clusterID, err)
-			}
-			log.Printf("Inserted/updated cluster: id=%s, name=%s", clusterID, clusterName)
+	result := &Result{Format: format}
+	if manifestFound {
+		// The manifest is kept with the result even when it is rejected, so uploads from
+		// unsupported operators can be audited
+		result.Manifest = &manifest
+		if err := manifest.Validate(opts.MinOperatorVersion); err != nil {
+			return result, err
+		}
+	} else {
+		manifest, err = manifestFromParams(format, params, csvFiles)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	// Insert or update clusters using Repository
+	clusterID := uuid.MustParse(manifest.ClusterID) // Validated above
+	clusterName := manifest.ClusterID               // Default to cluster_id
+	if manifest.CRStatus.Source.Name != "" {
+		clusterName = manifest.CRStatus.Source.Name
+	}
+	err = repo.UpsertCluster(clusterID, clusterName)
+	if err != nil {
+		return result, fmt.Errorf("failed to insert/update cluster %s: %w", clusterID, err)
+	}
+	log.Printf("Inserted/updated cluster: id=%s, name=%s, operator_version=%s", clusterID, clusterName, manifest.OperatorVersion)
+	result.ClusterID = manifest.ClusterID
+
+	// An upload whose manifest was already ingested is a retry of the same data
+	ingested := false
+	if manifestFound {
+		result.ManifestUUID = manifest.UUID
+		ingested, err = repo.ManifestIngested(uuid.MustParse(manifest.UUID))
+		if err != nil {
+			return result, fmt.Errorf("failed to check manifest %s: %w", manifest.UUID, err)
+		}
+	}
+	if ingested {
+		log.Printf("Skipping upload: manifest %s was already ingested", manifest.UUID)
+		for _, f := range manifest.Files {
+			result.Files = append(result.Files, FileResult{
+				Name:     f,
+				Status:   FileStatusDuplicate,
+				Checksum: checksums[f],
+				Error:    "manifest already ingested",
+			})
+		}
+		return result, nil
+	}
+
+	// Rules stored in the database are read per upload so that changes apply without a restart
+	if opts.ComponentRules == nil {
+		if opts.ComponentRules, err = repo.ResolveComponentRules(""); err != nil {
+			return result, err
 		}
 	}
 
-	if !manifestFound {
-		return fmt.Errorf("no manifest.json found in tar archive")
+	// Read the upload again to process its CSVs
+	archive, err = openArchive(uploadPath, format, csvName, opts.Limits)
+	if err != nil {
+		return result, err
+	}
+	defer archive.close()
+
+	if opts.TransactionScope == TransactionScopeUpload {
+		// The whole upload commits or rolls back as one; a failed file aborts the rest
+		err = repo.WithTx(ctx, func(tx *db.Repository) error {
+			return processEntries(ctx, archive, tx, manifest, clusterID, checksums, opts, result, true)
+		})
+		// Nothing written by the transaction is kept, so no file may be recorded as succeeded:
+		// its checksum would make a retry skip it as a duplicate
+		if errors.Is(err, errFileFailed) {
+			result.rollBack("another file in the upload failed")
+			return result, nil
+		}
+		if err != nil {
+			result.rollBack(err.Error())
+		}
+		return result, err
 	}
 
-	// Reset tar reader to process CSVs
-	file.Seek(0, io.SeekStart)
-	gzr.Reset(file)
-	tr = tar.NewReader(gzr)
+	return result, processEntries(ctx, archive, repo, manifest, clusterID, checksums, opts, result, false)
+}
+
+// errFileFailed stops an upload-scoped transaction after a file fails
+var errFileFailed = errors.New("file failed")
 
-	// Process only CSVs listed in manifest.files
+// processEntries processes the CSVs listed in manifest.files, recording each outcome
+// in result. When inUploadTx is set, files share the caller's transaction and the
+// first failure returns errFileFailed; otherwise each file gets its own transaction.
+func processEntries(ctx context.Context, archive archiveReader, repo *db.Repository, manifest Manifest,
+	clusterID uuid.UUID, checksums map[string]string, opts Options, result *Result, inUploadTx bool) error {
 	for {
-		header, err := tr.Next()
+		filename, content, err := archive.next()
 		if err == io.EOF {
-			break
+			return nil
 		}
 		if err != nil {
-			return fmt.Errorf("failed to read tar header: %w", err)
+			return err
 		}
 
-		filename := header.Name
 		if !strings.HasSuffix(filename, ".csv") {
 			continue
 		}
@@ -118,23 +238,139 @@ func ProcessTar(ctx context.Context, tarPath string, repo *db.Repository) error
 		}
 		if !isValidFile {
 			log.Printf("Skipping %s: not listed in manifest.files", filename)
+			result.Files = append(result.Files, FileResult{
+				Name:   filename,
+				Status: FileStatusSkipped,
+				Error:  "not listed in manifest.files",
+			})
 			continue
 		}
 
-		// Read CSV content
-		data, err := io.ReadAll(tr)
+		checksum := checksums[filename]
+		ingested, err := repo.FileIngested(clusterID, checksum)
 		if err != nil {
-			log.Printf("Failed to read %s: %v", filename, err)
+			return fmt.Errorf("failed to check checksum of %s: %w", filename, err)
+		}
+		if ingested {
+			log.Printf("Skipping %s: checksum %s was already ingested", filename, checksum)
+			result.Files = append(result.Files, FileResult{
+				Name:     filename,
+				Status:   FileStatusDuplicate,
+				Checksum: checksum,
+				Error:    "file already ingested",
+			})
 			continue
 		}
-		reader := csv.NewReader(strings.NewReader(string(data)))
-		log.Printf("Processing CSV file: %s", filename)
-		if err := ProcessCSV(ctx, repo, reader, manifest.ClusterID); err != nil {
+
+		// Stream CSV content straight from the archive. The header, or failing that the
+		// file name, decides which report the file holds.
+		reader := csv.NewReader(content)
+		headers, err := readHeader(reader)
+		if err != nil {
 			log.Printf("Failed to process %s: %v", filename, err)
+			result.Files = append(result.Files, FileResult{
+				Name:     filename,
+				Status:   FileStatusFailed,
+				Checksum: checksum,
+				Error:    err.Error(),
+			})
+			if inUploadTx {
+				return errFileFailed
+			}
+			continue
+		}
+
+		reportType := DetectReportType(filename, headers)
+		if reportType == nil {
+			log.Printf("Skipping %s: unrecognized report type", filename)
+			result.Files = append(result.Files, FileResult{
+				Name:     filename,
+				Status:   FileStatusUnrecognized,
+				Checksum: checksum,
+				Error:    "unrecognized report type: header matches no known report",
+			})
+			continue
+		}
+		if reportType.Process == nil {
+			log.Printf("Skipping %s: %s reports are not ingested", filename, reportType.Name)
+			result.Files = append(result.Files, FileResult{
+				Name:       filename,
+				ReportType: reportType.Name,
+				Status:     FileStatusSkipped,
+				Checksum:   checksum,
+				Error:      reportType.Name + " reports are not ingested",
+			})
+			continue
+		}
+
+		src := manifest.reportSource()
+		src.TimestampFormats = reportType.TimestampFormats
+		src.ComponentRules = opts.ComponentRules
+		if formats, ok := opts.TimestampFormats[reportType.Name]; ok {
+			src.TimestampFormats = formats
+		}
+
+		// Write the whole file or nothing
+		log.Printf("Processing %s report: %s", reportType.Name, filename)
+		report := &Report{}
+		err = repo.WithTx(ctx, func(tx *db.Repository) error {
+			var err error
+			report, err = reportType.Process(ctx, tx, reader, headers, src)
+			return err
+		})
+		if err != nil {
+			log.Printf("Failed to process %s, rolled back: %v", filename, err)
+			// Nothing from the file was stored, but its rejected rows still help diagnose it
+			report.Accepted = 0
+			result.Files = append(result.Files, FileResult{
+				Name:       filename,
+				ReportType: reportType.Name,
+				Status:     FileStatusFailed,
+				Checksum:   checksum,
+				Report:     *report,
+				Error:      err.Error(),
+			})
+			if inUploadTx {
+				return errFileFailed
+			}
 			continue
 		}
-		log.Printf("Successfully processed %s", filename)
+		log.Printf("Processed %s: %d accepted, %d rejected, %d skipped", filename, report.Accepted, report.Rejected, report.Skipped)
+		result.Files = append(result.Files, FileResult{
+			Name:       filename,
+			ReportType: reportType.Name,
+			Status:     FileStatusSucceeded,
+			Checksum:   checksum,
+			Report:     *report,
+		})
 	}
+}
 
-	return nil
+// manifestFromParams describes an upload without a manifest.json from its request
+// parameters; every CSV in the upload is processed
+func manifestFromParams(format string, params UploadParams, csvFiles []string) (Manifest, error) {
+	if params.ClusterID == "" {
+		switch format {
+		case FormatCSV, FormatCSVGz, FormatCSVZst:
+			return Manifest{}, fmt.Errorf("cluster_id is required for %s uploads", format)
+		case FormatZip:
+			return Manifest{}, fmt.Errorf("no manifest.json found in zip archive and no cluster_id given")
+		default:
+			return Manifest{}, fmt.Errorf("no manifest.json found in tar archive and no cluster_id given")
+		}
+	}
+	if _, err := uuid.Parse(params.ClusterID); err != nil {
+		return Manifest{}, fmt.Errorf("invalid cluster_id %s: %w", params.ClusterID, err)
+	}
+	if len(csvFiles) == 0 {
+		return Manifest{}, fmt.Errorf("no CSV files found in %s upload", format)
+	}
+	return Manifest{
+		ClusterID: params.ClusterID,
+		Files:     csvFiles,
+		CRStatus: CRStatus{
+			ClusterID: params.ClusterID,
+			Source:    CRStatusSource{Name: params.ClusterName},
+		},
+	}, nil
 }
@@ -5,9 +5,13 @@ import (
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"io"
 	"os"
 	"path/filepath"
+	"runtime"
+	"runtime/debug"
 	"testing"
+	"time"
 
 	"github.com/chambridge/cost-metrics-aggregator/internal/db"
 	"github.com/chambridge/cost-metrics-aggregator/internal/processor/testutils"
@@ -46,6 +50,11 @@ func createTarGz(t *testing.T, files map[string]string) string {
 	return tarPath
 }
 
+// newTestManifest returns a valid manifest for the given cluster and files
+func newTestManifest(clusterID string, files ...string) Manifest {
+	return Manifest{UUID: uuid.New().String(), ClusterID: clusterID, Version: "test", Files: files}
+}
+
 func TestProcessTar(t *testing.T) {
 	pool := testutils.SetupTestDB(t)
 	repo := db.NewRepository(pool)
@@ -55,22 +64,10 @@ func TestProcessTar(t *testing.T) {
 	defer os.Unsetenv("POD_LABEL_KEYS")
 
 	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
-	manifest := Manifest{
+	manifest := newTestManifest(clusterID, "data.csv")
+	manifest.CRStatus = CRStatus{
 		ClusterID: clusterID,
-		Files:     []string{"data.csv"},
-		CRStatus: struct {
-			ClusterID string `json:"clusterID"`
-			Source    struct {
-				Name string `json:"name"`
-			} `json:"source"`
-		}{
-			ClusterID: clusterID,
-			Source: struct {
-				Name string `json:"name"`
-			}{
-				Name: "test-cluster",
-			},
-		},
+		Source:    CRStatusSource{Name: "test-cluster"},
 	}
 	manifestJSON, _ := json.Marshal(manifest)
 
@@ -87,11 +84,15 @@ func TestProcessTar(t *testing.T) {
 	podName := "zip-1"
 	namespace := "test"
 	component := "EAP"
-	_, err := repo.UpsertPod(clusterUUID, nodeID, podName, namespace, component)
+	_, err := repo.UpsertPods(ctx, []db.PodKey{
+		{ClusterID: clusterUUID, NodeID: nodeID, Name: podName, Namespace: namespace, Component: component},
+	})
 	require.NoError(t, err)
 
-	err = ProcessTar(ctx, tarPath, repo)
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
 	assert.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
 
 	var clusterName string
 	err = pool.QueryRow(context.Background(), "SELECT name FROM clusters WHERE id = $1", clusterUUID).Scan(&clusterName)
@@ -111,7 +112,7 @@ func TestProcessTarMissingManifest(t *testing.T) {
 		"data.csv": csvData,
 	})
 
-	err := ProcessTar(ctx, tarPath, repo)
+	_, err := ProcessTar(ctx, tarPath, repo, Options{})
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "no manifest.json found in tar archive")
 }
@@ -122,25 +123,215 @@ func TestProcessTarInvalidCSV(t *testing.T) {
 	ctx := context.Background()
 
 	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
-	manifest := Manifest{
-		ClusterID: clusterID,
-		Files:     []string{"data.csv"},
-	}
+	manifest := newTestManifest(clusterID, "data.csv", "0_openshift_usage_report.0.csv")
 	manifestJSON, _ := json.Marshal(manifest)
 
 	invalidCSV := `invalid_header
 bad,data`
 
 	tarPath := createTarGz(t, map[string]string{
-		"manifest.json": string(manifestJSON),
-		"data.csv":      invalidCSV,
+		"manifest.json":                  string(manifestJSON),
+		"data.csv":                       invalidCSV,
+		"0_openshift_usage_report.0.csv": invalidCSV,
 	})
 
-	err := ProcessTar(ctx, tarPath, repo)
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
 	assert.NoError(t, err) // ProcessTar logs errors but continues
+	require.Len(t, result.Files, 2)
+	statuses := make(map[string]FileResult)
+	for _, f := range result.Files {
+		statuses[f.Name] = f
+	}
+	// Neither header nor name identify the report
+	assert.Equal(t, FileStatusUnrecognized, statuses["data.csv"].Status)
+	// The name identifies a pod usage report, so its missing headers fail the file
+	assert.Equal(t, FileStatusFailed, statuses["0_openshift_usage_report.0.csv"].Status)
+	assert.Equal(t, ReportTypePodUsage, statuses["0_openshift_usage_report.0.csv"].ReportType)
+	assert.Contains(t, statuses["0_openshift_usage_report.0.csv"].Error, "missing required header")
+	assert.Equal(t, 1, result.Failed())
 
 	var count int
 	err = pool.QueryRow(context.Background(), "SELECT COUNT(*) FROM pod_metrics").Scan(&count)
 	assert.NoError(t, err)
 	assert.Equal(t, 0, count, "No metrics should be inserted for invalid CSV")
 }
+
+func TestProcessTarDuplicateUpload(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	clusterUUID, _ := uuid.Parse(clusterID)
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web|label_rht_comp:EAP`
+
+	// recordUpload stores the processing result the way the ingestion worker does
+	recordUpload := func(result *Result) {
+		uploadID, err := repo.CreateUpload()
+		require.NoError(t, err)
+		upload := &db.Upload{ID: uploadID, Status: db.UploadStatusSucceeded, ClusterID: &clusterUUID}
+		if result.ManifestUUID != "" {
+			manifestUUID, _ := uuid.Parse(result.ManifestUUID)
+			upload.ManifestUUID = &manifestUUID
+		}
+		for _, f := range result.Files {
+			upload.Files = append(upload.Files, db.UploadFile{Name: f.Name, Status: f.Status, Checksum: f.Checksum})
+		}
+		require.NoError(t, repo.CompleteUpload(upload))
+	}
+
+	manifest := newTestManifest(clusterID, "data.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json": string(manifestJSON),
+		"data.csv":      csvData,
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+	assert.NotEmpty(t, result.Files[0].Checksum)
+	recordUpload(result)
+
+	// Same manifest UUID: the whole upload is a duplicate
+	result, err = ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusDuplicate, result.Files[0].Status)
+
+	// New manifest UUID but identical file content: the file is a duplicate
+	manifest.UUID = uuid.New().String()
+	manifestJSON, _ = json.Marshal(manifest)
+	tarPath = createTarGz(t, map[string]string{
+		"manifest.json": string(manifestJSON),
+		"data.csv":      csvData,
+	})
+	result, err = ProcessTar(ctx, tarPath, repo, Options{})
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusDuplicate, result.Files[0].Status)
+	assert.Equal(t, "file already ingested", result.Files[0].Error)
+}
+
+func TestProcessTarUploadScopeRollsBack(t *testing.T) {
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	os.Setenv("POD_LABEL_KEYS", "label_rht_comp")
+	defer os.Unsetenv("POD_LABEL_KEYS")
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	manifest := newTestManifest(clusterID, "good.csv", "bad_openshift_usage_report.csv")
+	manifestJSON, _ := json.Marshal(manifest)
+
+	csvData := `report_period_start,report_period_end,interval_start,interval_end,node,namespace,pod,pod_usage_cpu_core_seconds,pod_request_cpu_core_seconds,pod_limit_cpu_core_seconds,pod_usage_memory_byte_seconds,pod_request_memory_byte_seconds,pod_limit_memory_byte_seconds,node_capacity_cpu_cores,node_capacity_cpu_core_seconds,node_capacity_memory_bytes,node_capacity_memory_byte_seconds,node_role,resource_id,pod_labels
+2025-05-17 00:00:00 +0000 UTC,2025-05-17 23:59:59 +0000 UTC,2025-05-17 14:00:00 +0000 UTC,2025-05-17 15:00:00 +0000 UTC,ip-10-0-1-63.ec2.internal,test,zip-1,100,200,300,1000,2000,3000,4,14400,17179869184,61729433600,worker,i-09ad6102842b9a786,app:web|label_rht_comp:EAP`
+
+	tarPath := createTarGz(t, map[string]string{
+		"manifest.json":                  string(manifestJSON),
+		"good.csv":                       csvData,
+		"bad_openshift_usage_report.csv": "invalid_header\nbad,data",
+	})
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{TransactionScope: TransactionScopeUpload})
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, result.Failed(), 1)
+	for _, f := range result.Files {
+		assert.NotEqual(t, FileStatusSucceeded, f.Status, "%s should not be reported as stored", f.Name)
+	}
+
+	var count int
+	err = pool.QueryRow(ctx, "SELECT COUNT(*) FROM pod_metrics").Scan(&count)
+	require.NoError(t, err)
+	assert.Equal(t, 0, count, "No metrics should remain after the upload rolled back")
+}
+
+// createLargeTarGz writes a tar.gz with a manifest and a synthetic pod usage CSV of the given
+// number of rows as it is generated, so the test never holds the CSV in memory. It returns the
+// archive's path and the size of the CSV.
+func createLargeTarGz(t *testing.T, manifest Manifest, rows int) (string, int64) {
+	tarPath := filepath.Join(t.TempDir(), "large.tar.gz")
+	file, err := os.Create(tarPath)
+	require.NoError(t, err)
+	defer file.Close()
+
+	gzw := gzip.NewWriter(file)
+	tw := tar.NewWriter(gzw)
+
+	manifestJSON, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: "manifest.json", Mode: 0600, Size: int64(len(manifestJSON))}))
+	_, err = tw.Write(manifestJSON)
+	require.NoError(t, err)
+
+	input, size := syntheticCSV(rows)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: manifest.Files[0], Mode: 0600, Size: size}))
+	_, err = io.Copy(tw, input)
+	require.NoError(t, err)
+
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return tarPath, size
+}
+
+func TestProcessTarStreamsLargeUpload(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping large synthetic upload in short mode")
+	}
+
+	pool := testutils.SetupTestDB(t)
+	repo := db.NewRepository(pool)
+	ctx := context.Background()
+
+	clusterID := "10f5a0f9-223a-41c1-8456-9a3eb0323a99"
+	rows := 40 * batchSize
+	tarPath, csvSize := createLargeTarGz(t, newTestManifest(clusterID, "data.csv"), rows)
+
+	// Collect garbage eagerly so that the heap stays close to what processing holds on to
+	defer debug.SetGCPercent(debug.SetGCPercent(10))
+	runtime.GC()
+	var before runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	var peak uint64
+	done := make(chan struct{})
+	sampled := make(chan struct{})
+	go func() {
+		defer close(sampled)
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		var stats runtime.MemStats
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if stats.HeapAlloc > peak {
+					peak = stats.HeapAlloc
+				}
+			}
+		}
+	}()
+
+	result, err := ProcessTar(ctx, tarPath, repo, Options{})
+	close(done)
+	<-sampled
+	require.NoError(t, err)
+	require.Len(t, result.Files, 1)
+	assert.Equal(t, FileStatusSucceeded, result.Files[0].Status)
+	assert.Equal(t, rows, result.Files[0].Report.Accepted)
+
+	var written int
+	require.NoError(t, pool.QueryRow(ctx, "SELECT COUNT(*) FROM pod_metrics").Scan(&written))
+	assert.Equal(t, rows, written)
+
+	// The CSV is decompressed and written a batch at a time; buffering the file, or the
+	// rows parsed from it, would grow the heap by at least its size
+	growth := int64(peak) - int64(before.HeapAlloc)
+	assert.Less(t, growth, csvSize/4, "heap grew by %d bytes while processing a %d byte CSV", growth, csvSize)
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"testing"
 
 	"github.com/google/uuid"
@@ -27,7 +28,8 @@ func SetupTestDB(t *testing.T) *pgxpool.Pool {
 	})
 
 	_, err = tx.Exec(context.Background(), `
-		DROP TABLE IF EXISTS pod_daily_summary, pod_metrics, pods, node_daily_summary, node_metrics, nodes, clusters CASCADE
+		DROP TABLE IF EXISTS pending_node_summary_rebuilds, pod_placements, ingestion_rules, component_rules, upload_files, uploads, node_labels, namespace_labels, storage_daily_summary, storage_metrics, persistent_volume_claims,
+		pod_daily_summary, pod_metrics, pods, node_daily_summary, node_metrics, nodes, clusters CASCADE
 	`)
 	require.NoError(t, err)
 
@@ -37,11 +39,15 @@ func SetupTestDB(t *testing.T) *pgxpool.Pool {
 	}
 	currentDir := filepath.Dir(currentFile)
 
-	schemaPath := filepath.Join(currentDir, "..", "..", "db", "migrations", "0001_init.up.sql")
-	schema, err := os.ReadFile(schemaPath)
-	require.NoError(t, err)
-	_, err = tx.Exec(context.Background(), string(schema))
+	migrations, err := filepath.Glob(filepath.Join(currentDir, "..", "..", "db", "migrations", "*.up.sql"))
 	require.NoError(t, err)
+	sort.Strings(migrations)
+	for _, schemaPath := range migrations {
+		schema, err := os.ReadFile(schemaPath)
+		require.NoError(t, err)
+		_, err = tx.Exec(context.Background(), string(schema))
+		require.NoError(t, err)
+	}
 
 	clusterID, _ := uuid.Parse("10f5a0f9-223a-41c1-8456-9a3eb0323a99")
 	_, err = tx.Exec(context.Background(), `
@@ -60,6 +66,8 @@ func SetupTestDB(t *testing.T) *pgxpool.Pool {
 		CREATE TABLE node_metrics_202505 PARTITION OF node_metrics
 		FOR VALUES FROM ('2025-05-01') TO ('2025-06-01');
 		CREATE TABLE pod_metrics_202505 PARTITION OF pod_metrics
+		FOR VALUES FROM ('2025-05-01') TO ('2025-06-01');
+		CREATE TABLE storage_metrics_202505 PARTITION OF storage_metrics
 		FOR VALUES FROM ('2025-05-01') TO ('2025-06-01')
 	`)
 	require.NoError(t, err)
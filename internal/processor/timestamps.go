@@ -0,0 +1,175 @@
+package processor
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp formats accepted in the interval and report period columns of a report
+const (
+	// TimestampFormatOperator is written by the cost management operator, e.g. 2025-05-17 14:00:00 +0000 UTC
+	TimestampFormatOperator = "operator"
+	// TimestampFormatRFC3339 is RFC 3339 with optional fractional seconds, e.g. 2025-05-17T14:00:00Z
+	TimestampFormatRFC3339 = "rfc3339"
+	// TimestampFormatEpoch is seconds since the Unix epoch, optionally fractional
+	TimestampFormatEpoch = "epoch"
+)
+
+// DefaultTimestampFormats are tried in order for report types that configure none
+var DefaultTimestampFormats = []string{TimestampFormatOperator, TimestampFormatRFC3339, TimestampFormatEpoch}
+
+const operatorTimestampLayout = "2006-01-02 15:04:05 -0700 MST"
+
+// parseTimestamp parses value with the first of formats that accepts it, returning it in UTC
+func parseTimestamp(formats []string, value string) (time.Time, error) {
+	if len(formats) == 0 {
+		formats = DefaultTimestampFormats
+	}
+	value = strings.TrimSpace(value)
+	for _, format := range formats {
+		switch format {
+		case TimestampFormatOperator:
+			if t, err := time.Parse(operatorTimestampLayout, value); err == nil {
+				return t.UTC(), nil
+			}
+		case TimestampFormatRFC3339:
+			if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+				return t.UTC(), nil
+			}
+		case TimestampFormatEpoch:
+			if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 && !math.IsInf(seconds, 0) {
+				whole, frac := math.Modf(seconds)
+				return time.Unix(int64(whole), int64(frac*1e9)).UTC(), nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q matches none of the formats %s", value, strings.Join(formats, ", "))
+}
+
+// validateTimestampFormats fails on a format name parseTimestamp does not know
+func validateTimestampFormats(formats []string) error {
+	for _, format := range formats {
+		switch format {
+		case TimestampFormatOperator, TimestampFormatRFC3339, TimestampFormatEpoch:
+		default:
+			return fmt.Errorf("unknown timestamp format %q: must be %s, %s or %s",
+				format, TimestampFormatOperator, TimestampFormatRFC3339, TimestampFormatEpoch)
+		}
+	}
+	return nil
+}
+
+// ParseTimestampFormats parses per report type timestamp formats written as
+// "pod_usage=operator,epoch;storage=rfc3339". Report types must be registered.
+func ParseTimestampFormats(s string) (map[string][]string, error) {
+	result := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		name, list, ok := strings.Cut(entry, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid timestamp formats %q: expected report_type=format,...", entry)
+		}
+		name = strings.TrimSpace(name)
+		if findReportType(name) == nil {
+			return nil, fmt.Errorf("invalid timestamp formats %q: unknown report type %q", entry, name)
+		}
+		var formats []string
+		for _, format := range strings.Split(list, ",") {
+			if format = strings.TrimSpace(format); format != "" {
+				formats = append(formats, format)
+			}
+		}
+		if len(formats) == 0 {
+			return nil, fmt.Errorf("invalid timestamp formats %q: no formats given", entry)
+		}
+		if err := validateTimestampFormats(formats); err != nil {
+			return nil, err
+		}
+		result[name] = formats
+	}
+	return result, nil
+}
+
+// interval is the time span a report row covers
+type interval struct {
+	Start time.Time
+	End   time.Time
+}
+
+// seconds is the length of the interval
+func (i interval) seconds() float64 {
+	return i.End.Sub(i.Start).Seconds()
+}
+
+// exclusiveEnd turns an inclusive end written by the operator, such as 14:59:59, into
+// the whole minute it stands for
+func exclusiveEnd(t time.Time) time.Time {
+	if t.Second() == 59 && t.Nanosecond() == 0 {
+		return t.Add(time.Second)
+	}
+	return t
+}
+
+// rowError is the reason a row is rejected with
+type rowError struct {
+	Reason  string
+	Message string
+}
+
+// parseInterval reads a row's interval and report period and checks that the interval
+// ends after it starts, lies in the report period and starts in the manifest window.
+// An empty report period column leaves that side of the period open. Interval and
+// period ends are made exclusive with exclusiveEnd, so an hour written as 14:00:00 to
+// 14:59:59 lasts 3600 seconds.
+func (s ReportSource) parseInterval(record []string, headerIndices map[string]int) (interval, *rowError) {
+	field := func(name string) string {
+		if i, ok := headerIndices[name]; ok {
+			return record[i]
+		}
+		return ""
+	}
+
+	startStr := field("interval_start")
+	start, err := parseTimestamp(s.TimestampFormats, startStr)
+	if err != nil {
+		return interval{}, &rowError{ReasonInvalidIntervalStart, fmt.Sprintf("invalid interval_start %q", startStr)}
+	}
+	endStr := field("interval_end")
+	end, err := parseTimestamp(s.TimestampFormats, endStr)
+	if err != nil {
+		return interval{}, &rowError{ReasonInvalidIntervalEnd, fmt.Sprintf("invalid interval_end %q", endStr)}
+	}
+	end = exclusiveEnd(end)
+	if !end.After(start) {
+		return interval{}, &rowError{ReasonInvalidInterval, fmt.Sprintf("interval_end %s is not after interval_start %s",
+			end.Format(time.RFC3339), start.Format(time.RFC3339))}
+	}
+
+	var periodStart, periodEnd time.Time
+	if v := field("report_period_start"); strings.TrimSpace(v) != "" {
+		if periodStart, err = parseTimestamp(s.TimestampFormats, v); err != nil {
+			return interval{}, &rowError{ReasonInvalidReportPeriod, fmt.Sprintf("invalid report_period_start %q", v)}
+		}
+	}
+	if v := field("report_period_end"); strings.TrimSpace(v) != "" {
+		if periodEnd, err = parseTimestamp(s.TimestampFormats, v); err != nil {
+			return interval{}, &rowError{ReasonInvalidReportPeriod, fmt.Sprintf("invalid report_period_end %q", v)}
+		}
+		periodEnd = exclusiveEnd(periodEnd)
+	}
+	if (!periodStart.IsZero() && start.Before(periodStart)) || (!periodEnd.IsZero() && end.After(periodEnd)) {
+		return interval{}, &rowError{ReasonOutsideReportPeriod, fmt.Sprintf("interval %s to %s is outside the report period %s to %s",
+			start.Format(time.RFC3339), end.Format(time.RFC3339), periodStart.Format(time.RFC3339), periodEnd.Format(time.RFC3339))}
+	}
+
+	if err := s.checkWindow(start); err != nil {
+		return interval{}, &rowError{ReasonOutsideManifestWindow, err.Error()}
+	}
+	return interval{Start: start, End: end}, nil
+}
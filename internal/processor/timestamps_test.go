@@ -0,0 +1,121 @@
+package processor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	want := time.Date(2025, 5, 17, 14, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value   string
+		formats []string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "2025-05-17 14:00:00 +0000 UTC", want: want},
+		{value: "2025-05-17 16:00:00 +0200 CEST", want: want},
+		{value: "2025-05-17T14:00:00Z", want: want},
+		{value: "2025-05-17T10:00:00.000-04:00", want: want},
+		{value: "1747490400", want: want},
+		{value: " 1747490400.5 ", want: want.Add(500 * time.Millisecond)},
+		{value: "1747490400", formats: []string{TimestampFormatOperator, TimestampFormatRFC3339}, wantErr: true},
+		{value: "2025-05-17T14:00:00Z", formats: []string{TimestampFormatEpoch}, wantErr: true},
+		{value: "-1", wantErr: true},
+		{value: "", wantErr: true},
+		{value: "2025-05-17 14:00:00", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseTimestamp(tt.formats, tt.value)
+		if tt.wantErr {
+			assert.Error(t, err, tt.value)
+			continue
+		}
+		require.NoError(t, err, tt.value)
+		assert.True(t, tt.want.Equal(got), "%s parsed as %s", tt.value, got)
+		assert.Equal(t, time.UTC, got.Location())
+	}
+}
+
+func TestParseTimestampFormats(t *testing.T) {
+	got, err := ParseTimestampFormats(" pod_usage = operator, epoch ;storage=rfc3339;")
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]string{
+		ReportTypePodUsage: {TimestampFormatOperator, TimestampFormatEpoch},
+		ReportTypeStorage:  {TimestampFormatRFC3339},
+	}, got)
+
+	got, err = ParseTimestampFormats("")
+	require.NoError(t, err)
+	assert.Empty(t, got)
+
+	for _, bad := range []string{"pod_usage", "pod_usage=", "pod_usage=unix", "gpu_usage=epoch"} {
+		_, err := ParseTimestampFormats(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseInterval(t *testing.T) {
+	headerIndices := map[string]int{"report_period_start": 0, "report_period_end": 1, "interval_start": 2, "interval_end": 3}
+	src := ReportSource{
+		Start: time.Date(2025, 5, 17, 0, 0, 0, 0, time.UTC),
+		End:   time.Date(2025, 5, 18, 23, 59, 59, 0, time.UTC),
+	}
+	period := []string{"2025-05-01 00:00:00 +0000 UTC", "2025-05-17 23:59:59 +0000 UTC"}
+
+	tests := []struct {
+		name       string
+		record     []string
+		wantReason string
+	}{
+		{name: "valid", record: append(period, "2025-05-17 14:00:00 +0000 UTC", "2025-05-17 15:00:00 +0000 UTC")},
+		{name: "mixed formats", record: append(period, "2025-05-17T14:00:00Z", "1747494000")},
+		{name: "last hour of the period", record: append(period, "2025-05-17 23:00:00 +0000 UTC", "2025-05-18 00:00:00 +0000 UTC")},
+		{name: "open period", record: []string{"", "", "2025-05-18 14:00:00 +0000 UTC", "2025-05-18 15:00:00 +0000 UTC"}},
+		{name: "bad start", record: append(period, "yesterday", "2025-05-17 15:00:00 +0000 UTC"), wantReason: ReasonInvalidIntervalStart},
+		{name: "bad end", record: append(period, "2025-05-17 14:00:00 +0000 UTC", ""), wantReason: ReasonInvalidIntervalEnd},
+		{name: "end before start", record: append(period, "2025-05-17 14:00:00 +0000 UTC", "2025-05-17 13:00:00 +0000 UTC"), wantReason: ReasonInvalidInterval},
+		{name: "empty interval", record: append(period, "2025-05-17 14:00:00 +0000 UTC", "2025-05-17 14:00:00 +0000 UTC"), wantReason: ReasonInvalidInterval},
+		{name: "bad period", record: []string{"May", "", "2025-05-17 14:00:00 +0000 UTC", "2025-05-17 15:00:00 +0000 UTC"}, wantReason: ReasonInvalidReportPeriod},
+		{name: "after the period", record: append(period, "2025-05-18 00:00:00 +0000 UTC", "2025-05-18 01:00:00 +0000 UTC"), wantReason: ReasonOutsideReportPeriod},
+		{name: "before the period", record: []string{"2025-05-17 15:00:00 +0000 UTC", "", "2025-05-17 14:00:00 +0000 UTC", "2025-05-17 15:00:00 +0000 UTC"}, wantReason: ReasonOutsideReportPeriod},
+		{name: "outside the manifest window", record: []string{"", "", "2025-05-16 14:00:00 +0000 UTC", "2025-05-16 15:00:00 +0000 UTC"}, wantReason: ReasonOutsideManifestWindow},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			span, rowErr := src.parseInterval(tt.record, headerIndices)
+			if tt.wantReason == "" {
+				require.Nil(t, rowErr)
+				assert.True(t, span.End.After(span.Start))
+				return
+			}
+			require.NotNil(t, rowErr)
+			assert.Equal(t, tt.wantReason, rowErr.Reason)
+		})
+	}
+
+	// Interval lengths come from the interval, with the operator's inclusive ends made exclusive
+	for end, want := range map[string]float64{
+		"2025-05-17 14:59:59 +0000 UTC": 3600,
+		"2025-05-17 15:00:00 +0000 UTC": 3600,
+		"2025-05-17 14:15:00 +0000 UTC": 900,
+		"2025-05-17 14:14:59 +0000 UTC": 900,
+		"2025-05-17 14:10:30 +0000 UTC": 630,
+	} {
+		span, rowErr := src.parseInterval(append(period, "2025-05-17 14:00:00 +0000 UTC", end), headerIndices)
+		require.Nil(t, rowErr, end)
+		assert.Equal(t, want, span.seconds(), end)
+	}
+
+	// A report type limited to epoch timestamps rejects the operator format
+	epochOnly := ReportSource{TimestampFormats: []string{TimestampFormatEpoch}}
+	_, rowErr := epochOnly.parseInterval([]string{"", "", "2025-05-17 14:00:00 +0000 UTC", "1747494000"}, headerIndices)
+	require.NotNil(t, rowErr)
+	assert.Equal(t, ReasonInvalidIntervalStart, rowErr.Reason)
+}
@@ -0,0 +1,297 @@
+// Package selector parses Kubernetes label selectors such as "env=prod,team in (a,b),!canary".
+// Keys are matched against labels as the Cost Management Metrics Operator reports them, so
+// env selects label_env and app.kubernetes.io/part-of selects label_app_kubernetes_io_part_of.
+package selector
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Operator is the comparison a Requirement makes
+type Operator string
+
+// Selector operators
+const (
+	Equals       Operator = "="
+	NotEquals    Operator = "!="
+	In           Operator = "in"
+	NotIn        Operator = "notin"
+	Exists       Operator = "exists"
+	DoesNotExist Operator = "!"
+)
+
+// operatorKeyPrefix is the prefix of the label keys the operator reports
+const operatorKeyPrefix = "label_"
+
+// OperatorKey returns the key the operator reports a Kubernetes label key as: label_
+// followed by the key with every character other than a letter, digit or underscore
+// replaced by an underscore. Keys already starting with label_ are returned as they are.
+func OperatorKey(key string) string {
+	if strings.HasPrefix(key, operatorKeyPrefix) {
+		return key
+	}
+	return operatorKeyPrefix + strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' {
+			return r
+		}
+		return '_'
+	}, key)
+}
+
+// Requirement is a single condition on a label. Equals and NotEquals have one value, In and
+// NotIn one or more, and Exists and DoesNotExist none. As in Kubernetes, NotEquals and NotIn
+// also match labels that are missing.
+type Requirement struct {
+	// Key is the label key in the operator's form, see OperatorKey
+	Key      string
+	Operator Operator
+	Values   []string
+}
+
+// Selector is a set of requirements that must all match; an empty Selector matches everything
+type Selector []Requirement
+
+// Parse reads a selector in Kubernetes syntax: comma separated requirements of the forms
+// key=value, key==value, key!=value, key in (v1,v2), key notin (v1,v2), key and !key.
+// Keys are converted with OperatorKey.
+func Parse(s string) (Selector, error) {
+	p := &parser{input: s}
+	var sel Selector
+	if p.peek().kind == tokenEOF {
+		return sel, nil
+	}
+	for {
+		req, err := p.requirement()
+		if err != nil {
+			return nil, fmt.Errorf("invalid label selector %q: %w", s, err)
+		}
+		sel = append(sel, req)
+
+		switch t := p.next(); t.kind {
+		case tokenEOF:
+			return sel, nil
+		case tokenComma:
+		default:
+			return nil, fmt.Errorf("invalid label selector %q: expected , but found %q", s, t.text)
+		}
+	}
+}
+
+// Matches reports whether labels, keyed as the operator reports them, satisfy every requirement
+func (s Selector) Matches(labels map[string]string) bool {
+	for _, r := range s {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches reports whether labels satisfy the requirement
+func (r Requirement) Matches(labels map[string]string) bool {
+	value, ok := labels[r.Key]
+	switch r.Operator {
+	case Exists:
+		return ok
+	case DoesNotExist:
+		return !ok
+	case Equals, In:
+		return ok && contains(r.Values, value)
+	case NotEquals, NotIn:
+		return !ok || !contains(r.Values, value)
+	}
+	return false
+}
+
+// String formats the selector in the syntax Parse reads
+func (s Selector) String() string {
+	parts := make([]string, len(s))
+	for i, r := range s {
+		switch r.Operator {
+		case Exists:
+			parts[i] = r.Key
+		case DoesNotExist:
+			parts[i] = "!" + r.Key
+		case In, NotIn:
+			parts[i] = r.Key + " " + string(r.Operator) + " (" + strings.Join(r.Values, ",") + ")"
+		default:
+			parts[i] = r.Key + string(r.Operator) + r.Values[0]
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenName
+	tokenNot
+	tokenEquals
+	tokenNotEquals
+	tokenOpen
+	tokenClose
+	tokenComma
+	tokenInvalid
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+// parser is a recursive descent parser over the tokens of a selector
+type parser struct {
+	input  string
+	pos    int
+	peeked *token
+}
+
+func (p *parser) requirement() (Requirement, error) {
+	t := p.next()
+	if t.kind == tokenNot {
+		key, err := p.key()
+		if err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: OperatorKey(key), Operator: DoesNotExist}, nil
+	}
+	if t.kind != tokenName {
+		return Requirement{}, fmt.Errorf("expected a label key but found %q", t.text)
+	}
+	key := t.text
+
+	switch t := p.peek(); t.kind {
+	case tokenEOF, tokenComma:
+		return Requirement{Key: OperatorKey(key), Operator: Exists}, nil
+	case tokenEquals, tokenNotEquals:
+		p.next()
+		op := Equals
+		if t.kind == tokenNotEquals {
+			op = NotEquals
+		}
+		// An empty value, as in "key=", matches a label set to the empty string
+		value := ""
+		if p.peek().kind == tokenName {
+			value = p.next().text
+		}
+		return Requirement{Key: OperatorKey(key), Operator: op, Values: []string{value}}, nil
+	case tokenName:
+		if t.text != string(In) && t.text != string(NotIn) {
+			return Requirement{}, fmt.Errorf("expected an operator after %q but found %q", key, t.text)
+		}
+		p.next()
+		values, err := p.values()
+		if err != nil {
+			return Requirement{}, err
+		}
+		return Requirement{Key: OperatorKey(key), Operator: Operator(t.text), Values: values}, nil
+	default:
+		return Requirement{}, fmt.Errorf("expected an operator after %q but found %q", key, t.text)
+	}
+}
+
+func (p *parser) key() (string, error) {
+	t := p.next()
+	if t.kind != tokenName {
+		return "", fmt.Errorf("expected a label key but found %q", t.text)
+	}
+	return t.text, nil
+}
+
+// values reads a parenthesized, comma separated list of one or more values
+func (p *parser) values() ([]string, error) {
+	if t := p.next(); t.kind != tokenOpen {
+		return nil, fmt.Errorf("expected ( but found %q", t.text)
+	}
+	var values []string
+	for {
+		t := p.next()
+		if t.kind != tokenName {
+			return nil, fmt.Errorf("expected a value but found %q", t.text)
+		}
+		values = append(values, t.text)
+		switch t := p.next(); t.kind {
+		case tokenClose:
+			return values, nil
+		case tokenComma:
+		default:
+			return nil, fmt.Errorf("expected , or ) but found %q", t.text)
+		}
+	}
+}
+
+func (p *parser) peek() token {
+	if p.peeked == nil {
+		t := p.scan()
+		p.peeked = &t
+	}
+	return *p.peeked
+}
+
+func (p *parser) next() token {
+	t := p.peek()
+	p.peeked = nil
+	return t
+}
+
+// scan reads the next token, skipping whitespace
+func (p *parser) scan() token {
+	for p.pos < len(p.input) && isSpace(p.input[p.pos]) {
+		p.pos++
+	}
+	if p.pos >= len(p.input) {
+		return token{kind: tokenEOF, text: "end of selector"}
+	}
+
+	start := p.pos
+	c := p.input[p.pos]
+	p.pos++
+	switch c {
+	case ',':
+		return token{kind: tokenComma, text: ","}
+	case '(':
+		return token{kind: tokenOpen, text: "("}
+	case ')':
+		return token{kind: tokenClose, text: ")"}
+	case '=':
+		if p.pos < len(p.input) && p.input[p.pos] == '=' {
+			p.pos++
+		}
+		return token{kind: tokenEquals, text: p.input[start:p.pos]}
+	case '!':
+		if p.pos < len(p.input) && p.input[p.pos] == '=' {
+			p.pos++
+			return token{kind: tokenNotEquals, text: "!="}
+		}
+		return token{kind: tokenNot, text: "!"}
+	}
+	if !isNameChar(c) {
+		return token{kind: tokenInvalid, text: string(c)}
+	}
+	for p.pos < len(p.input) && isNameChar(p.input[p.pos]) {
+		p.pos++
+	}
+	return token{kind: tokenName, text: p.input[start:p.pos]}
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+// isNameChar reports whether c may appear in a label key or value, including the prefix
+// of a key such as app.kubernetes.io/name
+func isNameChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' ||
+		c == '-' || c == '_' || c == '.' || c == '/'
+}
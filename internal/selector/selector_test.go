@@ -0,0 +1,97 @@
+package selector
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input string
+		want  Selector
+	}{
+		{input: "", want: nil},
+		{input: "  ", want: nil},
+		{input: "env=prod", want: Selector{{Key: "label_env", Operator: Equals, Values: []string{"prod"}}}},
+		{input: "env==prod", want: Selector{{Key: "label_env", Operator: Equals, Values: []string{"prod"}}}},
+		{input: "env!=prod", want: Selector{{Key: "label_env", Operator: NotEquals, Values: []string{"prod"}}}},
+		{input: "env=", want: Selector{{Key: "label_env", Operator: Equals, Values: []string{""}}}},
+		{input: "team in (a, b)", want: Selector{{Key: "label_team", Operator: In, Values: []string{"a", "b"}}}},
+		{input: "team notin (a)", want: Selector{{Key: "label_team", Operator: NotIn, Values: []string{"a"}}}},
+		{input: "canary", want: Selector{{Key: "label_canary", Operator: Exists}}},
+		{input: "! canary", want: Selector{{Key: "label_canary", Operator: DoesNotExist}}},
+		{input: "label_env=prod", want: Selector{{Key: "label_env", Operator: Equals, Values: []string{"prod"}}}},
+		{input: "app.kubernetes.io/part-of=kafka", want: Selector{{Key: "label_app_kubernetes_io_part_of", Operator: Equals, Values: []string{"kafka"}}}},
+		{
+			input: "env=prod,team in (a,b),!canary",
+			want: Selector{
+				{Key: "label_env", Operator: Equals, Values: []string{"prod"}},
+				{Key: "label_team", Operator: In, Values: []string{"a", "b"}},
+				{Key: "label_canary", Operator: DoesNotExist},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			got, err := Parse(tt.input)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, bad := range []string{"=prod", "env=prod,", "env prod", "env in a", "env in ()", "env in (a,)", "env in (a", "!", "!env=prod", "env=prod=x", "env=*", "env,,team"} {
+		_, err := Parse(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestMatches(t *testing.T) {
+	labels := map[string]string{"label_env": "prod", "label_team": "a"}
+
+	tests := []struct {
+		selector string
+		want     bool
+	}{
+		{selector: "", want: true},
+		{selector: "env=prod", want: true},
+		{selector: "env=dev", want: false},
+		{selector: "env!=dev", want: true},
+		{selector: "tier!=web", want: true},
+		{selector: "team in (a,b)", want: true},
+		{selector: "tier in (web)", want: false},
+		{selector: "team notin (a,b)", want: false},
+		{selector: "tier notin (web)", want: true},
+		{selector: "team", want: true},
+		{selector: "!canary", want: true},
+		{selector: "!team", want: false},
+		{selector: "env=prod,team in (a,b),!canary", want: true},
+		{selector: "env=prod,team in (b)", want: false},
+		{selector: "label_env=prod", want: true},
+	}
+
+	for _, tt := range tests {
+		sel, err := Parse(tt.selector)
+		require.NoError(t, err, tt.selector)
+		assert.Equal(t, tt.want, sel.Matches(labels), tt.selector)
+	}
+}
+
+func TestString(t *testing.T) {
+	input := "label_env=prod,label_tier!=web,label_team in (a,b),label_region notin (us),label_canary,!label_legacy"
+	sel, err := Parse(input)
+	require.NoError(t, err)
+	assert.Equal(t, input, sel.String())
+
+	sel, err = Parse("env=prod,app.kubernetes.io/part-of in (kafka)")
+	require.NoError(t, err)
+	assert.Equal(t, "label_env=prod,label_app_kubernetes_io_part_of in (kafka)", sel.String())
+}
+
+func TestOperatorKey(t *testing.T) {
+	assert.Equal(t, "label_env", OperatorKey("env"))
+	assert.Equal(t, "label_app_kubernetes_io_part_of", OperatorKey("app.kubernetes.io/part-of"))
+	assert.Equal(t, "label_rht_comp", OperatorKey("label_rht_comp"))
+}
@@ -52,7 +52,26 @@ func createPodMetricPartitions(ctx context.Context, pool *pgxpool.Pool, date tim
 	return nil
 }
 
-// CreatePartitions creates daily partitions for the node_metrics and pod_metrics tables
+func createStorageMetricsPartition(ctx context.Context, pool *pgxpool.Pool, date time.Time) error {
+	year, month, day := date.Year(), int(date.Month()), date.Day()
+	partitionName := fmt.Sprintf("storage_metrics_y%d_m%d_d%d", year, month, day)
+	startDate := date
+	endDate := startDate.AddDate(0, 0, 1)
+	query := fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s
+		PARTITION OF storage_metrics
+		FOR VALUES FROM ('%s') TO ('%s');
+		CREATE INDEX IF NOT EXISTS %s_timestamp_idx ON %s (timestamp)`,
+		partitionName, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"), partitionName, partitionName)
+	_, err := pool.Exec(ctx, query)
+	if err != nil {
+		return fmt.Errorf("failed to create storage_metrics partition %s: %w", partitionName, err)
+	}
+	log.Printf("Created storage_metrics partition: %s", partitionName)
+	return nil
+}
+
+// CreatePartitions creates daily partitions for the node_metrics, pod_metrics and storage_metrics tables
 func main() {
 	var init bool
 	flag.BoolVar(&init, "init", false, "Initialize partitions for 90 days prior and current day")
@@ -84,6 +103,9 @@ func main() {
 		if err := createPodMetricPartitions(ctx, db, d); err != nil {
 			return
 		}
+		if err := createStorageMetricsPartition(ctx, db, d); err != nil {
+			return
+		}
 	}
 
 	if init {
@@ -96,6 +118,9 @@ func main() {
 			if err := createPodMetricPartitions(ctx, db, d); err != nil {
 				return
 			}
+			if err := createStorageMetricsPartition(ctx, db, d); err != nil {
+				return
+			}
 		}
 	}
 }
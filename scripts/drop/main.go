@@ -28,21 +28,15 @@ func main() {
 	daysInMonth := time.Date(year, month+1, 1, 0, 0, 0, 0, time.UTC).AddDate(0, 0, -1).Day()
 
 	for day := 1; day <= daysInMonth; day++ {
-		nodeMetricsPartitionName := fmt.Sprintf("node_metrics_y%d_m%d_d%d", year, month, day)
-		_, err := db.Exec(context.Background(), `DROP TABLE IF EXISTS %s`, nodeMetricsPartitionName)
-		if err != nil {
-			log.Printf("Failed to drop partition %s: %v", nodeMetricsPartitionName, err)
-			continue
+		for _, table := range []string{"node_metrics", "pod_metrics", "storage_metrics"} {
+			partitionName := fmt.Sprintf("%s_y%d_m%d_d%d", table, year, month, day)
+			_, err := db.Exec(context.Background(), fmt.Sprintf(`DROP TABLE IF EXISTS %s`, partitionName))
+			if err != nil {
+				log.Printf("Failed to drop partition %s: %v", partitionName, err)
+				continue
+			}
+			log.Printf("Dropped partition %s", partitionName)
 		}
-		log.Printf("Dropped partition %s", nodeMetricsPartitionName)
-
-		podMetricsPartitionName := fmt.Sprintf("pod_metrics_y%d_m%d_d%d", year, month, day)
-		_, err = db.Exec(context.Background(), `DROP TABLE IF EXISTS %s`, podMetricsPartitionName)
-		if err != nil {
-			log.Printf("Failed to drop partition %s: %v", podMetricsPartitionName, err)
-			continue
-		}
-		log.Printf("Dropped partition %s", podMetricsPartitionName)
 	}
 
 	log.Printf("Successfully dropped %d partitions for %d-%02d", daysInMonth, year, month)
@@ -5,7 +5,9 @@ import (
 	"compress/gzip"
 	"encoding/csv"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"time"
@@ -15,9 +17,15 @@ import (
 
 // Manifest represents the structure of manifest.json
 type Manifest struct {
-	ClusterID string   `json:"cluster_id"`
-	Files     []string `json:"files"`
-	CRStatus  struct {
+	UUID            string    `json:"uuid"`
+	ClusterID       string    `json:"cluster_id"`
+	Version         string    `json:"version"`
+	OperatorVersion string    `json:"operator_version"`
+	Date            time.Time `json:"date"`
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Files           []string  `json:"files"`
+	CRStatus        struct {
 		ClusterID string `json:"clusterID"`
 		Source    struct {
 			Name string `json:"name"`
@@ -25,8 +33,9 @@ type Manifest struct {
 	} `json:"cr_status"`
 }
 
-// generateCSV creates a CSV file with sample data for the given timestamp
-func generateCSV(filename string, clusterID string, startTime, endTime time.Time) error {
+// generateCSV creates a CSV file with sample data for every hour in the given range,
+// one row per pod on each node
+func generateCSV(filename string, clusterID string, startTime, endTime time.Time, nodes, pods int) error {
 	file, err := os.Create(filename)
 	if err != nil {
 		return fmt.Errorf("failed to create CSV file %s: %w", filename, err)
@@ -51,34 +60,38 @@ func generateCSV(filename string, clusterID string, startTime, endTime time.Time
 		return fmt.Errorf("failed to write CSV headers: %w", err)
 	}
 
-	// Generate sample data for each hour in the 24-hour period
+	// Generate sample data for each hour in the period
 	currentTime := startTime
 	for currentTime.Before(endTime) {
 		intervalEnd := currentTime.Add(time.Hour)
-		record := []string{
-			startTime.Format("2006-01-02 15:04:05 +0000 MST"),           // report_period_start
-			endTime.Format("2006-01-02 15:04:05 +0000 MST"),             // report_period_end
-			currentTime.Format("2006-01-02 15:04:05 +0000 MST"),         // interval_start
-			intervalEnd.Format("2006-01-02 15:04:05 +0000 MST"),         // interval_end
-			fmt.Sprintf("node-%s-1", clusterID[:8]),                     // node
-			"test-namespace",                                            // namespace
-			fmt.Sprintf("pod-%s-%d", clusterID[:8], currentTime.Hour()), // pod
-			"100.5",                               // pod_usage_cpu_core_seconds
-			"200.0",                               // pod_request_cpu_core_seconds
-			"300.0",                               // pod_limit_cpu_core_seconds
-			"1073741824",                          // pod_usage_memory_byte_seconds
-			"2147483648",                          // pod_request_memory_byte_seconds
-			"4294967296",                          // pod_limit_memory_byte_seconds
-			"4",                                   // node_capacity_cpu_cores
-			"14400",                               // node_capacity_cpu_core_seconds (4 cores * 3600 seconds)
-			"17179869184",                         // node_capacity_memory_bytes
-			"61728312345600",                      // node_capacity_memory_byte_seconds
-			"worker",                              // node_role
-			fmt.Sprintf("resource-%s", clusterID), // resource_id
-			"label_rht_comp:test-component|app:test-app", // pod_labels
-		}
-		if err := writer.Write(record); err != nil {
-			return fmt.Errorf("failed to write CSV record: %w", err)
+		for n := 1; n <= nodes; n++ {
+			for p := 1; p <= pods; p++ {
+				record := []string{
+					startTime.Format("2006-01-02 15:04:05 +0000 MST"),   // report_period_start
+					endTime.Format("2006-01-02 15:04:05 +0000 MST"),     // report_period_end
+					currentTime.Format("2006-01-02 15:04:05 +0000 MST"), // interval_start
+					intervalEnd.Format("2006-01-02 15:04:05 +0000 MST"), // interval_end
+					fmt.Sprintf("node-%s-%d", clusterID[:8], n),         // node
+					"test-namespace", // namespace
+					fmt.Sprintf("pod-%s-%d-%d", clusterID[:8], n, p), // pod
+					"100.5",          // pod_usage_cpu_core_seconds
+					"200.0",          // pod_request_cpu_core_seconds
+					"300.0",          // pod_limit_cpu_core_seconds
+					"1073741824",     // pod_usage_memory_byte_seconds
+					"2147483648",     // pod_request_memory_byte_seconds
+					"4294967296",     // pod_limit_memory_byte_seconds
+					"4",              // node_capacity_cpu_cores
+					"14400",          // node_capacity_cpu_core_seconds (4 cores * 3600 seconds)
+					"17179869184",    // node_capacity_memory_bytes
+					"61728312345600", // node_capacity_memory_byte_seconds
+					"worker",         // node_role
+					fmt.Sprintf("resource-%s-%d", clusterID, n),  // resource_id
+					"label_rht_comp:test-component|app:test-app", // pod_labels
+				}
+				if err := writer.Write(record); err != nil {
+					return fmt.Errorf("failed to write CSV record: %w", err)
+				}
+			}
 		}
 		currentTime = intervalEnd
 	}
@@ -86,7 +99,39 @@ func generateCSV(filename string, clusterID string, startTime, endTime time.Time
 	return nil
 }
 
+// addFileToTar streams a file from disk into the tar archive under the given name
+func addFileToTar(tw *tar.Writer, path, name string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	header, err := tar.FileInfoHeader(info, "")
+	if err != nil {
+		return fmt.Errorf("failed to create tar header for %s: %w", name, err)
+	}
+	header.Name = name
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write tar header for %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, f); err != nil {
+		return fmt.Errorf("failed to write %s to tar: %w", name, err)
+	}
+	return nil
+}
+
 func main() {
+	// Larger values produce synthetic uploads for checking ingestion memory use
+	days := flag.Int("days", 1, "Number of days of hourly data to generate")
+	nodes := flag.Int("nodes", 1, "Number of nodes per CSV file")
+	pods := flag.Int("pods", 1, "Number of pods per node per hour")
+	flag.Parse()
+
 	// Define output directory and file
 	outputDir := "test_upload"
 	outputFile := "test_upload.tar.gz"
@@ -95,11 +140,21 @@ func main() {
 		os.Exit(1)
 	}
 
+	// Generate time range for the previous days
+	endTime := time.Now().UTC().Truncate(time.Hour)
+	startTime := endTime.Add(-time.Duration(*days) * 24 * time.Hour)
+
 	// Generate manifest
 	clusterID := uuid.New().String()
 	manifest := Manifest{
-		ClusterID: clusterID,
-		Files:     []string{"data1.csv", "data2.csv"},
+		UUID:            uuid.New().String(),
+		ClusterID:       clusterID,
+		Version:         "generate-test-upload",
+		OperatorVersion: "3.3.0",
+		Date:            time.Now().UTC(),
+		Start:           startTime,
+		End:             endTime,
+		Files:           []string{"data1.csv", "data2.csv"},
 	}
 	manifest.CRStatus.ClusterID = clusterID
 	manifest.CRStatus.Source.Name = "test-cluster"
@@ -116,14 +171,10 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Generate time range for the previous 24 hours
-	endTime := time.Now().UTC().Truncate(time.Hour)
-	startTime := endTime.Add(-24 * time.Hour)
-
 	// Generate CSV files
 	for _, csvFile := range manifest.Files {
 		csvPath := filepath.Join(outputDir, csvFile)
-		if err := generateCSV(csvPath, clusterID, startTime, endTime); err != nil {
+		if err := generateCSV(csvPath, clusterID, startTime, endTime, *nodes, *pods); err != nil {
 			fmt.Fprintf(os.Stderr, "Failed to generate CSV %s: %v\n", csvFile, err)
 			os.Exit(1)
 		}
@@ -165,29 +216,8 @@ func main() {
 
 	// Add CSV files to tar
 	for _, csvFile := range manifest.Files {
-		csvPath := filepath.Join(outputDir, csvFile)
-		data, err := os.ReadFile(csvPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to read CSV %s: %v\n", csvFile, err)
-			os.Exit(1)
-		}
-		csvInfo, err := os.Stat(csvPath)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to stat CSV %s: %v\n", csvFile, err)
-			os.Exit(1)
-		}
-		header, err := tar.FileInfoHeader(csvInfo, "")
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to create tar header for %s: %v\n", csvFile, err)
-			os.Exit(1)
-		}
-		header.Name = csvFile
-		if err := tw.WriteHeader(header); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write tar header for %s: %v\n", csvFile, err)
-			os.Exit(1)
-		}
-		if _, err := tw.Write(data); err != nil {
-			fmt.Fprintf(os.Stderr, "Failed to write %s to tar: %v\n", csvFile, err)
+		if err := addFileToTar(tw, filepath.Join(outputDir, csvFile), csvFile); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
 			os.Exit(1)
 		}
 	}
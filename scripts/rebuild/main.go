@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/config"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Rebuild recomputes the daily summaries of a date range from the raw partitioned metrics,
+// e.g. after an ingestion fix or a configuration change
+func main() {
+	var startDate, endDate, cluster string
+	flag.StringVar(&startDate, "start", "", "First day to rebuild (YYYY-MM-DD, required)")
+	flag.StringVar(&endDate, "end", "", "Last day to rebuild (YYYY-MM-DD, defaults to -start)")
+	flag.StringVar(&cluster, "cluster", "", "Only rebuild the summaries of this cluster ID")
+	flag.Parse()
+
+	if startDate == "" {
+		log.Fatalf("-start is required")
+	}
+	start, err := time.Parse("2006-01-02", startDate)
+	if err != nil {
+		log.Fatalf("Invalid -start: %v", err)
+	}
+	end := start
+	if endDate != "" {
+		if end, err = time.Parse("2006-01-02", endDate); err != nil {
+			log.Fatalf("Invalid -end: %v", err)
+		}
+	}
+	clusterID := uuid.Nil
+	if cluster != "" {
+		if clusterID, err = uuid.Parse(cluster); err != nil {
+			log.Fatalf("Invalid -cluster: %v", err)
+		}
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	loc, err := time.LoadLocation(cfg.ReportingTimezone)
+	if err != nil {
+		log.Fatalf("Invalid REPORTING_TIMEZONE: %v", err)
+	}
+
+	pool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	repo := db.NewRepository(pool).WithHoursRounding(cfg.HoursRounding).WithReportingLocation(loc)
+	rules, err := repo.ResolveComponentRules(cfg.ComponentRulesFile)
+	if err != nil {
+		log.Fatalf("Failed to load component rules: %v", err)
+	}
+	result, err := repo.WithComponentRules(rules).RebuildDailySummaries(context.Background(), start, end, clusterID)
+	if err != nil {
+		log.Fatalf("Failed to rebuild summaries: %v", err)
+	}
+	log.Printf("Rebuilt summaries for %s to %s: %d pods re-attributed, %d node, %d pod and %d storage rows",
+		start.Format("2006-01-02"), end.Format("2006-01-02"), result.Pods, result.NodeSummaries, result.PodSummaries, result.StorageSummaries)
+}
@@ -12,5 +12,8 @@ psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d postgres -c "DROP DATABASE IF EXISTS
 # Create database
 psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d postgres -c "CREATE DATABASE $DB_NAME;"
 
-# Apply migrations
-psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d $DB_NAME -f internal/db/migrations/0001_init.up.sql
+# Apply every migration in order; the glob sorts by their numeric prefix
+for migration in internal/db/migrations/*.up.sql; do
+    echo "Applying $migration"
+    psql -h $DB_HOST -p $DB_PORT -U $DB_USER -d $DB_NAME -v ON_ERROR_STOP=1 -f "$migration"
+done
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"log"
+	"os"
+	"time"
+	_ "time/tzdata"
+
+	"github.com/chambridge/cost-metrics-aggregator/internal/config"
+	"github.com/chambridge/cost-metrics-aggregator/internal/db"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Verify compares the daily summaries of a date range with aggregates recomputed from the
+// raw partitioned metrics and reports every mismatch. It exits with status 1 when
+// mismatches are found and left unrepaired.
+func main() {
+	var startDate, endDate, cluster string
+	var days int
+	var repair bool
+	flag.StringVar(&startDate, "start", "", "First day to verify (YYYY-MM-DD, defaults to -days before -end)")
+	flag.StringVar(&endDate, "end", "", "Last day to verify (YYYY-MM-DD, defaults to today)")
+	flag.IntVar(&days, "days", 3, "Number of days to verify when -start is not set")
+	flag.StringVar(&cluster, "cluster", "", "Only verify the summaries of this cluster ID")
+	flag.BoolVar(&repair, "repair", false, "Rebuild the summaries of mismatched clusters and days")
+	flag.Parse()
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		log.Fatalf("Failed to load config: %v", err)
+	}
+	loc, err := time.LoadLocation(cfg.ReportingTimezone)
+	if err != nil {
+		log.Fatalf("Invalid REPORTING_TIMEZONE: %v", err)
+	}
+
+	end := db.ReportingDate(time.Now(), loc)
+	if endDate != "" {
+		if end, err = time.Parse("2006-01-02", endDate); err != nil {
+			log.Fatalf("Invalid -end: %v", err)
+		}
+	}
+	if days < 1 {
+		log.Fatalf("Invalid -days %d: must be at least 1", days)
+	}
+	start := end.AddDate(0, 0, 1-days)
+	if startDate != "" {
+		if start, err = time.Parse("2006-01-02", startDate); err != nil {
+			log.Fatalf("Invalid -start: %v", err)
+		}
+	}
+	clusterID := uuid.Nil
+	if cluster != "" {
+		if clusterID, err = uuid.Parse(cluster); err != nil {
+			log.Fatalf("Invalid -cluster: %v", err)
+		}
+	}
+
+	pool, err := pgxpool.New(context.Background(), cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Failed to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	repo := db.NewRepository(pool).WithHoursRounding(cfg.HoursRounding).WithReportingLocation(loc)
+	mismatches, err := repo.VerifyDailySummaries(context.Background(), start, end, clusterID, repair)
+	if err != nil {
+		log.Fatalf("Failed to verify summaries: %v", err)
+	}
+
+	for _, m := range mismatches {
+		log.Printf("Mismatch in %s_daily_summary for cluster %s on %s: %d of %d rows differ (%d expected)",
+			m.Summary, m.ClusterID, m.Date.Format("2006-01-02"), m.MismatchedRows, m.ActualRows, m.ExpectedRows)
+		for name, v := range m.Values {
+			if v.Difference != 0 {
+				log.Printf("  %s: stored %g, recomputed %g, difference %g", name, v.Actual, v.Expected, v.Difference)
+			}
+		}
+	}
+	switch {
+	case len(mismatches) == 0:
+		log.Printf("Summaries for %s to %s match the raw metrics", start.Format("2006-01-02"), end.Format("2006-01-02"))
+	case repair:
+		log.Printf("Repaired %d mismatched summaries for %s to %s", len(mismatches), start.Format("2006-01-02"), end.Format("2006-01-02"))
+	default:
+		log.Printf("Found %d mismatched summaries for %s to %s; run with -repair to rebuild them",
+			len(mismatches), start.Format("2006-01-02"), end.Format("2006-01-02"))
+		pool.Close()
+		os.Exit(1)
+	}
+}